@@ -0,0 +1,297 @@
+package mediawiki
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"gitlab.com/tozd/go/errors"
+)
+
+// nameRegex matches valid database names of wikis and names of tables.
+var nameRegex = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// Project is a Wikimedia project with wikis in many languages.
+// Its value is the suffix of database names of its wikis.
+type Project string
+
+const (
+	WikipediaProject   Project = "wiki"
+	WiktionaryProject  Project = "wiktionary"
+	WikibooksProject   Project = "wikibooks"
+	WikinewsProject    Project = "wikinews"
+	WikiquoteProject   Project = "wikiquote"
+	WikisourceProject  Project = "wikisource"
+	WikiversityProject Project = "wikiversity"
+	WikivoyageProject  Project = "wikivoyage"
+)
+
+// Wiki is the database name of a wiki (e.g., "enwiki" for English Wikipedia),
+// which is how wikis are named on the dumps site.
+type Wiki string
+
+const (
+	WikidataWiki Wiki = "wikidatawiki"
+	CommonsWiki  Wiki = "commonswiki"
+)
+
+// NewWiki returns the wiki of the project in the language (e.g., "en" or "zh-min-nan").
+func NewWiki(language string, project Project) Wiki {
+	return Wiki(strings.ReplaceAll(strings.ToLower(language), "-", "_") + string(project))
+}
+
+// DumpKind is a kind of dump.
+//
+// Wikidata truthy dumps are published only in RDF formats which Process cannot decode,
+// so they are not cataloged.
+type DumpKind string
+
+const (
+	// EnterpriseHTMLKind is Wikimedia Enterprise HTML dump of a namespace of any wiki.
+	EnterpriseHTMLKind DumpKind = "enterprise-html"
+	// EntitiesKind is entities JSON dump of Wikidata (all entities) or
+	// Wikimedia Commons (MediaInfo entities).
+	EntitiesKind DumpKind = "entities"
+	// LexemesKind is lexemes JSON dump of Wikidata.
+	LexemesKind DumpKind = "lexemes"
+	// PagesArticlesKind is pages-articles XML dump of any wiki.
+	PagesArticlesKind DumpKind = "pages-articles"
+	// PagesArticlesMultistreamKind is pages-articles-multistream XML dump of any wiki.
+	PagesArticlesMultistreamKind DumpKind = "pages-articles-multistream"
+	// PagesLoggingKind is pages-logging XML dump (log entries, e.g., page deletions) of any wiki.
+	PagesLoggingKind DumpKind = "pages-logging"
+	// PagesMetaCurrentKind is pages-meta-current XML dump of any wiki.
+	PagesMetaCurrentKind DumpKind = "pages-meta-current"
+	// StubMetaHistoryKind is stub-meta-history XML dump of any wiki.
+	StubMetaHistoryKind DumpKind = "stub-meta-history"
+	// StubMetaCurrentKind is stub-meta-current XML dump of any wiki.
+	StubMetaCurrentKind DumpKind = "stub-meta-current"
+	// TableKind is SQL dump of a table of any wiki.
+	TableKind DumpKind = "table"
+)
+
+// DumpFormat is the format of a dump file, named by its file extension.
+type DumpFormat string
+
+const (
+	JSONBZIP2Format   DumpFormat = "json.bz2"
+	JSONGZIPFormat    DumpFormat = "json.gz"
+	JSONTarGZIPFormat DumpFormat = "json.tar.gz"
+	XMLBZIP2Format    DumpFormat = "xml.bz2"
+	XMLGZIPFormat     DumpFormat = "xml.gz"
+	SQLGZIPFormat     DumpFormat = "sql.gz"
+)
+
+type dumpFormat struct {
+	fileType    FileType
+	compression Compression
+}
+
+//nolint:gochecknoglobals
+var dumpFormats = map[DumpFormat]dumpFormat{
+	JSONBZIP2Format:   {fileType: JSONArray, compression: BZIP2},
+	JSONGZIPFormat:    {fileType: JSONArray, compression: GZIP},
+	JSONTarGZIPFormat: {fileType: NDJSON, compression: GZIPTar},
+	XMLBZIP2Format:    {fileType: XML, compression: BZIP2},
+	XMLGZIPFormat:     {fileType: XML, compression: GZIP},
+	SQLGZIPFormat:     {fileType: SQLDump, compression: GZIP},
+}
+
+// DumpSpec identifies a dump in the catalog.
+//
+// Format is optional and defaults to the format in which the kind of dump
+// is published (for entities and lexemes dumps that is JSONBZIP2Format).
+// Namespace is used only with EnterpriseHTMLKind and Table only with TableKind.
+type DumpSpec struct {
+	Wiki      Wiki
+	Kind      DumpKind
+	Format    DumpFormat
+	Namespace int
+	Table     string
+}
+
+type catalogKind struct {
+	// wikis are the only wikis with the kind of dump, or nil if all wikis have it.
+	wikis []Wiki
+	// formats are formats in which the kind of dump is published, the first one is the default.
+	formats []DumpFormat
+	dump    func(spec DumpSpec) Dump
+}
+
+//nolint:gochecknoglobals
+var catalogKinds = map[DumpKind]catalogKind{
+	EnterpriseHTMLKind: {
+		wikis:   nil,
+		formats: []DumpFormat{JSONTarGZIPFormat},
+		dump: func(spec DumpSpec) Dump {
+			return enterpriseHTMLDump(spec.Wiki, spec.Namespace)
+		},
+	},
+	EntitiesKind: {
+		wikis:   []Wiki{WikidataWiki, CommonsWiki},
+		formats: []DumpFormat{JSONBZIP2Format, JSONGZIPFormat},
+		dump: func(spec DumpSpec) Dump {
+			if spec.Wiki == CommonsWiki {
+				return entitiesDump(spec.Wiki, "commons", "mediainfo", spec.Format)
+			}
+			return entitiesDump(spec.Wiki, "wikidata", "all", spec.Format)
+		},
+	},
+	LexemesKind: {
+		wikis:   []Wiki{WikidataWiki},
+		formats: []DumpFormat{JSONBZIP2Format, JSONGZIPFormat},
+		dump: func(spec DumpSpec) Dump {
+			return entitiesDump(spec.Wiki, "wikidata", "lexemes", spec.Format)
+		},
+	},
+	PagesArticlesKind: {
+		wikis:   nil,
+		formats: []DumpFormat{XMLBZIP2Format},
+		dump: func(spec DumpSpec) Dump {
+			return xmlDump(spec.Wiki, spec.Kind, spec.Format)
+		},
+	},
+	PagesArticlesMultistreamKind: {
+		wikis:   nil,
+		formats: []DumpFormat{XMLBZIP2Format},
+		dump: func(spec DumpSpec) Dump {
+			return xmlDump(spec.Wiki, spec.Kind, spec.Format)
+		},
+	},
+	PagesLoggingKind: {
+		wikis:   nil,
+		formats: []DumpFormat{XMLGZIPFormat},
+		dump: func(spec DumpSpec) Dump {
+			return xmlDump(spec.Wiki, spec.Kind, spec.Format)
+		},
+	},
+	PagesMetaCurrentKind: {
+		wikis:   nil,
+		formats: []DumpFormat{XMLBZIP2Format},
+		dump: func(spec DumpSpec) Dump {
+			return xmlDump(spec.Wiki, spec.Kind, spec.Format)
+		},
+	},
+	StubMetaHistoryKind: {
+		wikis:   nil,
+		formats: []DumpFormat{XMLGZIPFormat},
+		dump: func(spec DumpSpec) Dump {
+			return xmlDump(spec.Wiki, spec.Kind, spec.Format)
+		},
+	},
+	StubMetaCurrentKind: {
+		wikis:   nil,
+		formats: []DumpFormat{XMLGZIPFormat},
+		dump: func(spec DumpSpec) Dump {
+			return xmlDump(spec.Wiki, spec.Kind, spec.Format)
+		},
+	},
+	TableKind: {
+		wikis:   nil,
+		formats: []DumpFormat{SQLGZIPFormat},
+		dump: func(spec DumpSpec) Dump {
+			return tableDump(spec.Wiki, spec.Table)
+		},
+	},
+}
+
+// CatalogDump returns the dump identified by spec.
+//
+// It returns ErrInvalidValue if the wiki does not have the kind of dump
+// or the dump is not published in the format.
+func CatalogDump(spec DumpSpec) (Dump, errors.E) {
+	kind, ok := catalogKinds[spec.Kind]
+	if !ok {
+		return Dump{}, errors.WithDetails(ErrInvalidValue, "kind", spec.Kind)
+	}
+	if !nameRegex.MatchString(string(spec.Wiki)) {
+		return Dump{}, errors.WithDetails(ErrInvalidValue, "wiki", spec.Wiki)
+	}
+	if kind.wikis != nil && !slices.Contains(kind.wikis, spec.Wiki) {
+		return Dump{}, errors.WithDetails(ErrInvalidValue, "kind", spec.Kind, "wiki", spec.Wiki)
+	}
+	if spec.Format == "" {
+		spec.Format = kind.formats[0]
+	} else if !slices.Contains(kind.formats, spec.Format) {
+		return Dump{}, errors.WithDetails(ErrInvalidValue, "kind", spec.Kind, "format", spec.Format)
+	}
+	if spec.Kind == TableKind && !nameRegex.MatchString(spec.Table) {
+		return Dump{}, errors.WithDetails(ErrInvalidValue, "table", spec.Table)
+	}
+	return kind.dump(spec), nil
+}
+
+func enterpriseHTMLDump(wiki Wiki, namespace int) Dump {
+	return newListingDump(
+		dumpsBaseURL+"/other/enterprise_html/runs/",
+		fmt.Sprintf("%s/other/enterprise_html/runs/%%s/%s-NS%d-%%s-ENTERPRISE-HTML.%s", dumpsBaseURL, wiki, namespace, JSONTarGZIPFormat),
+		JSONTarGZIPFormat,
+	)
+}
+
+// entitiesDump returns entities dump of the wiki, with files named "<prefix>-<date>-<name>.<format>".
+func entitiesDump(wiki Wiki, prefix, name string, format DumpFormat) Dump {
+	return newListingDump(
+		fmt.Sprintf("%s/%s/entities/", dumpsBaseURL, wiki),
+		fmt.Sprintf("%s/%s/entities/%%s/%s-%%s-%s.%s", dumpsBaseURL, wiki, prefix, name, format),
+		format,
+	)
+}
+
+func xmlDump(wiki Wiki, kind DumpKind, format DumpFormat) Dump {
+	return newStatusDump(dumpsBaseURL, string(wiki), fmt.Sprintf("%%s-%%s-%s.%s", kind, format), format)
+}
+
+func tableDump(wiki Wiki, table string) Dump {
+	return newStatusDump(dumpsBaseURL, string(wiki), fmt.Sprintf("%%s-%%s-%s.%s", table, SQLGZIPFormat), SQLGZIPFormat)
+}
+
+// fileExists returns true if path is set and the file at path exists.
+func fileExists(path string) bool {
+	if path == "" {
+		return false
+	}
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// ProcessDump downloads (unless already saved), decompresses, decodes,
+// and calls processItem on every item in the dump, based on its FileType and Compression.
+//
+// If URL is not set in config and the file at Path (if set) does not already exist,
+// the latest run of the dump is processed. Use the Run method of the dump to obtain URL
+// of a run from a specific date.
+func ProcessDump[T any](
+	ctx context.Context, dump Dump, config *ProcessDumpConfig,
+	processItem func(context.Context, T) errors.E,
+) errors.E {
+	url := config.URL
+	if url == "" && !fileExists(config.Path) {
+		run, errE := dump.Latest(ctx, config.Client)
+		if errE != nil {
+			return errE
+		}
+		url = run.URL
+	}
+	return Process(ctx, &ProcessConfig[T]{
+		URL:                    url,
+		Path:                   config.Path,
+		Client:                 config.Client,
+		DecompressionThreads:   config.DecompressionThreads,
+		DecodingThreads:        config.DecodingThreads,
+		ItemsProcessingThreads: config.ItemsProcessingThreads,
+		Process:                processItem,
+		Progress:               config.Progress,
+		Shard:                  config.Shard,
+		Shards:                 config.Shards,
+		Logger:                 config.Logger,
+		Observer:               config.Observer,
+		Checksum:               config.Checksum,
+		CheckpointConfig:       config.CheckpointConfig,
+		FileType:               dump.FileType(),
+		Compression:            dump.Compression(),
+	})
+}
@@ -0,0 +1,145 @@
+package mediawiki_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-retryablehttp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/go/errors"
+
+	"github.com/citadel2024/go-mediawiki"
+)
+
+func TestNewWiki(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, mediawiki.Wiki("enwiki"), mediawiki.NewWiki("en", mediawiki.WikipediaProject))
+	assert.Equal(t, mediawiki.Wiki("dewiktionary"), mediawiki.NewWiki("de", mediawiki.WiktionaryProject))
+	assert.Equal(t, mediawiki.Wiki("zh_min_nanwikisource"), mediawiki.NewWiki("zh-min-nan", mediawiki.WikisourceProject))
+}
+
+func TestCatalogDump(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		Spec        mediawiki.DumpSpec
+		Format      mediawiki.DumpFormat
+		FileType    mediawiki.FileType
+		Compression mediawiki.Compression
+	}{
+		{
+			mediawiki.DumpSpec{Wiki: mediawiki.WikidataWiki, Kind: mediawiki.EntitiesKind},
+			mediawiki.JSONBZIP2Format, mediawiki.JSONArray, mediawiki.BZIP2,
+		},
+		{
+			mediawiki.DumpSpec{Wiki: mediawiki.WikidataWiki, Kind: mediawiki.EntitiesKind, Format: mediawiki.JSONGZIPFormat},
+			mediawiki.JSONGZIPFormat, mediawiki.JSONArray, mediawiki.GZIP,
+		},
+		{
+			mediawiki.DumpSpec{Wiki: mediawiki.CommonsWiki, Kind: mediawiki.EntitiesKind},
+			mediawiki.JSONBZIP2Format, mediawiki.JSONArray, mediawiki.BZIP2,
+		},
+		{
+			mediawiki.DumpSpec{Wiki: mediawiki.WikidataWiki, Kind: mediawiki.LexemesKind, Format: mediawiki.JSONGZIPFormat},
+			mediawiki.JSONGZIPFormat, mediawiki.JSONArray, mediawiki.GZIP,
+		},
+		{
+			mediawiki.DumpSpec{Wiki: mediawiki.NewWiki("en", mediawiki.WikivoyageProject), Kind: mediawiki.EnterpriseHTMLKind, Namespace: 14},
+			mediawiki.JSONTarGZIPFormat, mediawiki.NDJSON, mediawiki.GZIPTar,
+		},
+		{
+			mediawiki.DumpSpec{Wiki: mediawiki.NewWiki("fr", mediawiki.WiktionaryProject), Kind: mediawiki.PagesMetaCurrentKind},
+			mediawiki.XMLBZIP2Format, mediawiki.XML, mediawiki.BZIP2,
+		},
+		{
+			mediawiki.DumpSpec{Wiki: mediawiki.NewWiki("en", mediawiki.WikisourceProject), Kind: mediawiki.StubMetaCurrentKind},
+			mediawiki.XMLGZIPFormat, mediawiki.XML, mediawiki.GZIP,
+		},
+		{
+			mediawiki.DumpSpec{Wiki: mediawiki.WikidataWiki, Kind: mediawiki.PagesLoggingKind},
+			mediawiki.XMLGZIPFormat, mediawiki.XML, mediawiki.GZIP,
+		},
+		{
+			mediawiki.DumpSpec{Wiki: mediawiki.NewWiki("sl", mediawiki.WikipediaProject), Kind: mediawiki.TableKind, Table: "templatelinks"},
+			mediawiki.SQLGZIPFormat, mediawiki.SQLDump, mediawiki.GZIP,
+		},
+	} {
+		t.Run(fmt.Sprintf("%s/%s", tt.Spec.Wiki, tt.Spec.Kind), func(t *testing.T) {
+			t.Parallel()
+
+			dump, errE := mediawiki.CatalogDump(tt.Spec)
+			require.NoError(t, errE, "% -+#.1v", errE)
+			assert.Equal(t, tt.Format, dump.Format())
+			assert.Equal(t, tt.FileType, dump.FileType())
+			assert.Equal(t, tt.Compression, dump.Compression())
+		})
+	}
+
+	for _, spec := range []mediawiki.DumpSpec{
+		{Wiki: mediawiki.WikidataWiki, Kind: "unknown"},
+		{Wiki: "", Kind: mediawiki.PagesArticlesKind},
+		{Wiki: "enwiki", Kind: mediawiki.EntitiesKind},
+		{Wiki: mediawiki.CommonsWiki, Kind: mediawiki.LexemesKind},
+		{Wiki: mediawiki.WikidataWiki, Kind: mediawiki.EntitiesKind, Format: mediawiki.XMLBZIP2Format},
+		{Wiki: "enwiki", Kind: mediawiki.TableKind},
+	} {
+		_, errE := mediawiki.CatalogDump(spec)
+		assert.ErrorIs(t, errE, mediawiki.ErrInvalidValue)
+	}
+}
+
+func TestProcessCatalogDump(t *testing.T) {
+	t.Parallel()
+
+	// A local directory laid out like the dumps site.
+	dir := t.TempDir()
+	wiki := mediawiki.NewWiki("en", mediawiki.WiktionaryProject)
+	name := fmt.Sprintf("%s-20240901-pages-articles-multistream.xml.bz2", wiki)
+	writeMirrorFile(t, dir, fmt.Sprintf("%s/20240901/dumpstatus.json", wiki), fmt.Sprintf(
+		`{"jobs":{"articlesmultistreamdump":{"status":"done","files":{"%s":{"url":"/%s/20240901/%s"}}}},"version":"0.8"}`,
+		name, wiki, name,
+	))
+	data, err := os.ReadFile("testdata/enwiki-testdata-pages-articles-multistream.xml.bz2")
+	require.NoError(t, err)
+	writeMirrorFile(t, dir, fmt.Sprintf("%s/20240901/%s", wiki, name), string(data))
+
+	client := retryablehttp.NewClient()
+	client.RetryMax = 0
+	client.Logger = nil
+	errE := mediawiki.UseMirrors(client, &mediawiki.MirrorConfig{
+		Mirrors:   []string{"file://" + filepath.ToSlash(dir)},
+		Transport: nil,
+		Logger:    nil,
+	})
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	dump, errE := mediawiki.CatalogDump(mediawiki.DumpSpec{Wiki: wiki, Kind: mediawiki.PagesArticlesMultistreamKind})
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	run, errE := dump.Latest(context.Background(), client)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, mediawiki.DumpRun{
+		Date: time.Date(2024, 9, 1, 0, 0, 0, 0, time.UTC),
+		URL:  "https://dumps.wikimedia.org/enwiktionary/20240901/enwiktionary-20240901-pages-articles-multistream.xml.bz2",
+	}, run)
+
+	var pages atomic.Int64
+	errE = mediawiki.ProcessDump(context.Background(), dump, &mediawiki.ProcessDumpConfig{
+		Client:                 client,
+		Path:                   filepath.Join(t.TempDir(), name),
+		ItemsProcessingThreads: 1,
+		CheckpointConfig:       testCheckpointConfig(),
+	}, func(_ context.Context, _ mediawiki.Page) errors.E {
+		pages.Add(1)
+		return nil
+	})
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, int64(10), pages.Load())
+}
@@ -1,12 +1,11 @@
 package mediawiki
 
 import (
-	"encoding/json"
-	"fmt"
-	"github.com/pkg/errors"
-	"os"
+	"log/slog"
 	"sync"
 	"time"
+
+	"gitlab.com/tozd/go/errors"
 )
 
 const (
@@ -18,28 +17,43 @@ const (
 // CheckpointConfig represents the configuration for the CheckpointManager
 // SaveInterval is the interval to save the checkpoint to the checkpoint file.
 // Due to saving interval, the program may lose some items if it crashes before saving the checkpoint.
+// Store is where the checkpoint is stored (see FileCheckpointStore, MemoryCheckpointStore, and
+// NewKeyValueCheckpointStore). If it is nil, the checkpoint is stored in CheckpointFile.
+// When processing in shards, each shard needs its own Store.
+// Process binds the checkpoint to the dump it processes (see DumpIdentity). If the checkpoint
+// belongs to a different dump, Process fails with ErrCheckpointMismatch, or, if ResetOnMismatch
+// is true, starts from the beginning and replaces the checkpoint. If the stored checkpoint is
+// changed by somebody else during processing, Process stops with ErrCheckpointConflict.
+// Logger is used for diagnostic events. If it is nil, nothing is logged.
 type CheckpointConfig struct {
-	SaveInterval   time.Duration
-	ItemsThreshold int
-	CheckpointFile string
+	SaveInterval    time.Duration
+	ItemsThreshold  int
+	CheckpointFile  string
+	Store           CheckpointStore
+	ResetOnMismatch bool
+	Logger          *slog.Logger
 }
 
 // Checkpoint represents the checkpoint data
-// TotalItems is the total number of items we send into channel, we will increase this number based on the previous checkpoint.
-// ProcessedPosition is the current position of the item we are processing, however, for multiple-consumer program, ProcessedPosition does not
-// mean the items before ProcessedPosition were processed, because the items in the channel are not processed in order.
-// Some items may be processed, the others may not.
-// Hence, next time we start the program, to make sure the items in the channel were processed correctly,
-// we need to retry these items in the channel, so we need to start from the beginning of the channel (ProcessedPosition - len(goroutines)),
-// whatever the position is the first or the last item in the channel, we can make sure all items in the channel were processed.
-// TotalItems >= ProcessedPosition - len(goroutines)
-// The producer is single-threaded, so the rows channel is FIFO.
-// Bitset is also a good choice to track the processed items, but it is not necessary. We only need a checkpoint.
+// TotalItems is the total number of items processed, we will increase this number based on the previous checkpoint.
+// ProcessedPosition is the low watermark: the highest line number (row) for which this row and all rows before it
+// have been completely processed. Items are processed by multiple goroutines and not in order, so rows after
+// ProcessedPosition may have been processed as well, but nothing before or at ProcessedPosition is missing.
+// Hence, next time we start the program, we continue with the row after ProcessedPosition, which guarantees
+// at-least-once processing for any thread configuration (some items after ProcessedPosition may be processed twice).
+// Offset is the byte offset in the decompressed file (or in the tar archive member) just after the row at
+// ProcessedPosition, if known, so that resuming can continue reading at that offset instead of reading and
+// skipping all rows before it. For compressed files and tar archives, Restart is where in the file reading
+// has to restart to get to that offset (see Restart).
+// Dump identifies the dump the checkpoint belongs to.
 type Checkpoint struct {
-	TotalItems        int       `json:"total_items"`
-	SaveTimestamp     time.Time `json:"timestamp"`
-	LastItemID        string    `json:"last_item_id"` // This field is unused, user can just use TotalItems to skip items already processed
-	ProcessedPosition int       `json:"position"`
+	TotalItems        int           `json:"total_items"`
+	SaveTimestamp     time.Time     `json:"timestamp"`
+	LastItemID        string        `json:"last_item_id"` // This field is unused, user can just use TotalItems to skip items already processed
+	ProcessedPosition int           `json:"position"`
+	Offset            int64         `json:"offset,omitempty"`
+	Restart           *Restart      `json:"restart,omitempty"`
+	Dump              *DumpIdentity `json:"dump,omitempty"`
 	//LastProcessedThreadCount int `json:"last_processed_thread_count"` we can store the number of goroutines in the last run
 }
 
@@ -49,6 +63,26 @@ type CheckpointManager struct {
 	itemsSinceLastCheckpoint int
 	mu                       sync.Mutex
 	dirty                    bool
+	// startedRows are line numbers of started rows after ProcessedPosition, in order.
+	startedRows []int
+	// rows contains state of every started row.
+	rows map[int]*startedRow
+	// store is initialized lazily by getStore.
+	store CheckpointStore
+	// savedCheckpoint is the checkpoint loaded or saved last, or nil if there is none.
+	savedCheckpoint *Checkpoint
+	// stop is closed by Close to stop autoSave.
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+type startedRow struct {
+	// remaining is the number of items not yet completed or -1 if the number of items of the row is not yet known.
+	remaining int
+	// offset is the byte offset just after the row or -1 if it is not known.
+	offset int64
+	// restart is where reading restarts to get to offset, if it is needed.
+	restart *Restart
 }
 
 // NewCheckpointManager creates a new CheckpointManager
@@ -58,38 +92,39 @@ type CheckpointManager struct {
 // 1. Recover from the last checkpoint, and skip items already processed.
 // 2. The program should be able to handle the case when the checkpoint file is missing.
 // 3. You may need to handle duplicate items if the program crashes after processing an item but before saving the checkpoint.
+// It panics if the checkpoint cannot be loaded (see LoadCheckpointManager).
 func NewCheckpointManager() *CheckpointManager {
-	cm := &CheckpointManager{
-		config: &CheckpointConfig{
-			SaveInterval:   saveInterval,
-			ItemsThreshold: itemsThreshold,
-			CheckpointFile: checkpointFile,
-		},
-	}
-	if err := cm.loadCheckpoint(); err != nil {
-		if os.IsNotExist(err) {
-			cm.currentCheckpoint = &Checkpoint{}
-		} else {
-			panic(fmt.Sprintf("Failed to load checkpoint: %v", err))
-		}
+	return NewCheckpointManagerWithConfig(&CheckpointConfig{
+		SaveInterval:   saveInterval,
+		ItemsThreshold: itemsThreshold,
+		CheckpointFile: checkpointFile,
+	})
+}
+
+// NewCheckpointManagerWithConfig creates a new CheckpointManager with the config.
+// It panics if the checkpoint cannot be loaded (see LoadCheckpointManager).
+func NewCheckpointManagerWithConfig(config *CheckpointConfig) *CheckpointManager {
+	cm, errE := LoadCheckpointManager(config)
+	if errE != nil {
+		panic(errE)
 	}
-	go cm.autoSave()
 	return cm
 }
 
-func NewCheckpointManagerWithConfig(config *CheckpointConfig) *CheckpointManager {
+// LoadCheckpointManager creates a new CheckpointManager with the config and loads
+// the checkpoint from the store, returning an error if it cannot be loaded.
+// Close should be called when the CheckpointManager is not needed anymore.
+func LoadCheckpointManager(config *CheckpointConfig) (*CheckpointManager, errors.E) {
 	cm := &CheckpointManager{
 		config: config,
+		stop:   make(chan struct{}),
 	}
-	if err := cm.loadCheckpoint(); err != nil {
-		if os.IsNotExist(err) {
-			cm.currentCheckpoint = &Checkpoint{}
-		} else {
-			panic(fmt.Sprintf("Failed to load checkpoint: %v", err))
-		}
+	errE := cm.loadCheckpoint()
+	if errE != nil {
+		return nil, errors.WithMessage(errE, "load checkpoint")
 	}
 	go cm.autoSave()
-	return cm
+	return cm, nil
 }
 
 // autoSave saves the checkpoint to the checkpoint file every saveInterval
@@ -97,10 +132,15 @@ func (cm *CheckpointManager) autoSave() {
 	ticker := time.NewTicker(cm.config.SaveInterval)
 	defer ticker.Stop()
 
-	for range ticker.C {
-		if cm.dirty {
+	for {
+		select {
+		case <-cm.stop:
+			return
+		case <-ticker.C:
+			// Save does nothing if the checkpoint is not dirty. We do not check dirty
+			// here because it can be accessed only while holding the lock.
 			if err := cm.Save(); err != nil {
-				fmt.Println("Failed to auto save checkpoint:", err)
+				cm.logger().Error("failed to auto save checkpoint", "stage", "checkpoint", "error", err)
 			}
 		}
 	}
@@ -122,6 +162,59 @@ func (cm *CheckpointManager) UpdateProgressAndMaybeSave(position int, itemID str
 	return nil
 }
 
+// StartRow registers the row with lineNumber as started. Rows have to be started
+// in the order of their line numbers and every started row has to be eventually completed
+// with CompleteItem, otherwise ProcessedPosition does not advance past it. offset is the byte
+// offset just after the row, or -1 if reading cannot be resumed there. For compressed files and
+// tar archives, restart is where reading restarts to get to offset.
+func (cm *CheckpointManager) StartRow(lineNumber int, offset int64, restart *Restart) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	if cm.rows == nil {
+		cm.rows = make(map[int]*startedRow)
+	}
+	cm.startedRows = append(cm.startedRows, lineNumber)
+	cm.rows[lineNumber] = &startedRow{remaining: -1, offset: offset, restart: restart}
+}
+
+// CompleteItem marks one item of the started row with lineNumber as processed. items is the
+// number of items in the row. Rows without items are completed by calling CompleteItem once
+// with items set to 0. When all items of the row and all rows before it are completed,
+// ProcessedPosition advances to it. The checkpoint is saved when the threshold is reached.
+func (cm *CheckpointManager) CompleteItem(lineNumber, items int) error {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	row, ok := cm.rows[lineNumber]
+	if !ok {
+		return errors.Errorf("row %d not started", lineNumber)
+	}
+	if row.remaining < 0 {
+		row.remaining = max(items, 1)
+	}
+	row.remaining--
+	if items > 0 {
+		cm.currentCheckpoint.TotalItems++
+		cm.itemsSinceLastCheckpoint++
+	}
+	for len(cm.startedRows) > 0 && cm.rows[cm.startedRows[0]].remaining == 0 {
+		first := cm.startedRows[0]
+		// Rows passed on again when resuming (e.g., CREATE TABLE of an SQL dump)
+		// do not move ProcessedPosition back.
+		if first > cm.currentCheckpoint.ProcessedPosition {
+			cm.currentCheckpoint.ProcessedPosition = first
+			cm.currentCheckpoint.Offset = max(cm.rows[first].offset, 0)
+			cm.currentCheckpoint.Restart = cm.rows[first].restart
+		}
+		delete(cm.rows, first)
+		cm.startedRows = cm.startedRows[1:]
+	}
+	cm.dirty = true
+	if cm.itemsSinceLastCheckpoint >= cm.config.ItemsThreshold {
+		return cm.save()
+	}
+	return nil
+}
+
 // Save saves the current checkpoint to the checkpoint file
 func (cm *CheckpointManager) Save() error {
 	cm.mu.Lock()
@@ -134,48 +227,79 @@ func (cm *CheckpointManager) Save() error {
 // 1. The number of items processed since the last checkpoint exceeds the threshold
 // 2. The time since the last save exceeds the save interval
 // So we use mutex to protect this method.
-func (cm *CheckpointManager) save() error {
+func (cm *CheckpointManager) save() errors.E {
 	if !cm.dirty {
 		return nil
 	}
 	cm.currentCheckpoint.SaveTimestamp = time.Now()
-	data, err := json.MarshalIndent(cm.currentCheckpoint, "", "  ")
-	if err != nil {
-		return errors.WithMessage(err, "marshal checkpoint")
-	}
-	tempFile := cm.config.CheckpointFile + ".tmp"
-	if err := os.WriteFile(tempFile, data, 0644); err != nil {
-		return errors.WithMessage(err, "write checkpoint")
+	checkpoint := copyCheckpoint(cm.currentCheckpoint)
+	// We save only if nobody else changed the checkpoint since we loaded or saved it.
+	swapped, errE := cm.getStore().CompareAndSwap(cm.savedCheckpoint, checkpoint)
+	if errE != nil {
+		return errE
 	}
-	if err := os.Rename(tempFile, cm.config.CheckpointFile); err != nil {
-		return errors.WithMessage(err, "rename checkpoint file")
+	if !swapped {
+		return errors.WithStack(ErrCheckpointConflict)
 	}
+	cm.savedCheckpoint = checkpoint
 	cm.itemsSinceLastCheckpoint = 0
 	cm.dirty = false
 	return nil
 }
 
-// loadCheckpoint loads the checkpoint from the checkpoint file
-func (cm *CheckpointManager) loadCheckpoint() error {
-	data, err := os.ReadFile(cm.config.CheckpointFile)
-	if err != nil {
-		return err
+// loadCheckpoint loads the checkpoint from the store, or starts with an empty checkpoint if there is none
+func (cm *CheckpointManager) loadCheckpoint() errors.E {
+	checkpoint, errE := cm.getStore().Load()
+	if errE != nil {
+		return errE
 	}
-	checkpoint := &Checkpoint{}
-	if err := json.Unmarshal(data, checkpoint); err != nil {
-		return errors.WithMessage(err, "unmarshal checkpoint")
+	cm.savedCheckpoint = checkpoint
+	if checkpoint == nil {
+		cm.currentCheckpoint = &Checkpoint{}
+		return nil
 	}
-	fmt.Println("Loaded checkpoint", checkpoint)
-	cm.currentCheckpoint = checkpoint
+	cm.logger().Info("loaded checkpoint",
+		"stage", "checkpoint", "line", checkpoint.ProcessedPosition, "offset", checkpoint.Offset, "items", checkpoint.TotalItems,
+	)
+	cm.currentCheckpoint = copyCheckpoint(checkpoint)
 	return nil
 }
 
+// logger returns the configured logger or a logger which discards everything
+func (cm *CheckpointManager) logger() *slog.Logger {
+	return loggerOrDiscard(cm.config.Logger)
+}
+
+// getStore returns the configured store or the file store for the checkpoint file
+func (cm *CheckpointManager) getStore() CheckpointStore { //nolint:ireturn
+	if cm.store == nil {
+		if cm.config.Store != nil {
+			cm.store = cm.config.Store
+		} else {
+			cm.store = NewFileCheckpointStore(cm.config.CheckpointFile)
+		}
+	}
+	return cm.store
+}
+
 func (cm *CheckpointManager) GetCheckpoint() *Checkpoint {
 	cm.mu.Lock()
 	defer cm.mu.Unlock()
 	return cm.currentCheckpoint
 }
 
+// Close stops saving the checkpoint every SaveInterval and saves it one last time.
+// It can be called multiple times.
 func (cm *CheckpointManager) Close() error {
+	cm.stopAutoSave()
 	return cm.Save()
 }
+
+// stopAutoSave stops saving the checkpoint every SaveInterval.
+func (cm *CheckpointManager) stopAutoSave() {
+	cm.stopOnce.Do(func() {
+		if cm.stop != nil {
+			close(cm.stop)
+		}
+	})
+}
@@ -48,6 +48,19 @@ func TestCheckpointManager_AutoSaveTicker(t *testing.T) {
 	assert.Equal(t, 1, checkpoint.TotalItems)
 	assert.Equal(t, 1, checkpoint.ProcessedPosition)
 	assert.Equal(t, "item1", checkpoint.LastItemID)
+
+	// After Close, the checkpoint is not saved anymore.
+	err = cm.Close()
+	assert.NoError(t, err)
+	err = cm.UpdateProgressAndMaybeSave(2, "item2")
+	assert.NoError(t, err)
+	time.Sleep(time.Millisecond * 150)
+
+	data, err = os.ReadFile(tmpFile)
+	assert.NoError(t, err)
+	err = json.Unmarshal(data, &checkpoint)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, checkpoint.ProcessedPosition)
 }
 
 func TestCheckpointManager_UpdateProgressAndMaybeSave(t *testing.T) {
@@ -145,6 +158,27 @@ func TestCheckpointManager_LoadCheckpoint(t *testing.T) {
 	assert.Equal(t, testCheckpoint.ProcessedPosition, cm.currentCheckpoint.ProcessedPosition)
 }
 
+func TestLoadCheckpointManager_Invalid(t *testing.T) {
+	tmpFile := "TestLoadCheckpointManager_Invalid.json"
+	err := os.WriteFile(tmpFile, []byte("{invalid"), 0644)
+	assert.NoError(t, err)
+	defer os.Remove(tmpFile)
+
+	config := &CheckpointConfig{
+		SaveInterval:   time.Second,
+		ItemsThreshold: 1,
+		CheckpointFile: tmpFile,
+	}
+
+	cm, errE := LoadCheckpointManager(config)
+	assert.Error(t, errE)
+	assert.Nil(t, cm)
+
+	assert.Panics(t, func() {
+		NewCheckpointManagerWithConfig(config)
+	})
+}
+
 func TestCheckpointManager_CloseAndSave(t *testing.T) {
 	tmpFile := "TestCheckpointManager_Close.json"
 	os.Remove(tmpFile)
@@ -174,3 +208,89 @@ func TestCheckpointManager_CloseAndSave(t *testing.T) {
 	assert.Equal(t, "final_item", checkpoint.LastItemID)
 	assert.Equal(t, 250, checkpoint.ProcessedPosition)
 }
+
+func TestCheckpointManager_CompleteItem(t *testing.T) {
+	tmpFile := "TestCheckpointManager_CompleteItem.json"
+	os.Remove(tmpFile)
+	cm := NewCheckpointManagerWithConfig(
+		&CheckpointConfig{
+			SaveInterval:   time.Second,
+			ItemsThreshold: 100,
+			CheckpointFile: tmpFile,
+		})
+	defer os.Remove(tmpFile)
+
+	cm.StartRow(1, 10, nil)
+	cm.StartRow(2, 20, nil)
+	cm.StartRow(4, -1, nil)
+
+	// Row 2 is completed before row 1, so the position cannot advance.
+	err := cm.CompleteItem(2, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, cm.GetCheckpoint().ProcessedPosition)
+
+	// Row 1 has two items.
+	err = cm.CompleteItem(1, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, cm.GetCheckpoint().ProcessedPosition)
+	err = cm.CompleteItem(1, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, cm.GetCheckpoint().ProcessedPosition)
+	assert.Equal(t, int64(20), cm.GetCheckpoint().Offset)
+
+	// Row 4 has no items.
+	err = cm.CompleteItem(4, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, cm.GetCheckpoint().ProcessedPosition)
+	// Offset of row 4 is not known.
+	assert.Equal(t, int64(0), cm.GetCheckpoint().Offset)
+	assert.Equal(t, 3, cm.GetCheckpoint().TotalItems)
+
+	err = cm.CompleteItem(5, 1)
+	assert.Error(t, err)
+
+	// Row passed on again (e.g., CREATE TABLE when resuming) does not move the position back.
+	cm.StartRow(3, 30, nil)
+	err = cm.CompleteItem(3, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, cm.GetCheckpoint().ProcessedPosition)
+
+	err = cm.Close()
+	assert.NoError(t, err)
+
+	data, err := os.ReadFile(tmpFile)
+	assert.NoError(t, err)
+
+	var checkpoint Checkpoint
+	err = json.Unmarshal(data, &checkpoint)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, checkpoint.ProcessedPosition)
+}
+
+func TestCheckpointManager_Conflict(t *testing.T) {
+	store := NewMemoryCheckpointStore()
+	cm := NewCheckpointManagerWithConfig(
+		&CheckpointConfig{
+			SaveInterval:   time.Minute,
+			ItemsThreshold: 1000,
+			Store:          store,
+		})
+
+	err := cm.UpdateProgressAndMaybeSave(1, "item1")
+	assert.NoError(t, err)
+	err = cm.Save()
+	assert.NoError(t, err)
+
+	// Somebody else changes the stored checkpoint.
+	errE := store.Save(&Checkpoint{TotalItems: 10, ProcessedPosition: 10})
+	assert.NoError(t, errE)
+
+	err = cm.UpdateProgressAndMaybeSave(2, "item2")
+	assert.NoError(t, err)
+	err = cm.Save()
+	assert.ErrorIs(t, err, ErrCheckpointConflict)
+
+	checkpoint, errE := store.Load()
+	assert.NoError(t, errE)
+	assert.Equal(t, 10, checkpoint.ProcessedPosition)
+}
@@ -0,0 +1,286 @@
+package mediawiki
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"sync"
+
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/x"
+)
+
+// CheckpointStore stores a checkpoint.
+//
+// CheckpointManager uses CompareAndSwap to save checkpoints, passing the checkpoint
+// it loaded or saved last as old, so that it notices if somebody else changed the
+// checkpoint in the meantime (e.g., another process using the same checkpoint).
+type CheckpointStore interface {
+	// Load returns the stored checkpoint or nil if there is none.
+	Load() (*Checkpoint, errors.E)
+	// Save stores the checkpoint.
+	Save(checkpoint *Checkpoint) errors.E
+	// CompareAndSwap stores the new checkpoint only if the currently stored checkpoint
+	// is equal to old. Old is nil if there should be no stored checkpoint.
+	// It returns false if the checkpoint has not been stored.
+	CompareAndSwap(old, new *Checkpoint) (bool, errors.E) //nolint:predeclared
+}
+
+// KeyValueStore is a minimal interface of a key-value storage which can be used
+// as CheckpointStore through NewKeyValueCheckpointStore.
+type KeyValueStore interface {
+	// Get returns the value for the key. It returns false if there is no value.
+	Get(key string) ([]byte, bool, errors.E)
+	// Set sets the value for the key.
+	Set(key string, value []byte) errors.E
+	// CompareAndSwap sets the value for the key to new only if the current value is
+	// equal to old. Old is nil if there should be no value for the key.
+	// It returns false if the value has not been set.
+	CompareAndSwap(key string, old, new []byte) (bool, errors.E) //nolint:predeclared
+}
+
+// marshalCheckpoint returns JSON of the checkpoint or nil if the checkpoint is nil.
+func marshalCheckpoint(checkpoint *Checkpoint) ([]byte, errors.E) {
+	if checkpoint == nil {
+		return nil, nil
+	}
+	data, err := json.MarshalIndent(checkpoint, "", "  ")
+	if err != nil {
+		return nil, errors.WithMessage(err, "marshal checkpoint")
+	}
+	return data, nil
+}
+
+// unmarshalCheckpoint parses JSON of the checkpoint.
+func unmarshalCheckpoint(data []byte) (*Checkpoint, errors.E) {
+	var checkpoint Checkpoint
+	errE := x.Unmarshal(data, &checkpoint)
+	if errE != nil {
+		return nil, errors.WithMessage(errE, "unmarshal checkpoint")
+	}
+	return &checkpoint, nil
+}
+
+// equalCheckpoints returns true if both checkpoints are nil or if their JSON is equal.
+func equalCheckpoints(a, b *Checkpoint) (bool, errors.E) {
+	aData, errE := marshalCheckpoint(a)
+	if errE != nil {
+		return false, errE
+	}
+	bData, errE := marshalCheckpoint(b)
+	if errE != nil {
+		return false, errE
+	}
+	return (a == nil) == (b == nil) && bytes.Equal(aData, bData), nil
+}
+
+var _ CheckpointStore = (*FileCheckpointStore)(nil)
+
+// FileCheckpointStore stores the checkpoint as JSON in a file on local disk.
+//
+// CompareAndSwap is atomic only among users of the same FileCheckpointStore.
+type FileCheckpointStore struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewFileCheckpointStore returns a new FileCheckpointStore storing the checkpoint in the file at path.
+func NewFileCheckpointStore(path string) *FileCheckpointStore {
+	return &FileCheckpointStore{
+		path: path,
+		mu:   sync.Mutex{},
+	}
+}
+
+// Load implements CheckpointStore interface.
+func (s *FileCheckpointStore) Load() (*Checkpoint, errors.E) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.load()
+}
+
+func (s *FileCheckpointStore) load() (*Checkpoint, errors.E) {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		errE := errors.WithMessage(err, "read checkpoint")
+		errors.Details(errE)["path"] = s.path
+		return nil, errE
+	}
+	checkpoint, errE := unmarshalCheckpoint(data)
+	if errE != nil {
+		errors.Details(errE)["path"] = s.path
+		return nil, errE
+	}
+	return checkpoint, nil
+}
+
+// Save implements CheckpointStore interface.
+func (s *FileCheckpointStore) Save(checkpoint *Checkpoint) errors.E {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.save(checkpoint)
+}
+
+func (s *FileCheckpointStore) save(checkpoint *Checkpoint) errors.E {
+	data, errE := marshalCheckpoint(checkpoint)
+	if errE != nil {
+		return errE
+	}
+	// We first write to a temporary file and then rename it, so that the checkpoint
+	// file is always complete.
+	tempFile := s.path + ".tmp"
+	if err := os.WriteFile(tempFile, data, 0o644); err != nil { //nolint:gosec
+		errE := errors.WithMessage(err, "write checkpoint")
+		errors.Details(errE)["path"] = tempFile
+		return errE
+	}
+	if err := os.Rename(tempFile, s.path); err != nil {
+		errE := errors.WithMessage(err, "rename checkpoint file")
+		errors.Details(errE)["path"] = s.path
+		return errE
+	}
+	return nil
+}
+
+// CompareAndSwap implements CheckpointStore interface.
+func (s *FileCheckpointStore) CompareAndSwap(old, new *Checkpoint) (bool, errors.E) { //nolint:predeclared
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	current, errE := s.load()
+	if errE != nil {
+		return false, errE
+	}
+	equal, errE := equalCheckpoints(current, old)
+	if errE != nil || !equal {
+		return false, errE
+	}
+	return true, s.save(new)
+}
+
+var _ CheckpointStore = (*MemoryCheckpointStore)(nil)
+
+// MemoryCheckpointStore stores the checkpoint in memory.
+type MemoryCheckpointStore struct {
+	checkpoint *Checkpoint
+	mu         sync.Mutex
+}
+
+// NewMemoryCheckpointStore returns a new empty MemoryCheckpointStore.
+func NewMemoryCheckpointStore() *MemoryCheckpointStore {
+	return &MemoryCheckpointStore{
+		checkpoint: nil,
+		mu:         sync.Mutex{},
+	}
+}
+
+// Load implements CheckpointStore interface.
+func (s *MemoryCheckpointStore) Load() (*Checkpoint, errors.E) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return copyCheckpoint(s.checkpoint), nil
+}
+
+// Save implements CheckpointStore interface.
+func (s *MemoryCheckpointStore) Save(checkpoint *Checkpoint) errors.E {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.checkpoint = copyCheckpoint(checkpoint)
+	return nil
+}
+
+// CompareAndSwap implements CheckpointStore interface.
+func (s *MemoryCheckpointStore) CompareAndSwap(old, new *Checkpoint) (bool, errors.E) { //nolint:predeclared
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	equal, errE := equalCheckpoints(s.checkpoint, old)
+	if errE != nil || !equal {
+		return false, errE
+	}
+	s.checkpoint = copyCheckpoint(new)
+	return true, nil
+}
+
+func copyCheckpoint(checkpoint *Checkpoint) *Checkpoint {
+	if checkpoint == nil {
+		return nil
+	}
+	c := *checkpoint
+	if c.Dump != nil {
+		dump := *c.Dump
+		c.Dump = &dump
+	}
+	if c.Restart != nil {
+		restart := *c.Restart
+		c.Restart = &restart
+	}
+	return &c
+}
+
+var _ CheckpointStore = (*keyValueCheckpointStore)(nil)
+
+type keyValueCheckpointStore struct {
+	store KeyValueStore
+	key   string
+}
+
+// NewKeyValueCheckpointStore returns a CheckpointStore which stores the checkpoint
+// as JSON under the key in the key-value store.
+func NewKeyValueCheckpointStore(store KeyValueStore, key string) CheckpointStore { //nolint:ireturn
+	return &keyValueCheckpointStore{
+		store: store,
+		key:   key,
+	}
+}
+
+// Load implements CheckpointStore interface.
+func (s *keyValueCheckpointStore) Load() (*Checkpoint, errors.E) {
+	data, ok, errE := s.store.Get(s.key)
+	if errE != nil {
+		errors.Details(errE)["key"] = s.key
+		return nil, errE
+	}
+	if !ok {
+		return nil, nil
+	}
+	checkpoint, errE := unmarshalCheckpoint(data)
+	if errE != nil {
+		errors.Details(errE)["key"] = s.key
+		return nil, errE
+	}
+	return checkpoint, nil
+}
+
+// Save implements CheckpointStore interface.
+func (s *keyValueCheckpointStore) Save(checkpoint *Checkpoint) errors.E {
+	data, errE := marshalCheckpoint(checkpoint)
+	if errE != nil {
+		return errE
+	}
+	errE = s.store.Set(s.key, data)
+	if errE != nil {
+		errors.Details(errE)["key"] = s.key
+	}
+	return errE
+}
+
+// CompareAndSwap implements CheckpointStore interface.
+//
+// The old checkpoint is compared by its JSON, so it should be a checkpoint
+// previously returned by Load or passed to Save or CompareAndSwap.
+func (s *keyValueCheckpointStore) CompareAndSwap(old, new *Checkpoint) (bool, errors.E) { //nolint:predeclared
+	oldData, errE := marshalCheckpoint(old)
+	if errE != nil {
+		return false, errE
+	}
+	newData, errE := marshalCheckpoint(new)
+	if errE != nil {
+		return false, errE
+	}
+	swapped, errE := s.store.CompareAndSwap(s.key, oldData, newData)
+	if errE != nil {
+		errors.Details(errE)["key"] = s.key
+	}
+	return swapped, errE
+}
@@ -0,0 +1,175 @@
+package mediawiki_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/go/errors"
+
+	"github.com/citadel2024/go-mediawiki"
+)
+
+type mapKeyValueStore struct {
+	mu     sync.Mutex
+	values map[string][]byte
+}
+
+func (s *mapKeyValueStore) Get(key string) ([]byte, bool, errors.E) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok := s.values[key]
+	return value, ok, nil
+}
+
+func (s *mapKeyValueStore) Set(key string, value []byte) errors.E {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key] = value
+	return nil
+}
+
+func (s *mapKeyValueStore) CompareAndSwap(key string, old, new []byte) (bool, errors.E) { //nolint:predeclared
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	current, ok := s.values[key]
+	if ok != (old != nil) || !bytes.Equal(current, old) {
+		return false, nil
+	}
+	s.values[key] = new
+	return true, nil
+}
+
+func TestCheckpointStore(t *testing.T) {
+	t.Parallel()
+
+	tempDir := t.TempDir()
+
+	for _, tt := range []struct {
+		name  string
+		store func() mediawiki.CheckpointStore
+	}{
+		{
+			"file",
+			func() mediawiki.CheckpointStore {
+				return mediawiki.NewFileCheckpointStore(filepath.Join(tempDir, "checkpoint.json"))
+			},
+		},
+		{
+			"memory",
+			func() mediawiki.CheckpointStore {
+				return mediawiki.NewMemoryCheckpointStore()
+			},
+		},
+		{
+			"keyvalue",
+			func() mediawiki.CheckpointStore {
+				return mediawiki.NewKeyValueCheckpointStore(&mapKeyValueStore{values: map[string][]byte{}}, "checkpoint")
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			store := tt.store()
+
+			checkpoint, errE := store.Load()
+			require.NoError(t, errE, "% -+#.1v", errE)
+			assert.Nil(t, checkpoint)
+
+			first := &mediawiki.Checkpoint{TotalItems: 1, ProcessedPosition: 1, SaveTimestamp: time.Now().UTC()}
+			second := &mediawiki.Checkpoint{TotalItems: 2, ProcessedPosition: 2, SaveTimestamp: time.Now().UTC()}
+
+			swapped, errE := store.CompareAndSwap(nil, first)
+			require.NoError(t, errE, "% -+#.1v", errE)
+			assert.True(t, swapped)
+
+			swapped, errE = store.CompareAndSwap(nil, second)
+			require.NoError(t, errE, "% -+#.1v", errE)
+			assert.False(t, swapped)
+
+			checkpoint, errE = store.Load()
+			require.NoError(t, errE, "% -+#.1v", errE)
+			assert.Equal(t, first, checkpoint)
+
+			swapped, errE = store.CompareAndSwap(checkpoint, second)
+			require.NoError(t, errE, "% -+#.1v", errE)
+			assert.True(t, swapped)
+
+			swapped, errE = store.CompareAndSwap(first, first)
+			require.NoError(t, errE, "% -+#.1v", errE)
+			assert.False(t, swapped)
+
+			errE = store.Save(first)
+			require.NoError(t, errE, "% -+#.1v", errE)
+
+			checkpoint, errE = store.Load()
+			require.NoError(t, errE, "% -+#.1v", errE)
+			assert.Equal(t, first, checkpoint)
+		})
+	}
+}
+
+func TestProcessCheckpointStore(t *testing.T) {
+	t.Parallel()
+
+	tempDir := t.TempDir()
+
+	var ndjson bytes.Buffer
+	for i := range 100 {
+		fmt.Fprintf(&ndjson, `{"n":%d}`+"\n", i)
+	}
+	path := filepath.Join(tempDir, "items.ndjson")
+	err := os.WriteFile(path, ndjson.Bytes(), 0o600)
+	require.NoError(t, err)
+
+	store := mediawiki.NewMemoryCheckpointStore()
+
+	errE := mediawiki.Process(context.Background(), &mediawiki.ProcessConfig[testNumber]{
+		Path:        path,
+		FileType:    mediawiki.NDJSON,
+		Compression: mediawiki.NoCompression,
+		Process: func(_ context.Context, _ testNumber) errors.E {
+			return nil
+		},
+		CheckpointConfig: &mediawiki.CheckpointConfig{
+			SaveInterval:   time.Minute,
+			ItemsThreshold: 1,
+			Store:          store,
+		},
+	})
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	checkpoint, errE := store.Load()
+	require.NoError(t, errE, "% -+#.1v", errE)
+	require.NotNil(t, checkpoint)
+	assert.Equal(t, 100, checkpoint.ProcessedPosition)
+	assert.Equal(t, 100, checkpoint.TotalItems)
+
+	// Nothing is processed again.
+	processed := 0
+	errE = mediawiki.Process(context.Background(), &mediawiki.ProcessConfig[testNumber]{
+		Path:        path,
+		FileType:    mediawiki.NDJSON,
+		Compression: mediawiki.NoCompression,
+		Process: func(_ context.Context, _ testNumber) errors.E {
+			processed++
+			return nil
+		},
+		ItemsProcessingThreads: 1,
+		CheckpointConfig: &mediawiki.CheckpointConfig{
+			SaveInterval:   time.Minute,
+			ItemsThreshold: 1,
+			Store:          store,
+		},
+	})
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, 0, processed)
+}
@@ -0,0 +1,251 @@
+package mediawiki
+
+import (
+	"bufio"
+	"context"
+	"crypto/md5"  //nolint:gosec
+	"crypto/sha1" //nolint:gosec
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/hashicorp/go-retryablehttp"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/x"
+)
+
+var dumpFilePrefixRegex = regexp.MustCompile(`^([^-]+-\d{8})-`)
+
+// ChecksumConfig configures verification of downloaded files against a published checksums file.
+//
+// URL is the URL of the checksums file (e.g., "enwiki-20240901-sha1sums.txt", see DumpChecksumsURL).
+// Every line of the file contains a hex-encoded checksum and a file name, separated by whitespace,
+// in the format of md5sum and sha1sum tools. The hash function (MD5, SHA-1, or SHA-256) is determined
+// by the length of the checksum.
+//
+// If the checksum of the downloaded file (or of the already existing file at Path) does not match,
+// the file is deleted, or, if Quarantine is true, moved to Path+".quarantine" for inspection.
+type ChecksumConfig struct {
+	URL        string
+	Quarantine bool
+}
+
+// DumpChecksumsURL returns the URL of the checksums file published for the dump run
+// of the dump file at dumpURL. Algorithm is "md5" or "sha1".
+//
+// For example, for "https://dumps.wikimedia.org/enwiki/20240901/enwiki-20240901-pages-articles.xml.bz2"
+// and "sha1" it returns "https://dumps.wikimedia.org/enwiki/20240901/enwiki-20240901-sha1sums.txt".
+func DumpChecksumsURL(dumpURL, algorithm string) (string, errors.E) {
+	if algorithm != "md5" && algorithm != "sha1" {
+		return "", errors.WithDetails(ErrInvalidValue, "algorithm", algorithm)
+	}
+	u, err := url.Parse(dumpURL)
+	if err != nil {
+		errE := errors.WithMessage(err, "parse url")
+		errors.Details(errE)["url"] = dumpURL
+		return "", errE
+	}
+	match := dumpFilePrefixRegex.FindStringSubmatch(path.Base(u.Path))
+	if match == nil {
+		return "", errors.WithDetails(ErrInvalidValue, "url", dumpURL)
+	}
+	u.Path = path.Join(path.Dir(u.Path), match[1]+"-"+algorithm+"sums.txt")
+	u.RawQuery = ""
+	return u.String(), nil
+}
+
+// parseChecksums parses the checksums file into a map between file names and checksums.
+func parseChecksums(reader io.Reader) (map[string]string, errors.E) {
+	checksums := map[string]string{}
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 { //nolint:mnd
+			return nil, errors.WithDetails(ErrInvalidValue, "line", scanner.Text())
+		}
+		// A file name can be prefixed with "*" to denote binary mode.
+		checksums[strings.TrimPrefix(fields[1], "*")] = strings.ToLower(fields[0])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.WithMessage(err, "scan")
+	}
+	return checksums, nil
+}
+
+// FetchChecksums fetches and parses the checksums file at checksumsURL.
+// It returns a map between file names and hex-encoded checksums.
+func FetchChecksums(ctx context.Context, client *retryablehttp.Client, checksumsURL string) (map[string]string, errors.E) {
+	req, err := retryablehttp.NewRequestWithContext(ctx, http.MethodGet, checksumsURL, nil)
+	if err != nil {
+		errE := errors.WithMessage(err, "new request")
+		errors.Details(errE)["url"] = checksumsURL
+		return nil, errE
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		errE := errors.WithMessage(err, "do")
+		errors.Details(errE)["url"] = checksumsURL
+		return nil, errE
+	}
+	defer resp.Body.Close()
+	defer io.Copy(io.Discard, resp.Body) //nolint:errcheck
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, errors.WithDetails(
+			x.ErrResponseBadStatus,
+			"status", resp.Status,
+			"body", strings.TrimSpace(string(body)),
+			"url", checksumsURL,
+		)
+	}
+	checksums, errE := parseChecksums(resp.Body)
+	if errE != nil {
+		errors.Details(errE)["url"] = checksumsURL
+		return nil, errE
+	}
+	return checksums, nil
+}
+
+// fetchChecksum returns the checksum for the file with name from the checksums file at checksumsURL.
+func fetchChecksum(ctx context.Context, client *retryablehttp.Client, checksumsURL, name string) (string, errors.E) {
+	checksums, errE := FetchChecksums(ctx, client, checksumsURL)
+	if errE != nil {
+		return "", errE
+	}
+	checksum, ok := checksums[name]
+	if !ok {
+		return "", errors.WithDetails(ErrNotFound, "url", checksumsURL, "name", name)
+	}
+	return checksum, nil
+}
+
+// newDownloadChecksum fetches the checksum for the file with name from the checksums file
+// configured by config and returns downloadChecksum which computes the actual checksum.
+func newDownloadChecksum(
+	ctx context.Context, client *retryablehttp.Client, config *ChecksumConfig, name string,
+) (*downloadChecksum, errors.E) {
+	if client == nil {
+		return nil, errors.New("client is required to fetch checksums")
+	}
+	expected, errE := fetchChecksum(ctx, client, config.URL, name)
+	if errE != nil {
+		return nil, errE
+	}
+	h, errE := newChecksumHash(expected)
+	if errE != nil {
+		errors.Details(errE)["url"] = config.URL
+		return nil, errE
+	}
+	return &downloadChecksum{
+		expected:   expected,
+		quarantine: config.Quarantine,
+		hash:       h,
+		hashed:     0,
+	}, nil
+}
+
+// hashFile writes the whole file at filePath into w.
+func hashFile(w io.Writer, filePath string) errors.E {
+	file, err := os.Open(filePath)
+	if err != nil {
+		errE := errors.WithMessage(err, "open")
+		errors.Details(errE)["path"] = filePath
+		return errE
+	}
+	defer file.Close()
+	_, err = io.Copy(w, file)
+	if err != nil {
+		errE := errors.WithMessage(err, "read")
+		errors.Details(errE)["path"] = filePath
+		return errE
+	}
+	return nil
+}
+
+// verifyFileChecksum verifies the existing file at filePath against checksum. On mismatch,
+// the file is deleted (or moved to filePath+".quarantine"), so that it is downloaded again.
+func verifyFileChecksum(filePath string, checksum *downloadChecksum) errors.E {
+	errE := hashFile(checksum, filePath)
+	if errE != nil {
+		return errE
+	}
+	errE = checksumMismatch(checksum.hash, checksum.expected)
+	if errE != nil {
+		errors.Details(errE)["path"] = filePath
+		return rejectFile(errE, filePath, filePath, checksum.quarantine)
+	}
+	return nil
+}
+
+// rejectFile deletes the file at filePath which failed verification with errE or,
+// if quarantine is true, moves it to path+".quarantine". It returns errE.
+func rejectFile(errE errors.E, filePath, path string, quarantine bool) errors.E {
+	if quarantine {
+		errors.Details(errE)["quarantine"] = path + ".quarantine"
+		err := os.Rename(filePath, path+".quarantine")
+		if err != nil {
+			return errors.Join(errE, errors.WithStack(err))
+		}
+		return errE
+	}
+	_ = os.Remove(filePath)
+	return errE
+}
+
+// newChecksumHash returns the hash function for the checksum, based on its length.
+func newChecksumHash(checksum string) (hash.Hash, errors.E) { //nolint:ireturn
+	switch len(checksum) {
+	case 2 * md5.Size:
+		return md5.New(), nil //nolint:gosec
+	case 2 * sha1.Size:
+		return sha1.New(), nil //nolint:gosec
+	case 2 * sha256.Size:
+		return sha256.New(), nil
+	}
+	return nil, errors.WithDetails(ErrInvalidValue, "checksum", checksum)
+}
+
+// checksumMismatch returns an error if the actual checksum (computed with h) does not match the expected one.
+func checksumMismatch(h hash.Hash, expected string) errors.E {
+	actual := hex.EncodeToString(h.Sum(nil))
+	if actual != expected {
+		return errors.WithDetails(ErrChecksumMismatch, "expected", expected, "actual", actual)
+	}
+	return nil
+}
+
+// VerifyChecksum verifies the file at filePath against its checksum in the checksums file at
+// checksumsURL (see DumpChecksumsURL). The file is looked up in the checksums file by its base name.
+// It returns ErrChecksumMismatch if the checksum does not match.
+func VerifyChecksum(ctx context.Context, client *retryablehttp.Client, checksumsURL, filePath string) errors.E {
+	expected, errE := fetchChecksum(ctx, client, checksumsURL, filepath.Base(filePath))
+	if errE != nil {
+		return errE
+	}
+	h, errE := newChecksumHash(expected)
+	if errE != nil {
+		errors.Details(errE)["url"] = checksumsURL
+		return errE
+	}
+	errE = hashFile(h, filePath)
+	if errE != nil {
+		return errE
+	}
+	errE = checksumMismatch(h, expected)
+	if errE != nil {
+		errors.Details(errE)["path"] = filePath
+		return errE
+	}
+	return nil
+}
@@ -3,6 +3,7 @@ package mediawiki
 import (
 	"context"
 	"fmt"
+	"iter"
 	"strings"
 
 	"github.com/elliotchance/phpserialize"
@@ -11,14 +12,14 @@ import (
 	"gitlab.com/tozd/go/x"
 )
 
+// CommonsEntitiesDump returns Wikimedia Commons entities JSON dump.
+func CommonsEntitiesDump() Dump {
+	return entitiesDump(CommonsWiki, "commons", "mediainfo", JSONBZIP2Format)
+}
+
 // LatestCommonsEntitiesRun returns URL of the latest run of Wikimedia Commons entities JSON dump.
 func LatestCommonsEntitiesRun(ctx context.Context, client *retryablehttp.Client) (string, errors.E) {
-	return latestRun(
-		ctx,
-		client,
-		"https://dumps.wikimedia.org/commonswiki/entities/",
-		"https://dumps.wikimedia.org/commonswiki/entities/%s/commons-%s-mediainfo.json.bz2",
-	)
+	return CommonsEntitiesDump().latestURL(ctx, client)
 }
 
 // ProcessCommonsEntitiesDump downloads (unless already saved), decompresses, decodes JSON,
@@ -37,9 +38,25 @@ func ProcessCommonsEntitiesDump(
 		Process: func(ctx context.Context, i commonsEntity) errors.E {
 			return processEntity(ctx, Entity(i))
 		},
-		Progress:    config.Progress,
-		FileType:    JSONArray,
-		Compression: BZIP2,
+		Progress:         config.Progress,
+		Shard:            config.Shard,
+		Shards:           config.Shards,
+		Logger:           config.Logger,
+		Observer:         config.Observer,
+		Checksum:         config.Checksum,
+		CheckpointConfig: config.CheckpointConfig,
+		FileType:         JSONArray,
+		Compression:      BZIP2,
+	})
+}
+
+// CommonsEntities returns an iterator over all entities in a Wikimedia Commons entities JSON dump.
+// See Items for details.
+func CommonsEntities(ctx context.Context, config *ProcessDumpConfig) iter.Seq2[Entity, error] {
+	return items(ctx, func(ctx context.Context, process func(context.Context, Entity) errors.E) errors.E {
+		c := *config
+		c.CheckpointConfig = iterationCheckpointConfig(config.CheckpointConfig)
+		return ProcessCommonsEntitiesDump(ctx, &c, process)
 	})
 }
 
@@ -65,14 +82,14 @@ func convertToStringMapsMap(m map[interface{}]interface{}) map[string]interface{
 	return out
 }
 
-// LatestCommonsImageMetadataRun returns URL of the latest run of Wikimedia Commons image table dump.
+// CommonsImageMetadataDump returns Wikimedia Commons image table dump.
+func CommonsImageMetadataDump() Dump {
+	return tableDump(CommonsWiki, "image")
+}
+
+// LatestCommonsImageMetadataRun returns URL of the latest completed run of Wikimedia Commons image table dump.
 func LatestCommonsImageMetadataRun(ctx context.Context, client *retryablehttp.Client) (string, errors.E) {
-	return latestRun(
-		ctx,
-		client,
-		"https://dumps.wikimedia.org/commonswiki/",
-		"https://dumps.wikimedia.org/commonswiki/%s/commonswiki-%s-image.sql.gz",
-	)
+	return CommonsImageMetadataDump().latestURL(ctx, client)
 }
 
 // DecodeImageMetadata decodes image and other uploaded files metadata column in
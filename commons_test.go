@@ -46,9 +46,10 @@ func TestProcessCommonsDumpLatest(t *testing.T) {
 	errE = mediawiki.ProcessCommonsEntitiesDump(
 		ctx,
 		&mediawiki.ProcessDumpConfig{
-			URL:    url,
-			Path:   dumpPath,
-			Client: client,
+			URL:              url,
+			Path:             dumpPath,
+			Client:           client,
+			CheckpointConfig: testCheckpointConfig(),
 		},
 		func(_ context.Context, a mediawiki.Entity) errors.E {
 			atomic.AddInt64(&entityCounter, int64(1))
@@ -97,9 +98,10 @@ func TestProcessCommonsDumpExplicit(t *testing.T) {
 	errE := mediawiki.ProcessCommonsEntitiesDump(
 		context.Background(),
 		&mediawiki.ProcessDumpConfig{
-			URL:    commonsTestDump,
-			Path:   dumpPath,
-			Client: client,
+			URL:              commonsTestDump,
+			Path:             dumpPath,
+			Client:           client,
+			CheckpointConfig: testCheckpointConfig(),
 		},
 		func(_ context.Context, a mediawiki.Entity) errors.E {
 			atomic.AddInt64(&entityCounter, int64(1))
@@ -137,7 +139,8 @@ func TestProcessCommonsDumpExplicit(t *testing.T) {
 	errE = mediawiki.ProcessCommonsEntitiesDump(
 		context.Background(),
 		&mediawiki.ProcessDumpConfig{
-			Path: dumpPath,
+			Path:             dumpPath,
+			CheckpointConfig: testCheckpointConfig(),
 		},
 		func(_ context.Context, a mediawiki.Entity) errors.E {
 			atomic.AddInt64(&entityCounter, int64(1))
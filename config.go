@@ -2,6 +2,7 @@ package mediawiki
 
 import (
 	"context"
+	"log/slog"
 
 	"github.com/hashicorp/go-retryablehttp"
 	"gitlab.com/tozd/go/x"
@@ -12,12 +13,23 @@ import (
 // URL or Path are required.
 // If URL is provided and Path does not already exist, Client is required, too.
 //
+// Shard and Shards can be set to process only one shard of the dump. Every shard still
+// downloads and decompresses the whole dump, only decoding and processing is divided.
+// See ProcessConfig for details.
+//
+// Logger is used for diagnostic events. If it is nil, nothing is logged.
+// Observer observes the processing pipeline (e.g., use Metrics).
+// Checksum configures verification of the file (see DumpChecksumsURL and ProcessConfig).
+// CheckpointConfig configures the checkpoint used to resume processing (see ProcessConfig).
+//
 // Client should set User-Agent header with contact information, e.g.:
 //
 //	client := retryablehttp.NewClient()
 //	client.RequestLogHook = func(logger retryablehttp.Logger, req *http.Request, retry int) {
 //		req.Header.Set("User-Agent", "My bot (user@example.com)")
 //	}
+//
+// To download from mirrors of the dumps site, configure Client with UseMirrors.
 type ProcessDumpConfig struct {
 	URL                    string
 	Path                   string
@@ -26,4 +38,10 @@ type ProcessDumpConfig struct {
 	DecodingThreads        int
 	ItemsProcessingThreads int
 	Progress               func(context.Context, x.Progress)
+	Shard                  int
+	Shards                 int
+	Logger                 *slog.Logger
+	Observer               Observer
+	Checksum               *ChecksumConfig
+	CheckpointConfig       *CheckpointConfig
 }
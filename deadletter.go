@@ -0,0 +1,244 @@
+package mediawiki
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"github.com/pingcap/tidb/pkg/parser"
+	"github.com/pingcap/tidb/pkg/parser/ast"
+	"github.com/pingcap/tidb/pkg/parser/format"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/x"
+)
+
+const (
+	deadLetterJSON = "json"
+	deadLetterXML  = "xml"
+	deadLetterSQL  = "sql"
+
+	// maxDeadLetterSize is the maximum size of one dead-letter record when replaying.
+	maxDeadLetterSize = 512 * 1024 * 1024
+)
+
+// ErrorPolicy controls what Process does when an item fails to decode or process.
+type ErrorPolicy int
+
+const (
+	// AbortOnError stops processing on the first error.
+	AbortOnError ErrorPolicy = iota
+	// SkipOnError skips items which failed.
+	SkipOnError
+	// DeadLetterOnError skips items which failed and writes them to the dead-letter writer.
+	DeadLetterOnError
+)
+
+// ErrorStage is the stage at which an item failed.
+type ErrorStage string
+
+const (
+	DecodeStage  ErrorStage = "decode"
+	ProcessStage ErrorStage = "process"
+)
+
+// DeadLetter is a record of an item which failed to decode or process.
+//
+// Data is raw data of the item in Format, which is "json", "xml", or "sql". For SQL dumps,
+// items which failed to process are recorded as JSON (the same JSON which was decoded into
+// the item), while statements which failed to parse are recorded as SQL with Columns set
+// to columns of the table (if known at that point). A list of values of an INSERT statement
+// which failed to decode is recorded as an INSERT statement with only that list of values.
+// Index is the index of the item among items of the same row (e.g., a list of values in
+// an INSERT statement) or -1 if the whole row failed. Details do not repeat raw data.
+type DeadLetter struct {
+	Stage      ErrorStage             `json:"stage"`
+	LineNumber int                    `json:"line"`
+	Index      int                    `json:"index"`
+	Format     string                 `json:"format"`
+	Data       []byte                 `json:"data,omitempty"`
+	Columns    []string               `json:"columns,omitempty"`
+	Error      string                 `json:"error"`
+	Details    map[string]interface{} `json:"details,omitempty"`
+}
+
+// errorHandler applies the error policy to errors of individual items.
+type errorHandler struct {
+	policy   ErrorPolicy
+	mu       sync.Mutex
+	writer   io.Writer
+	onError  func(context.Context, DeadLetter)
+	logger   *slog.Logger
+	observer Observer
+}
+
+func newErrorHandler(
+	policy ErrorPolicy, writer io.Writer, onError func(context.Context, DeadLetter), logger *slog.Logger, observer Observer,
+) *errorHandler {
+	return &errorHandler{
+		policy:   policy,
+		mu:       sync.Mutex{},
+		writer:   writer,
+		onError:  onError,
+		logger:   logger,
+		observer: observer,
+	}
+}
+
+// keepData returns true if raw data of items has to be kept for dead-letter records.
+func (h *errorHandler) keepData() bool {
+	return h.policy == DeadLetterOnError
+}
+
+// handle returns nil if the error has been handled according to the error policy
+// and the item should be skipped. Otherwise it returns the error which should stop processing.
+func (h *errorHandler) handle(ctx context.Context, errE errors.E, deadLetter DeadLetter) errors.E {
+	if errors.Is(errE, context.Canceled) || errors.Is(errE, context.DeadlineExceeded) {
+		return errE
+	}
+
+	h.observer.ItemFailed(ctx, deadLetter.Stage)
+
+	if h.policy == AbortOnError {
+		return errE
+	}
+
+	deadLetter.Error = errE.Error()
+	deadLetter.Details = errors.AllDetails(errE)
+	// Raw data is already in Data.
+	delete(deadLetter.Details, "row")
+
+	h.logger.WarnContext(ctx, "skipping item",
+		"stage", deadLetter.Stage, "line", deadLetter.LineNumber, "index", deadLetter.Index, "error", errE,
+	)
+
+	if h.policy == DeadLetterOnError {
+		data, errE := x.MarshalWithoutEscapeHTML(deadLetter)
+		if errE != nil {
+			return errors.WithMessage(errE, "dead letter")
+		}
+		data = append(data, '\n')
+		h.mu.Lock()
+		_, err := h.writer.Write(data)
+		h.mu.Unlock()
+		if err != nil {
+			return errors.WithMessage(err, "dead letter write")
+		}
+	}
+
+	if h.onError != nil {
+		h.onError(ctx, deadLetter)
+	}
+	return nil
+}
+
+// processDeadLetter returns dead-letter record for an item which failed to process.
+func processDeadLetter[T any](i OutputData[T]) DeadLetter {
+	return DeadLetter{
+		Stage:      ProcessStage,
+		LineNumber: i.LineNumber,
+		Index:      i.Index,
+		Format:     i.format,
+		Data:       i.data,
+		Columns:    nil,
+		Error:      "",
+		Details:    nil,
+	}
+}
+
+// sqlInsertTuple returns SQL of the INSERT statement with only one list of values.
+func sqlInsertTuple(stmt *ast.InsertStmt, values []ast.ExprNode) ([]byte, errors.E) {
+	s := *stmt
+	s.Lists = [][]ast.ExprNode{values}
+	var b strings.Builder
+	err := s.Restore(format.NewRestoreCtx(format.DefaultRestoreFlags|format.RestoreStringEscapeBackslash, &b))
+	if err != nil {
+		return nil, errors.WithMessage(err, "restore insert")
+	}
+	return []byte(b.String()), nil
+}
+
+// ReplayDeadLetters reads dead-letter records written by Process with DeadLetterOnError
+// error policy, decodes their data again, and calls process on every decoded item.
+// It stops on the first error.
+func ReplayDeadLetters[T any](ctx context.Context, reader io.Reader, process func(context.Context, T) errors.E) errors.E {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(nil, maxDeadLetterSize)
+	sqlParser := parser.New()
+	record := 0
+	for scanner.Scan() {
+		record++
+		if ctx.Err() != nil {
+			return errors.WithStack(ctx.Err())
+		}
+		var deadLetter DeadLetter
+		errE := x.UnmarshalWithoutUnknownFields(scanner.Bytes(), &deadLetter)
+		if errE != nil {
+			errors.Details(errE)["record"] = record
+			return errE
+		}
+		errE = replayDeadLetter(ctx, sqlParser, &deadLetter, process)
+		if errE != nil {
+			errors.Details(errE)["record"] = record
+			errors.Details(errE)["line"] = deadLetter.LineNumber
+			return errE
+		}
+	}
+	err := scanner.Err()
+	if err != nil {
+		return errors.WithMessage(err, "scan")
+	}
+	return nil
+}
+
+func replayDeadLetter[T any](
+	ctx context.Context, sqlParser *parser.Parser, deadLetter *DeadLetter, process func(context.Context, T) errors.E,
+) errors.E {
+	switch deadLetter.Format {
+	case deadLetterJSON:
+		e, errE := decodeJSON[T](deadLetter.Data)
+		if errE != nil {
+			return errE
+		}
+		return process(ctx, e)
+	case deadLetterXML:
+		e, errE := decodeXML[T](deadLetter.Data)
+		if errE != nil {
+			return errE
+		}
+		return process(ctx, e)
+	case deadLetterSQL:
+		stmt, err := sqlParser.ParseOneStmt(string(deadLetter.Data), "", "")
+		if err != nil {
+			return errors.Prefix(err, ErrSQLParse)
+		}
+		s, ok := stmt.(*ast.InsertStmt)
+		if !ok {
+			errE := errors.WithMessage(ErrUnexpectedType, "statement")
+			errors.Details(errE)["type"] = fmt.Sprintf("%T", stmt)
+			return errE
+		}
+		for _, r := range s.Lists {
+			d, errE := sqlInsertValues(deadLetter.Columns, r)
+			if errE != nil {
+				return errE
+			}
+			e, errE := decodeJSON[T](d)
+			if errE != nil {
+				return errE
+			}
+			errE = process(ctx, e)
+			if errE != nil {
+				return errE
+			}
+		}
+		return nil
+	default:
+		errE := errors.WithMessage(ErrInvalidValue, "dead letter format")
+		errors.Details(errE)["value"] = deadLetter.Format
+		return errE
+	}
+}
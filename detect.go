@@ -0,0 +1,285 @@
+package mediawiki
+
+import (
+	"bufio"
+	"bytes"
+	"net/url"
+	"path"
+	"strings"
+
+	"gitlab.com/tozd/go/errors"
+)
+
+const (
+	// tarMagicOffset is the offset of "ustar" magic in the tar header block.
+	tarMagicOffset = 257
+	// compressionSniffSize is the length of the longest compression magic.
+	compressionSniffSize = 6
+	// fileTypeSniffSize is how many bytes at most are peeked to find the first non-whitespace byte.
+	fileTypeSniffSize = 4096
+	// sqlPrefixMaxLength is the length of the longest prefix in sqlPrefixes.
+	sqlPrefixMaxLength = 6
+)
+
+var (
+	bzip2Magic = []byte("BZh")
+	gzipMagic  = []byte{0x1f, 0x8b}
+	zstdMagic  = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	xzMagic    = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+	tarMagic   = []byte("ustar")
+	utf8BOM    = []byte{0xef, 0xbb, 0xbf}
+
+	sqlPrefixes = [][]byte{
+		[]byte("--"),
+		[]byte("/*"),
+		[]byte("CREATE"),
+		[]byte("DROP"),
+		[]byte("INSERT"),
+		[]byte("LOCK"),
+		[]byte("SET"),
+	}
+)
+
+// String returns the name of the file type.
+func (t FileType) String() string {
+	switch t {
+	case JSONArray:
+		return "JSONArray"
+	case NDJSON:
+		return "NDJSON"
+	case SQLDump:
+		return "SQLDump"
+	case XML:
+		return "XML"
+	case XMLRevision:
+		return "XMLRevision"
+	case AutoFileType:
+		return "AutoFileType"
+	}
+	return "unknown"
+}
+
+// String returns the name of the compression.
+func (c Compression) String() string {
+	switch c {
+	case NoCompression:
+		return "NoCompression"
+	case Tar:
+		return "Tar"
+	case BZIP2:
+		return "BZIP2"
+	case BZIP2Tar:
+		return "BZIP2Tar"
+	case GZIP:
+		return "GZIP"
+	case GZIPTar:
+		return "GZIPTar"
+	case ZSTD:
+		return "ZSTD"
+	case ZSTDTar:
+		return "ZSTDTar"
+	case XZ:
+		return "XZ"
+	case XZTar:
+		return "XZTar"
+	case AutoCompression:
+		return "AutoCompression"
+	}
+	return "unknown"
+}
+
+// withoutTar returns the compression of the stream without the tar archive.
+func (c Compression) withoutTar() Compression {
+	switch c {
+	case Tar:
+		return NoCompression
+	case BZIP2Tar:
+		return BZIP2
+	case GZIPTar:
+		return GZIP
+	case ZSTDTar:
+		return ZSTD
+	case XZTar:
+		return XZ
+	case NoCompression, BZIP2, GZIP, ZSTD, XZ, AutoCompression:
+	}
+	return c
+}
+
+// withTar returns the compression of the stream with the tar archive.
+func (c Compression) withTar() Compression {
+	switch c {
+	case NoCompression:
+		return Tar
+	case BZIP2:
+		return BZIP2Tar
+	case GZIP:
+		return GZIPTar
+	case ZSTD:
+		return ZSTDTar
+	case XZ:
+		return XZTar
+	case Tar, BZIP2Tar, GZIPTar, ZSTDTar, XZTar, AutoCompression:
+	}
+	return c
+}
+
+// sniffCompression detects the compression from magic bytes at the start of the
+// compressed stream. It returns false if there is no data to detect from.
+func sniffCompression(header []byte) (Compression, bool) {
+	switch {
+	case len(header) == 0:
+		return NoCompression, false
+	case bytes.HasPrefix(header, bzip2Magic):
+		return BZIP2, true
+	case bytes.HasPrefix(header, gzipMagic):
+		return GZIP, true
+	case bytes.HasPrefix(header, zstdMagic):
+		return ZSTD, true
+	case bytes.HasPrefix(header, xzMagic):
+		return XZ, true
+	default:
+		return NoCompression, true
+	}
+}
+
+// sniffTar detects if the decompressed stream is a tar archive. Both POSIX
+// and GNU tar archives contain "ustar" magic in the header of the first file.
+func sniffTar(reader *bufio.Reader) bool {
+	header, _ := reader.Peek(tarMagicOffset + len(tarMagic))
+	return len(header) >= tarMagicOffset+len(tarMagic) && bytes.Equal(header[tarMagicOffset:tarMagicOffset+len(tarMagic)], tarMagic)
+}
+
+// sniffFileType detects the file type from the first non-whitespace byte
+// of the decompressed stream. It returns false if it cannot detect it.
+//
+// It peeks only as much as necessary so that it does not wait for more data
+// than needed when data is streamed.
+func sniffFileType(reader *bufio.Reader) (FileType, bool) {
+	for n := 1; n <= fileTypeSniffSize; n++ {
+		data, _ := reader.Peek(n)
+		if len(data) < n {
+			return JSONArray, false
+		}
+		switch data[n-1] {
+		case ' ', '\t', '\r', '\n', utf8BOM[0], utf8BOM[1], utf8BOM[2]:
+			continue
+		case '[':
+			return JSONArray, true
+		case '{':
+			return NDJSON, true
+		case '<':
+			return XML, true
+		}
+		data, _ = reader.Peek(n - 1 + sqlPrefixMaxLength)
+		data = data[n-1:]
+		for _, prefix := range sqlPrefixes {
+			if bytes.HasPrefix(data, prefix) {
+				return SQLDump, true
+			}
+		}
+		return JSONArray, false
+	}
+	return JSONArray, false
+}
+
+// fileName returns the file name from Path or URL (without query string).
+func fileName(filePath, fileURL string) string {
+	if filePath != "" {
+		return path.Base(filePath)
+	}
+	u, err := url.Parse(fileURL)
+	if err != nil {
+		return path.Base(fileURL)
+	}
+	return path.Base(u.Path)
+}
+
+// compressionFromName detects the compression from the file extension.
+func compressionFromName(name string) Compression {
+	name = strings.ToLower(name)
+	compression := NoCompression
+	switch ext := path.Ext(name); ext {
+	case ".bz2":
+		compression = BZIP2
+	case ".gz":
+		compression = GZIP
+	case ".zst":
+		compression = ZSTD
+	case ".xz":
+		compression = XZ
+	}
+	if compression != NoCompression {
+		name = strings.TrimSuffix(name, path.Ext(name))
+	}
+	if path.Ext(name) == ".tar" {
+		return compression.withTar()
+	}
+	return compression
+}
+
+// fileTypeFromName detects the file type from the file extension.
+// It returns false if it cannot detect it.
+func fileTypeFromName(name string) (FileType, bool) {
+	name = strings.ToLower(name)
+	for {
+		switch path.Ext(name) {
+		case ".bz2", ".gz", ".zst", ".xz", ".tar":
+			name = strings.TrimSuffix(name, path.Ext(name))
+			continue
+		case ".json":
+			return JSONArray, true
+		case ".ndjson", ".jsonl":
+			return NDJSON, true
+		case ".sql":
+			return SQLDump, true
+		case ".xml":
+			return XML, true
+		}
+		return JSONArray, false
+	}
+}
+
+// resolveCompression combines the configured compression with the detected one.
+// If the configured compression is AutoCompression, the detected compression is
+// returned. Otherwise an error is returned if they do not match.
+func resolveCompression(configured, detected Compression) (Compression, errors.E) {
+	if configured == AutoCompression || configured == detected {
+		return detected, nil
+	}
+	errE := errors.WithMessage(ErrFormatMismatch, "compression")
+	errors.Details(errE)["configured"] = configured.String()
+	errors.Details(errE)["detected"] = detected.String()
+	return configured, errE
+}
+
+// resolveFileType combines the configured file type with the detected one.
+// If the configured file type is AutoFileType, the detected file type is used and
+// if the file type could not be detected from contents, the file type from the file
+// name is used. Otherwise an error is returned if the configured and detected file
+// types do not match.
+func resolveFileType(configured, detected FileType, sniffed bool, name string) (FileType, errors.E) {
+	if !sniffed {
+		if configured != AutoFileType {
+			return configured, nil
+		}
+		fromName, ok := fileTypeFromName(name)
+		if !ok {
+			errE := errors.WithMessage(ErrUnknownFormat, "file type")
+			errors.Details(errE)["name"] = name
+			return configured, errE
+		}
+		return fromName, nil
+	}
+	if configured == AutoFileType || configured == detected {
+		return detected, nil
+	}
+	if configured == XMLRevision && detected == XML {
+		// Both are XML, they differ only in what is one item.
+		return configured, nil
+	}
+	errE := errors.WithMessage(ErrFormatMismatch, "file type")
+	errors.Details(errE)["configured"] = configured.String()
+	errors.Details(errE)["detected"] = detected.String()
+	return configured, errE
+}
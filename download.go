@@ -0,0 +1,436 @@
+package mediawiki
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/hashicorp/go-retryablehttp"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/x"
+)
+
+// errUpstreamChanged is returned when the file at URL has changed since it was partially downloaded.
+var errUpstreamChanged = errors.Base("file changed since partially downloaded")
+
+// rangeResponse reads the file at URL starting at an offset using Range requests.
+//
+// If reading fails before the end of the file, it transparently retries the request
+// with Range request header from the current position, similar to x.RetryableResponse.
+//
+// If ifRange is set, it is sent as If-Range request header. If the file has changed
+// since (and the server responds with the whole file), errUpstreamChanged is returned.
+type rangeResponse struct {
+	ctx      context.Context //nolint:containedctx
+	client   *retryablehttp.Client
+	url      string
+	ifRange  string
+	position int64
+	size     int64
+	body     io.ReadCloser
+	// mirror is the mirror which served the last response, if any (see MirrorTransport).
+	mirror string
+}
+
+func newRangeResponse(ctx context.Context, client *retryablehttp.Client, url, ifRange string, offset int64) (*rangeResponse, errors.E) {
+	r := &rangeResponse{
+		ctx:      ctx,
+		client:   client,
+		url:      url,
+		ifRange:  ifRange,
+		position: offset,
+		size:     -1,
+		body:     nil,
+		mirror:   "",
+	}
+	errE := r.start()
+	if errE != nil {
+		return nil, errE
+	}
+	return r, nil
+}
+
+func (r *rangeResponse) start() errors.E {
+	if r.body != nil {
+		r.body.Close()
+		r.body = nil
+	}
+	req, err := retryablehttp.NewRequestWithContext(r.ctx, http.MethodGet, r.url, nil)
+	if err != nil {
+		errE := errors.WithMessage(err, "new request")
+		errors.Details(errE)["url"] = r.url
+		return errE
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-", r.position))
+	if r.ifRange != "" {
+		req.Header.Set("If-Range", r.ifRange)
+	}
+	resp, err := r.client.Do(req) //nolint:bodyclose
+	if err != nil {
+		errE := errors.WithMessage(err, "do")
+		errors.Details(errE)["url"] = r.url
+		return errE
+	}
+	if resp.StatusCode == http.StatusOK && r.ifRange != "" {
+		resp.Body.Close()
+		return errors.WithDetails(errUpstreamChanged, "url", r.url)
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return errors.WithDetails(
+			x.ErrResponseBadStatus,
+			"status", resp.Status,
+			"body", strings.TrimSpace(string(body)),
+			"url", r.url,
+		)
+	}
+	var start, end, size int64
+	_, err = fmt.Sscanf(resp.Header.Get("Content-Range"), "bytes %d-%d/%d", &start, &end, &size)
+	if err != nil || start != r.position || (r.size >= 0 && size != r.size) {
+		resp.Body.Close()
+		errE := errors.WithMessage(ErrInvalidValue, "content range")
+		errors.Details(errE)["url"] = r.url
+		errors.Details(errE)["value"] = resp.Header.Get("Content-Range")
+		return errE
+	}
+	r.size = size
+	r.body = resp.Body
+	r.mirror = resp.Header.Get(MirrorHeader)
+	return nil
+}
+
+// Read implements io.Reader for rangeResponse.
+func (r *rangeResponse) Read(p []byte) (int, error) {
+	if r.body == nil {
+		return 0, errors.WithStack(x.ErrResponseClosed)
+	}
+	n, err := r.body.Read(p)
+	r.position += int64(n)
+	if r.position >= r.size || err == nil {
+		if err == io.EOF { //nolint:errorlint
+			// See: https://github.com/golang/go/issues/39155
+			return n, io.EOF
+		}
+		return n, errors.WithStack(err)
+	}
+	if contextErr := r.ctx.Err(); contextErr != nil {
+		// Do not retry on context.Canceled or context.DeadlineExceeded.
+		return n, errors.WithStack(contextErr)
+	}
+	// We have not read everything, but we got an error. We retry.
+	errE := r.start()
+	if errE != nil {
+		return n, errE
+	}
+	return n, nil
+}
+
+// Size returns the size of the whole file.
+func (r *rangeResponse) Size() int64 {
+	return r.size
+}
+
+// Close implements io.Closer interface for rangeResponse.
+func (r *rangeResponse) Close() error {
+	if r.body == nil {
+		return nil
+	}
+	err := r.body.Close()
+	r.body = nil
+	return errors.WithStack(err)
+}
+
+// partialDownloadMetadata is stored next to a partially downloaded file,
+// so that the download can be resumed if the file at URL has not changed.
+type partialDownloadMetadata struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+	Size         int64  `json:"size"`
+}
+
+// validator returns the value for If-Range request header or an empty string
+// if the download cannot be resumed safely.
+func (m *partialDownloadMetadata) validator() string {
+	// Weak ETags cannot be used with If-Range.
+	if m.ETag != "" && !strings.HasPrefix(m.ETag, "W/") {
+		return m.ETag
+	}
+	return m.LastModified
+}
+
+// pathDownload downloads the file at URL to Path, through a partial file at Path+".partial".
+//
+// If the download is interrupted, the partial file is kept together with metadata
+// at Path+".partial.json" and the next pathDownload resumes the download using a Range
+// request, if the file at URL has not changed since (based on its ETag or Last-Modified).
+// Reading returns the whole file, first data already downloaded and then the rest
+// while it is being downloaded. When the whole file has been downloaded, Close renames
+// the partial file to Path.
+//
+// If checksum is provided, the hash of the file is computed while it is being downloaded
+// and Close verifies it before moving the file to Path.
+type pathDownload struct {
+	path     string
+	file     *os.File
+	response io.ReadCloser
+	reader   io.Reader
+	size     int64
+	checksum *downloadChecksum
+	// mirror is the mirror which served the download, if any (see MirrorTransport).
+	mirror string
+}
+
+// downloadChecksum is the expected checksum of a downloaded file.
+type downloadChecksum struct {
+	expected   string
+	quarantine bool
+	hash       hash.Hash
+	// hashed is the number of bytes from the start of the file hashed so far.
+	hashed int64
+}
+
+// Write implements io.Writer for downloadChecksum.
+func (c *downloadChecksum) Write(p []byte) (int, error) {
+	n, err := c.hash.Write(p)
+	c.hashed += int64(n)
+	return n, err //nolint:wrapcheck
+}
+
+func partialPath(path string) string {
+	return path + ".partial"
+}
+
+func partialMetadataPath(path string) string {
+	return partialPath(path) + ".json"
+}
+
+func readPartialDownloadMetadata(path string) *partialDownloadMetadata {
+	data, err := os.ReadFile(partialMetadataPath(path))
+	if err != nil {
+		return nil
+	}
+	var metadata partialDownloadMetadata
+	err = json.Unmarshal(data, &metadata)
+	if err != nil {
+		return nil
+	}
+	return &metadata
+}
+
+func writePartialDownloadMetadata(path string, metadata *partialDownloadMetadata) errors.E {
+	data, errE := x.MarshalWithoutEscapeHTML(metadata)
+	if errE != nil {
+		return errE
+	}
+	err := os.WriteFile(partialMetadataPath(path), data, 0o644) //nolint:gosec
+	if err != nil {
+		errE := errors.WithMessage(err, "write partial download metadata")
+		errors.Details(errE)["path"] = partialMetadataPath(path)
+		return errE
+	}
+	return nil
+}
+
+// newPathDownload starts or resumes downloading the file at url to path.
+//
+// Reading starts at offset. Data before offset is not read, if it has already been downloaded.
+// It returns the position at which reading starts (offset or 0).
+func newPathDownload(
+	ctx context.Context, client *retryablehttp.Client, url, path string, offset int64, checksum *downloadChecksum,
+) (*pathDownload, int64, errors.E) {
+	file, err := os.OpenFile(partialPath(path), os.O_RDWR|os.O_CREATE, 0o644) //nolint:gosec
+	if err != nil {
+		errE := errors.WithMessage(err, "open")
+		errors.Details(errE)["path"] = partialPath(path)
+		return nil, 0, errE
+	}
+	d := &pathDownload{
+		path:     path,
+		file:     file,
+		response: nil,
+		reader:   nil,
+		size:     0,
+		checksum: checksum,
+		mirror:   "",
+	}
+	start, errE := d.start(ctx, client, url, offset)
+	if errE == nil && checksum != nil {
+		errE = d.startChecksum(start)
+	}
+	if errE != nil {
+		if d.response != nil {
+			d.response.Close()
+		}
+		d.file.Close()
+		return nil, 0, errE
+	}
+	return d, start, nil
+}
+
+// startChecksum hashes already downloaded data before start (which is not read)
+// and then hashes all data read.
+func (d *pathDownload) startChecksum(start int64) errors.E {
+	_, err := io.Copy(d.checksum, io.NewSectionReader(d.file, 0, start))
+	if err != nil {
+		errE := errors.WithMessage(err, "checksum")
+		errors.Details(errE)["path"] = partialPath(d.path)
+		return errE
+	}
+	d.reader = io.TeeReader(d.reader, d.checksum)
+	return nil
+}
+
+func (d *pathDownload) start(ctx context.Context, client *retryablehttp.Client, url string, offset int64) (int64, errors.E) {
+	partialSize, err := d.file.Seek(0, io.SeekEnd)
+	if err != nil {
+		errE := errors.WithMessage(err, "seek end")
+		errors.Details(errE)["path"] = partialPath(d.path)
+		return 0, errE
+	}
+
+	metadata := readPartialDownloadMetadata(d.path)
+	if metadata != nil && metadata.URL == url && partialSize > 0 && partialSize <= metadata.Size && metadata.validator() != "" {
+		if partialSize < metadata.Size {
+			rangeReader, errE := newRangeResponse(ctx, client, url, metadata.validator(), partialSize)
+			if errE == nil && rangeReader.Size() != metadata.Size {
+				rangeReader.Close()
+				errE = errors.WithDetails(errUpstreamChanged, "url", url)
+			}
+			if errE != nil && !errors.Is(errE, errUpstreamChanged) {
+				return 0, errE
+			}
+			if errE == nil {
+				d.response = rangeReader
+				d.mirror = rangeReader.mirror
+			}
+		}
+		if d.response != nil || partialSize == metadata.Size {
+			// We resume (or just read the already completely downloaded file).
+			d.size = metadata.Size
+			start := int64(0)
+			if offset <= partialSize {
+				start = offset
+			}
+			readers := []io.Reader{io.NewSectionReader(d.file, start, partialSize-start)}
+			if d.response != nil {
+				// Reading from the section reader does not change the file offset,
+				// which is at the end of the file, so we can append to it.
+				readers = append(readers, io.TeeReader(d.response, d.file))
+			}
+			d.reader = io.MultiReader(readers...)
+			return start, nil
+		}
+	}
+
+	// We start the download from the beginning.
+	err = d.file.Truncate(0)
+	if err != nil {
+		errE := errors.WithMessage(err, "truncate")
+		errors.Details(errE)["path"] = partialPath(d.path)
+		return 0, errE
+	}
+	_, err = d.file.Seek(0, io.SeekStart)
+	if err != nil {
+		errE := errors.WithMessage(err, "seek start")
+		errors.Details(errE)["path"] = partialPath(d.path)
+		return 0, errE
+	}
+	req, err := retryablehttp.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		errE := errors.WithMessage(err, "new request")
+		errors.Details(errE)["url"] = url
+		return 0, errE
+	}
+	downloadReader, errE := x.NewRetryableResponse(client, req)
+	if errE != nil {
+		errors.Details(errE)["url"] = url
+		return 0, errE
+	}
+	d.response = downloadReader
+	d.mirror = downloadReader.Header.Get(MirrorHeader)
+	d.size = downloadReader.Size()
+	errE = writePartialDownloadMetadata(d.path, &partialDownloadMetadata{
+		URL:          url,
+		ETag:         downloadReader.Header.Get("ETag"),
+		LastModified: downloadReader.Header.Get("Last-Modified"),
+		Size:         d.size,
+	})
+	if errE != nil {
+		return 0, errE
+	}
+	d.reader = io.TeeReader(downloadReader, d.file)
+	return 0, nil
+}
+
+// Read implements io.Reader for pathDownload.
+func (d *pathDownload) Read(p []byte) (int, error) {
+	return d.reader.Read(p) //nolint:wrapcheck
+}
+
+// Size returns the size of the whole file.
+func (d *pathDownload) Size() int64 {
+	return d.size
+}
+
+// Close implements io.Closer interface for pathDownload.
+//
+// If the whole file has been downloaded, it is moved to Path.
+// Otherwise the partial file is kept so that the download can be resumed.
+func (d *pathDownload) Close() error {
+	if d.response != nil {
+		d.response.Close()
+	}
+	if d.checksum != nil && d.checksum.hashed < d.size {
+		// Not everything has been read, so we hash the rest of the (possibly complete) file.
+		_, _ = io.Copy(d.checksum, io.NewSectionReader(d.file, d.checksum.hashed, d.size-d.checksum.hashed))
+	}
+	info, err := d.file.Stat()
+	errClose := d.file.Close()
+	if err != nil {
+		errE := errors.WithMessage(err, "stat")
+		errors.Details(errE)["path"] = partialPath(d.path)
+		return errE
+	}
+	if errClose != nil {
+		errE := errors.WithMessage(errClose, "close")
+		errors.Details(errE)["path"] = partialPath(d.path)
+		return errE
+	}
+	if info.Size() != d.size {
+		// Incomplete file. Keep it to resume later.
+		return nil
+	}
+	if d.checksum != nil {
+		errE := d.verifyChecksum()
+		if errE != nil {
+			return errE
+		}
+	}
+	err = os.Rename(partialPath(d.path), d.path)
+	if err != nil {
+		errE := errors.WithMessage(err, "rename")
+		errors.Details(errE)["path"] = d.path
+		return errE
+	}
+	_ = os.Remove(partialMetadataPath(d.path))
+	return nil
+}
+
+// verifyChecksum verifies the checksum of the complete partial file. On mismatch, the partial file
+// is deleted (or quarantined), so that the next download starts from the beginning.
+func (d *pathDownload) verifyChecksum() errors.E {
+	errE := checksumMismatch(d.checksum.hash, d.checksum.expected)
+	if errE == nil {
+		return nil
+	}
+	errors.Details(errE)["path"] = d.path
+	_ = os.Remove(partialMetadataPath(d.path))
+	return rejectFile(errE, partialPath(d.path), d.path, d.checksum.quarantine)
+}
@@ -0,0 +1,404 @@
+package mediawiki_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"  //nolint:gosec
+	"crypto/sha1" //nolint:gosec
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-retryablehttp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/go/errors"
+
+	"github.com/citadel2024/go-mediawiki"
+)
+
+func TestResumableDownload(t *testing.T) {
+	t.Parallel()
+
+	const count = 200000
+
+	makeDump := func(start int) []byte {
+		var ndjson bytes.Buffer
+		for i := range count {
+			fmt.Fprintf(&ndjson, `{"n":%d}`+"\n", start+i)
+		}
+		return ndjson.Bytes()
+	}
+
+	type request struct {
+		rangeHeader   string
+		ifRangeHeader string
+	}
+
+	for _, test := range []struct {
+		changed bool
+		auto    bool
+	}{
+		{false, false},
+		{true, false},
+		// With auto-detection, offsets are not recorded and the format is sniffed
+		// from the start of the resumed partial file.
+		{false, true},
+		{true, true},
+	} {
+		changed := test.changed
+		t.Run(fmt.Sprintf("changed=%t/auto=%t", changed, test.auto), func(t *testing.T) {
+			t.Parallel()
+
+			var mu sync.Mutex
+			data := makeDump(0)
+			etag := `"v1"`
+			requests := []request{}
+
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				d, e := data, etag
+				if r.URL.Path == "/sha1sums.txt" {
+					mu.Unlock()
+					sum := sha1.Sum(d) //nolint:gosec
+					fmt.Fprintf(w, "%s  dump.ndjson\n", hex.EncodeToString(sum[:]))
+					return
+				}
+				requests = append(requests, request{r.Header.Get("Range"), r.Header.Get("If-Range")})
+				mu.Unlock()
+				w.Header().Set("ETag", e)
+				// ServeContent supports Range and If-Range requests.
+				http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(d))
+			}))
+			t.Cleanup(ts.Close)
+
+			path := filepath.Join(t.TempDir(), "dump.ndjson")
+
+			process := func(store mediawiki.CheckpointStore, stopAt int64) (map[int]bool, errors.E) {
+				var mu sync.Mutex
+				var processed atomic.Int64
+				seen := map[int]bool{}
+				fileType, compression := mediawiki.NDJSON, mediawiki.NoCompression
+				if test.auto {
+					fileType, compression = mediawiki.AutoFileType, mediawiki.AutoCompression
+				}
+				errE := mediawiki.Process(context.Background(), &mediawiki.ProcessConfig[testNumber]{
+					URL:         ts.URL + "/dump.ndjson",
+					Path:        path,
+					Checksum:    &mediawiki.ChecksumConfig{URL: ts.URL + "/sha1sums.txt"},
+					Client:      retryablehttp.NewClient(),
+					FileType:    fileType,
+					Compression: compression,
+					Process: func(_ context.Context, i testNumber) errors.E {
+						if stopAt > 0 && processed.Add(1) > stopAt {
+							return errors.New("stop")
+						}
+						mu.Lock()
+						defer mu.Unlock()
+						seen[i.N] = true
+						return nil
+					},
+					CheckpointConfig: &mediawiki.CheckpointConfig{
+						SaveInterval:   time.Minute,
+						ItemsThreshold: 100,
+						Store:          store,
+					},
+				})
+				return seen, errE
+			}
+
+			store := mediawiki.NewMemoryCheckpointStore()
+
+			first, errE := process(store, 1000)
+			require.Error(t, errE)
+
+			_, err := os.Stat(path)
+			assert.ErrorIs(t, err, os.ErrNotExist)
+			info, err := os.Stat(path + ".partial")
+			require.NoError(t, err)
+			partialSize := info.Size()
+			assert.Positive(t, partialSize)
+			assert.Less(t, partialSize, int64(len(data)))
+			assert.FileExists(t, path+".partial.json")
+
+			if changed {
+				mu.Lock()
+				data = makeDump(count)
+				etag = `"v2"`
+				mu.Unlock()
+				// The checkpoint belongs to the old dump.
+				store = mediawiki.NewMemoryCheckpointStore()
+			}
+
+			mu.Lock()
+			requests = []request{}
+			mu.Unlock()
+
+			seen, errE := process(store, 0)
+			require.NoError(t, errE, "% -+#.1v", errE)
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			if changed {
+				assert.Len(t, seen, count)
+				assert.True(t, seen[count])
+				// The fingerprint request, the resume request answered with the whole file,
+				// and the download from the beginning.
+				assert.Equal(t, []request{
+					{fmt.Sprintf("bytes=0-%d", 64*1024-1), ""},
+					{fmt.Sprintf("bytes=%d-", partialSize), `"v1"`},
+					{"", ""},
+				}, requests)
+			} else {
+				// Items processed in the first run are not processed again.
+				assert.Less(t, len(seen), count)
+				assert.True(t, seen[count-1])
+				// But no item is skipped.
+				for n := range count {
+					if !first[n] && !seen[n] {
+						assert.Fail(t, "item not processed", n)
+						break
+					}
+				}
+				// The fingerprint request and the resume request.
+				assert.Equal(t, []request{
+					{fmt.Sprintf("bytes=0-%d", 64*1024-1), ""},
+					{fmt.Sprintf("bytes=%d-", partialSize), `"v1"`},
+				}, requests)
+			}
+
+			downloaded, err := os.ReadFile(path)
+			require.NoError(t, err)
+			assert.Equal(t, data, downloaded)
+			assert.NoFileExists(t, path+".partial")
+			assert.NoFileExists(t, path+".partial.json")
+		})
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	t.Parallel()
+
+	var ndjson bytes.Buffer
+	for i := range 1000 {
+		fmt.Fprintf(&ndjson, `{"n":%d}`+"\n", i)
+	}
+	data := ndjson.Bytes()
+	sha1Sum := sha1.Sum(data) //nolint:gosec
+	md5Sum := md5.Sum(data)   //nolint:gosec
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/enwiki/20240901/enwiki-20240901-items.ndjson", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	})
+	mux.HandleFunc("/enwiki/20240901/enwiki-20240901-sha1sums.txt", func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprintf(w, "%s  enwiki-20240901-other.xml.bz2\n", hex.EncodeToString(make([]byte, sha1.Size)))
+		fmt.Fprintf(w, "%s  enwiki-20240901-items.ndjson\n", hex.EncodeToString(sha1Sum[:]))
+	})
+	mux.HandleFunc("/enwiki/20240901/enwiki-20240901-md5sums.txt", func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprintf(w, "%s *enwiki-20240901-items.ndjson\n", hex.EncodeToString(md5Sum[:]))
+	})
+	mux.HandleFunc("/bad-sha1sums.txt", func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprintf(w, "%s  enwiki-20240901-items.ndjson\n", hex.EncodeToString(make([]byte, sha1.Size)))
+	})
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+
+	dumpURL := ts.URL + "/enwiki/20240901/enwiki-20240901-items.ndjson"
+
+	sha1URL, errE := mediawiki.DumpChecksumsURL(dumpURL, "sha1")
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, ts.URL+"/enwiki/20240901/enwiki-20240901-sha1sums.txt", sha1URL)
+	md5URL, errE := mediawiki.DumpChecksumsURL(dumpURL, "md5")
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, ts.URL+"/enwiki/20240901/enwiki-20240901-md5sums.txt", md5URL)
+	_, errE = mediawiki.DumpChecksumsURL(ts.URL+"/other/enterprise_html/runs/20240901/enwiki-NS0-20240901-ENTERPRISE-HTML.json.tar.gz", "md5")
+	assert.ErrorIs(t, errE, mediawiki.ErrInvalidValue)
+
+	processWith := func(
+		path string, checksum *mediawiki.ChecksumConfig, store mediawiki.CheckpointStore, processItem func(testNumber) errors.E,
+	) errors.E {
+		return mediawiki.Process(context.Background(), &mediawiki.ProcessConfig[testNumber]{
+			URL:         dumpURL,
+			Path:        path,
+			Client:      retryablehttp.NewClient(),
+			FileType:    mediawiki.NDJSON,
+			Compression: mediawiki.NoCompression,
+			Checksum:    checksum,
+			Process: func(_ context.Context, i testNumber) errors.E {
+				return processItem(i)
+			},
+			Ordered:                true,
+			ItemsProcessingThreads: 1,
+			CheckpointConfig: &mediawiki.CheckpointConfig{
+				SaveInterval:   time.Minute,
+				ItemsThreshold: 1,
+				Store:          store,
+			},
+		})
+	}
+	process := func(path string, checksum *mediawiki.ChecksumConfig) errors.E {
+		return processWith(path, checksum, mediawiki.NewMemoryCheckpointStore(), func(testNumber) errors.E {
+			return nil
+		})
+	}
+
+	t.Run("match", func(t *testing.T) {
+		t.Parallel()
+
+		path := filepath.Join(t.TempDir(), "enwiki-20240901-items.ndjson")
+		errE := process(path, &mediawiki.ChecksumConfig{URL: sha1URL})
+		require.NoError(t, errE, "% -+#.1v", errE)
+		assert.FileExists(t, path)
+
+		errE = mediawiki.VerifyChecksum(context.Background(), retryablehttp.NewClient(), sha1URL, path)
+		assert.NoError(t, errE, "% -+#.1v", errE)
+		errE = mediawiki.VerifyChecksum(context.Background(), retryablehttp.NewClient(), md5URL, path)
+		assert.NoError(t, errE, "% -+#.1v", errE)
+
+		errE = mediawiki.VerifyChecksum(context.Background(), retryablehttp.NewClient(), ts.URL+"/bad-sha1sums.txt", path)
+		assert.ErrorIs(t, errE, mediawiki.ErrChecksumMismatch)
+
+		other := filepath.Join(t.TempDir(), "other.ndjson")
+		err := os.WriteFile(other, data, 0o600)
+		require.NoError(t, err)
+		errE = mediawiki.VerifyChecksum(context.Background(), retryablehttp.NewClient(), sha1URL, other)
+		assert.ErrorIs(t, errE, mediawiki.ErrNotFound)
+	})
+
+	t.Run("mismatch", func(t *testing.T) {
+		t.Parallel()
+
+		path := filepath.Join(t.TempDir(), "enwiki-20240901-items.ndjson")
+		errE := process(path, &mediawiki.ChecksumConfig{URL: ts.URL + "/bad-sha1sums.txt"})
+		assert.ErrorIs(t, errE, mediawiki.ErrChecksumMismatch)
+		assert.NoFileExists(t, path)
+		assert.NoFileExists(t, path+".partial")
+		assert.NoFileExists(t, path+".partial.json")
+	})
+
+	t.Run("quarantine", func(t *testing.T) {
+		t.Parallel()
+
+		path := filepath.Join(t.TempDir(), "enwiki-20240901-items.ndjson")
+		errE := process(path, &mediawiki.ChecksumConfig{URL: ts.URL + "/bad-sha1sums.txt", Quarantine: true})
+		assert.ErrorIs(t, errE, mediawiki.ErrChecksumMismatch)
+		assert.NoFileExists(t, path)
+		assert.NoFileExists(t, path+".partial")
+		quarantined, err := os.ReadFile(path + ".quarantine")
+		require.NoError(t, err)
+		assert.Equal(t, data, quarantined)
+	})
+	t.Run("existing", func(t *testing.T) {
+		t.Parallel()
+
+		path := filepath.Join(t.TempDir(), "enwiki-20240901-items.ndjson")
+		err := os.WriteFile(path, data, 0o600)
+		require.NoError(t, err)
+		errE := process(path, &mediawiki.ChecksumConfig{URL: sha1URL})
+		require.NoError(t, errE, "% -+#.1v", errE)
+		assert.FileExists(t, path)
+
+		// The existing file is verified before any of its items are processed.
+		corrupted := bytes.Replace(data, []byte(`{"n":500}`), []byte(`{"n":999}`), 1)
+		err = os.WriteFile(path, corrupted, 0o600)
+		require.NoError(t, err)
+		processed := 0
+		errE = processWith(path, &mediawiki.ChecksumConfig{URL: sha1URL, Quarantine: true}, mediawiki.NewMemoryCheckpointStore(), func(testNumber) errors.E {
+			processed++
+			return nil
+		})
+		assert.ErrorIs(t, errE, mediawiki.ErrChecksumMismatch)
+		assert.Equal(t, 0, processed)
+		assert.NoFileExists(t, path)
+		quarantined, err := os.ReadFile(path + ".quarantine")
+		require.NoError(t, err)
+		assert.Equal(t, corrupted, quarantined)
+	})
+
+	t.Run("stream", func(t *testing.T) {
+		t.Parallel()
+
+		errE := process("", &mediawiki.ChecksumConfig{URL: sha1URL})
+		require.NoError(t, errE, "% -+#.1v", errE)
+
+		errE = process("", &mediawiki.ChecksumConfig{URL: ts.URL + "/bad-sha1sums.txt"})
+		assert.ErrorIs(t, errE, mediawiki.ErrChecksumMismatch)
+	})
+}
+
+func TestChecksumStreamResume(t *testing.T) {
+	t.Parallel()
+
+	var ndjson bytes.Buffer
+	for i := range 1000 {
+		fmt.Fprintf(&ndjson, `{"n":%d}`+"\n", i)
+	}
+	data := ndjson.Bytes()
+	sha1Sum := sha1.Sum(data) //nolint:gosec
+
+	var rangeRequests atomic.Int64
+	mux := http.NewServeMux()
+	mux.HandleFunc("/enwiki-20240901-items.ndjson", func(w http.ResponseWriter, r *http.Request) {
+		// Ranges from the start are requested to fingerprint the dump.
+		if r.Header.Get("Range") != "" && !strings.HasPrefix(r.Header.Get("Range"), "bytes=0-") {
+			rangeRequests.Add(1)
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	})
+	mux.HandleFunc("/sha1sums.txt", func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprintf(w, "%s  enwiki-20240901-items.ndjson\n", hex.EncodeToString(sha1Sum[:]))
+	})
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+
+	store := mediawiki.NewMemoryCheckpointStore()
+	process := func(processItem func(testNumber) errors.E) errors.E {
+		return mediawiki.Process(context.Background(), &mediawiki.ProcessConfig[testNumber]{
+			URL:         ts.URL + "/enwiki-20240901-items.ndjson",
+			Client:      retryablehttp.NewClient(),
+			FileType:    mediawiki.NDJSON,
+			Compression: mediawiki.NoCompression,
+			Checksum:    &mediawiki.ChecksumConfig{URL: ts.URL + "/sha1sums.txt"},
+			Process: func(_ context.Context, i testNumber) errors.E {
+				return processItem(i)
+			},
+			Ordered:                true,
+			ItemsProcessingThreads: 1,
+			CheckpointConfig: &mediawiki.CheckpointConfig{
+				SaveInterval:   time.Minute,
+				ItemsThreshold: 1,
+				Store:          store,
+			},
+		})
+	}
+
+	errE := process(func(i testNumber) errors.E {
+		if i.N == 500 {
+			return errors.New("stop")
+		}
+		return nil
+	})
+	require.Error(t, errE)
+
+	// The file streamed without saving it is read from the start when resuming,
+	// so that its checksum can be verified.
+	processed := []int{}
+	errE = process(func(i testNumber) errors.E {
+		processed = append(processed, i.N)
+		return nil
+	})
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, int64(0), rangeRequests.Load())
+	require.Len(t, processed, 500)
+	assert.Equal(t, 500, processed[0])
+}
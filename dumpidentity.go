@@ -0,0 +1,226 @@
+package mediawiki
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/go-retryablehttp"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/x"
+)
+
+// fingerprintSize is the number of bytes from the start of the file used for the fingerprint.
+const fingerprintSize = 64 * 1024
+
+// DumpIdentity identifies the dump (and the way it is processed) a checkpoint belongs to.
+//
+// Fingerprint is the hex-encoded SHA-256 of the first 64 KiB of the (compressed) file.
+// Together with the file size it detects a different dump (e.g., a new dump run)
+// at the same URL or Path.
+//
+// Threads are recorded for information only and are not compared: resuming at the low
+// watermark of processed rows does not depend on the number of threads used.
+type DumpIdentity struct {
+	URL                    string `json:"url,omitempty"`
+	Path                   string `json:"path,omitempty"`
+	Size                   int64  `json:"size"`
+	Fingerprint            string `json:"fingerprint"`
+	FileType               string `json:"file_type"`
+	Compression            string `json:"compression"`
+	Shard                  int    `json:"shard,omitempty"`
+	Shards                 int    `json:"shards,omitempty"`
+	DecompressionThreads   int    `json:"decompression_threads,omitempty"`
+	DecodingThreads        int    `json:"decoding_threads,omitempty"`
+	ItemsProcessingThreads int    `json:"items_processing_threads,omitempty"`
+}
+
+// mismatch returns names of fields which differ between identities.
+func (d *DumpIdentity) mismatch(other *DumpIdentity) []string {
+	fields := []string{}
+	if d.URL != other.URL {
+		fields = append(fields, "url")
+	}
+	if d.Path != other.Path {
+		fields = append(fields, "path")
+	}
+	if d.Size != other.Size {
+		fields = append(fields, "size")
+	}
+	if d.Fingerprint != other.Fingerprint {
+		fields = append(fields, "fingerprint")
+	}
+	if d.FileType != other.FileType {
+		fields = append(fields, "file_type")
+	}
+	if d.Compression != other.Compression {
+		fields = append(fields, "compression")
+	}
+	if d.Shard != other.Shard || d.Shards != other.Shards {
+		fields = append(fields, "shard")
+	}
+	return fields
+}
+
+// dumpIdentity returns the identity of the dump to be processed with config.
+//
+// If the file at Path exists, the identity is computed from it. Otherwise the start
+// of the file at URL is requested.
+func dumpIdentity[T any](ctx context.Context, config *ProcessConfig[T]) (*DumpIdentity, errors.E) {
+	size, fingerprint, errE := fileFingerprint(config.Path)
+	if errors.Is(errE, os.ErrNotExist) && config.URL != "" {
+		size, fingerprint, errE = urlFingerprint(ctx, config.Client, config.URL)
+	}
+	if errE != nil {
+		return nil, errE
+	}
+	shards := config.Shards
+	if shards <= 1 {
+		// Processing with one shard is the same as processing without shards.
+		shards = 0
+	}
+	return &DumpIdentity{
+		URL:                    config.URL,
+		Path:                   config.Path,
+		Size:                   size,
+		Fingerprint:            fingerprint,
+		FileType:               config.FileType.String(),
+		Compression:            config.Compression.String(),
+		Shard:                  config.Shard,
+		Shards:                 shards,
+		DecompressionThreads:   config.DecompressionThreads,
+		DecodingThreads:        config.DecodingThreads,
+		ItemsProcessingThreads: config.ItemsProcessingThreads,
+	}, nil
+}
+
+func hashFingerprint(reader io.Reader) (string, errors.E) {
+	hash := sha256.New()
+	_, err := io.CopyN(hash, reader, fingerprintSize)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", errors.WithMessage(err, "fingerprint")
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// fileFingerprint returns the size and the fingerprint of the file at path.
+// It returns an error satisfying errors.Is(err, os.ErrNotExist) if there is no file.
+func fileFingerprint(path string) (int64, string, errors.E) {
+	if path == "" {
+		return 0, "", errors.WithStack(os.ErrNotExist)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		errE := errors.WithMessage(err, "open")
+		errors.Details(errE)["path"] = path
+		return 0, "", errE
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		errE := errors.WithMessage(err, "stat")
+		errors.Details(errE)["path"] = path
+		return 0, "", errE
+	}
+	fingerprint, errE := hashFingerprint(file)
+	if errE != nil {
+		errors.Details(errE)["path"] = path
+		return 0, "", errE
+	}
+	return info.Size(), fingerprint, nil
+}
+
+// urlFingerprint returns the size and the fingerprint of the file at url.
+// It requests only the start of the file, if the server supports Range requests.
+func urlFingerprint(ctx context.Context, client *retryablehttp.Client, url string) (int64, string, errors.E) {
+	req, err := retryablehttp.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		errE := errors.WithMessage(err, "new request")
+		errors.Details(errE)["url"] = url
+		return 0, "", errE
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=0-%d", fingerprintSize-1))
+	resp, err := client.Do(req)
+	if err != nil {
+		errE := errors.WithMessage(err, "do")
+		errors.Details(errE)["url"] = url
+		return 0, "", errE
+	}
+	defer resp.Body.Close()
+	size := resp.ContentLength
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusPartialContent:
+		_, err = fmt.Sscanf(resp.Header.Get("Content-Range"), "bytes 0-%d/%d", new(int64), &size)
+		if err != nil {
+			errE := errors.WithMessage(ErrInvalidValue, "content range")
+			errors.Details(errE)["url"] = url
+			errors.Details(errE)["value"] = resp.Header.Get("Content-Range")
+			return 0, "", errE
+		}
+	default:
+		body, _ := io.ReadAll(resp.Body)
+		return 0, "", errors.WithDetails(
+			x.ErrResponseBadStatus,
+			"status", resp.Status,
+			"body", strings.TrimSpace(string(body)),
+			"url", url,
+		)
+	}
+	fingerprint, errE := hashFingerprint(resp.Body)
+	if errE != nil {
+		errors.Details(errE)["url"] = url
+		return 0, "", errE
+	}
+	return size, fingerprint, nil
+}
+
+// dumpCheckpointFile returns the default checkpoint file name for the dump at path or URL,
+// e.g., "checkpoint.latest-all.json.bz2.0123456789abcdef.json".
+//
+// The name includes a hash of the absolute path and URL, so that processing different dumps
+// in the same working directory uses different checkpoint files.
+func dumpCheckpointFile(filePath, fileURL string) string {
+	if filePath != "" {
+		if abs, err := filepath.Abs(filePath); err == nil {
+			filePath = abs
+		}
+	}
+	hash := sha256.Sum256([]byte(fileURL + "\n" + filePath))
+	ext := filepath.Ext(checkpointFile)
+	return fmt.Sprintf("%s.%s.%s%s", strings.TrimSuffix(checkpointFile, ext), fileName(filePath, fileURL), hex.EncodeToString(hash[:8]), ext)
+}
+
+// bind binds the checkpoint to the dump. If the loaded checkpoint belongs to a different
+// dump, bind returns ErrCheckpointMismatch, or starts with an empty checkpoint if reset is true.
+//
+// Checkpoints without a dump identity (saved by older versions) are bound to the dump.
+func (cm *CheckpointManager) bind(dump *DumpIdentity, reset bool) errors.E {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	if cm.currentCheckpoint.Dump != nil {
+		if fields := cm.currentCheckpoint.Dump.mismatch(dump); len(fields) > 0 {
+			if !reset {
+				return errors.WithDetails(
+					ErrCheckpointMismatch,
+					"fields", fields,
+					"checkpoint", cm.currentCheckpoint.Dump,
+					"dump", dump,
+				)
+			}
+			cm.logger().Warn("checkpoint belongs to a different dump, starting from the beginning",
+				"stage", "checkpoint", "fields", fields,
+			)
+			cm.currentCheckpoint = &Checkpoint{}
+			cm.dirty = true
+		}
+	}
+	cm.currentCheckpoint.Dump = dump
+	return nil
+}
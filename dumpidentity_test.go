@@ -0,0 +1,135 @@
+package mediawiki_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-retryablehttp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/go/errors"
+
+	"github.com/citadel2024/go-mediawiki"
+)
+
+func TestDumpIdentity(t *testing.T) {
+	t.Parallel()
+
+	tempDir := t.TempDir()
+
+	writeDump := func(path string, start, count int) []byte {
+		var ndjson bytes.Buffer
+		for i := range count {
+			fmt.Fprintf(&ndjson, `{"n":%d}`+"\n", start+i)
+		}
+		err := os.WriteFile(path, ndjson.Bytes(), 0o600)
+		require.NoError(t, err)
+		return ndjson.Bytes()
+	}
+
+	process := func(config *mediawiki.ProcessConfig[testNumber], store mediawiki.CheckpointStore, reset bool) (int, errors.E) {
+		processed := 0
+		config.FileType = mediawiki.NDJSON
+		config.Compression = mediawiki.NoCompression
+		config.ItemsProcessingThreads = 1
+		config.Process = func(_ context.Context, _ testNumber) errors.E {
+			processed++
+			return nil
+		}
+		config.CheckpointConfig = &mediawiki.CheckpointConfig{
+			SaveInterval:    time.Minute,
+			ItemsThreshold:  1,
+			Store:           store,
+			ResetOnMismatch: reset,
+		}
+		errE := mediawiki.Process(context.Background(), config)
+		return processed, errE
+	}
+
+	t.Run("path", func(t *testing.T) {
+		t.Parallel()
+
+		path := filepath.Join(tempDir, "dump.ndjson")
+		data := writeDump(path, 0, 100)
+		store := mediawiki.NewMemoryCheckpointStore()
+
+		processed, errE := process(&mediawiki.ProcessConfig[testNumber]{Path: path}, store, false)
+		require.NoError(t, errE, "% -+#.1v", errE)
+		assert.Equal(t, 100, processed)
+
+		checkpoint, errE := store.Load()
+		require.NoError(t, errE, "% -+#.1v", errE)
+		require.NotNil(t, checkpoint.Dump)
+		assert.Equal(t, path, checkpoint.Dump.Path)
+		assert.Equal(t, int64(len(data)), checkpoint.Dump.Size)
+		assert.NotEmpty(t, checkpoint.Dump.Fingerprint)
+		assert.Equal(t, "NDJSON", checkpoint.Dump.FileType)
+		assert.Equal(t, "NoCompression", checkpoint.Dump.Compression)
+		assert.Equal(t, 1, checkpoint.Dump.ItemsProcessingThreads)
+
+		// Same dump, nothing to process.
+		processed, errE = process(&mediawiki.ProcessConfig[testNumber]{Path: path}, store, false)
+		require.NoError(t, errE, "% -+#.1v", errE)
+		assert.Equal(t, 0, processed)
+
+		// Same dump, but one shard of it.
+		processed, errE = process(&mediawiki.ProcessConfig[testNumber]{Path: path, Shards: 2}, store, false)
+		assert.ErrorIs(t, errE, mediawiki.ErrCheckpointMismatch)
+		assert.Equal(t, []string{"shard"}, errors.AllDetails(errE)["fields"])
+		assert.Equal(t, 0, processed)
+
+		// Different dump at the same path.
+		writeDump(path, 100, 100)
+		processed, errE = process(&mediawiki.ProcessConfig[testNumber]{Path: path}, store, false)
+		assert.ErrorIs(t, errE, mediawiki.ErrCheckpointMismatch)
+		assert.Equal(t, []string{"size", "fingerprint"}, errors.AllDetails(errE)["fields"])
+		assert.Equal(t, 0, processed)
+
+		processed, errE = process(&mediawiki.ProcessConfig[testNumber]{Path: path}, store, true)
+		require.NoError(t, errE, "% -+#.1v", errE)
+		assert.Equal(t, 100, processed)
+
+		processed, errE = process(&mediawiki.ProcessConfig[testNumber]{Path: path}, store, false)
+		require.NoError(t, errE, "% -+#.1v", errE)
+		assert.Equal(t, 0, processed)
+	})
+
+	t.Run("url", func(t *testing.T) {
+		t.Parallel()
+
+		path := filepath.Join(tempDir, "url.ndjson")
+		data := writeDump(path, 0, 100)
+
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.ServeContent(w, r, "url.ndjson", time.Time{}, bytes.NewReader(data))
+		}))
+		t.Cleanup(ts.Close)
+
+		store := mediawiki.NewMemoryCheckpointStore()
+
+		processed, errE := process(&mediawiki.ProcessConfig[testNumber]{
+			URL:    ts.URL,
+			Client: retryablehttp.NewClient(),
+		}, store, false)
+		require.NoError(t, errE, "% -+#.1v", errE)
+		assert.Equal(t, 100, processed)
+
+		checkpoint, errE := store.Load()
+		require.NoError(t, errE, "% -+#.1v", errE)
+		require.NotNil(t, checkpoint.Dump)
+		assert.Equal(t, ts.URL, checkpoint.Dump.URL)
+		assert.Equal(t, int64(len(data)), checkpoint.Dump.Size)
+
+		// The same file, but at Path instead of URL.
+		_, errE = process(&mediawiki.ProcessConfig[testNumber]{Path: path}, store, false)
+		assert.ErrorIs(t, errE, mediawiki.ErrCheckpointMismatch)
+		assert.Equal(t, []string{"url", "path"}, errors.AllDetails(errE)["fields"])
+	})
+}
@@ -0,0 +1,153 @@
+package mediawiki
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/hashicorp/go-retryablehttp"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/x"
+)
+
+// dumpsBaseURL is the base URL of Wikimedia dumps.
+const dumpsBaseURL = "https://dumps.wikimedia.org"
+
+// statusFileURLRegex matches the run date in URLs of files in dumpstatus.json.
+var statusFileURLRegex = regexp.MustCompile(`^/[^/]+/(\d{8})/`)
+
+// JobStatus is the status of a dump job.
+type JobStatus string
+
+const (
+	JobDone       JobStatus = "done"
+	JobInProgress JobStatus = "in-progress"
+	JobFailed     JobStatus = "failed"
+	JobWaiting    JobStatus = "waiting"
+	JobSkipped    JobStatus = "skipped"
+)
+
+// DumpFile is a file produced by a dump job.
+//
+// URL is relative to the dumps server (e.g., "/enwiki/20240901/enwiki-20240901-pages-articles.xml.bz2").
+// Size, URL, and checksums are known only for completed files.
+type DumpFile struct {
+	Size int64  `json:"size,omitempty"`
+	URL  string `json:"url,omitempty"`
+	MD5  string `json:"md5,omitempty"`
+	SHA1 string `json:"sha1,omitempty"`
+}
+
+// DumpJob is a job of a dump run which produces files.
+type DumpJob struct {
+	Status  JobStatus           `json:"status"`
+	Updated string              `json:"updated,omitempty"`
+	Files   map[string]DumpFile `json:"files,omitempty"`
+}
+
+// DumpStatus is the status of a dump run of a wiki, as published in its dumpstatus.json.
+// Jobs is a map between job names (e.g., "articlesdump") and jobs.
+type DumpStatus struct {
+	Version string             `json:"version"`
+	Jobs    map[string]DumpJob `json:"jobs"`
+}
+
+// File returns the job which produces the file with name and the file.
+// It returns false if no job produces the file.
+func (s *DumpStatus) File(name string) (string, DumpJob, DumpFile, bool) {
+	for jobName, job := range s.Jobs {
+		if file, ok := job.Files[name]; ok {
+			return jobName, job, file, true
+		}
+	}
+	return "", DumpJob{}, DumpFile{}, false
+}
+
+// date returns the date (in YYYYMMDD format) of the run from URLs of its files.
+// It returns false if no file has its URL set.
+func (s *DumpStatus) date() (string, bool) {
+	for _, job := range s.Jobs {
+		for _, file := range job.Files {
+			match := statusFileURLRegex.FindStringSubmatch(file.URL)
+			if match != nil {
+				return match[1], true
+			}
+		}
+	}
+	return "", false
+}
+
+// dumpIndex is the status of the latest dump runs of all wikis, as published in index.json
+// of the dumps site. Wikis is a map between wikis (e.g., "enwiki") and dumpstatus.json of
+// their latest runs.
+type dumpIndex struct {
+	Wikis map[string]DumpStatus `json:"wikis"`
+}
+
+// FetchDumpStatus fetches the status of the dump run of the wiki (e.g., "enwiki")
+// on the date (in YYYYMMDD format) from its dumpstatus.json.
+func FetchDumpStatus(ctx context.Context, client *retryablehttp.Client, wiki, date string) (*DumpStatus, errors.E) {
+	return fetchDumpStatus(ctx, client, dumpsBaseURL, wiki, date)
+}
+
+func fetchDumpStatus(ctx context.Context, client *retryablehttp.Client, baseURL, wiki, date string) (*DumpStatus, errors.E) {
+	var status DumpStatus
+	errE := fetchJSON(ctx, client, fmt.Sprintf("%s/%s/%s/dumpstatus.json", baseURL, wiki, date), &status)
+	if errE != nil {
+		return nil, errE
+	}
+	return &status, nil
+}
+
+func fetchDumpIndex(ctx context.Context, client *retryablehttp.Client, baseURL string) (*dumpIndex, errors.E) {
+	var index dumpIndex
+	errE := fetchJSON(ctx, client, baseURL+"/index.json", &index)
+	if errE != nil {
+		return nil, errE
+	}
+	return &index, nil
+}
+
+// fetchJSON fetches JSON at url and decodes it into v.
+func fetchJSON(ctx context.Context, client *retryablehttp.Client, url string, v any) errors.E {
+	req, err := retryablehttp.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		errE := errors.WithMessage(err, "new request")
+		errors.Details(errE)["url"] = url
+		return errE
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		errE := errors.WithMessage(err, "do")
+		errors.Details(errE)["url"] = url
+		return errE
+	}
+	defer resp.Body.Close()
+	defer io.Copy(io.Discard, resp.Body) //nolint:errcheck
+	if resp.StatusCode == http.StatusNotFound {
+		return errors.WithDetails(ErrNotFound, "url", url)
+	} else if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return errors.WithDetails(
+			x.ErrResponseBadStatus,
+			"status", resp.Status,
+			"body", strings.TrimSpace(string(body)),
+			"url", url,
+		)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		errE := errors.WithMessage(err, "read")
+		errors.Details(errE)["url"] = url
+		return errE
+	}
+	errE := x.Unmarshal(data, v)
+	if errE != nil {
+		errors.Details(errE)["url"] = url
+		return errE
+	}
+	return nil
+}
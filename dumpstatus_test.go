@@ -0,0 +1,248 @@
+package mediawiki
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-retryablehttp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/x"
+)
+
+const testDumpStatus = `{
+  "jobs": {
+    "articlesdump": {
+      "status": "%s",
+      "updated": "2024-09-02 10:11:12",
+      "files": {
+        "enwiki-%s-pages-articles.xml.bz2": {
+          "size": 1234,
+          "url": "/enwiki/%s/enwiki-%s-pages-articles.xml.bz2",
+          "md5": "0123456789abcdef0123456789abcdef",
+          "sha1": "0123456789abcdef0123456789abcdef01234567"
+        }
+      }
+    },
+    "pagetable": {
+      "status": "done",
+      "updated": "2024-09-01 10:11:12",
+      "files": {
+        "enwiki-%s-page.sql.gz": {
+          "size": 100,
+          "url": "/enwiki/%s/enwiki-%s-page.sql.gz"
+        }
+      }
+    }
+  },
+  "version": "0.8"
+}`
+
+func TestDumpStatus(t *testing.T) {
+	t.Parallel()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/enwiki/", func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/enwiki/" {
+			http.NotFound(w, req)
+			return
+		}
+		fmt.Fprint(w, `<html><body><a href="../">../</a><a href="20240801/">20240801/</a>`+
+			`<a href="20240820/">20240820/</a><a href="20240901/">20240901/</a>`+
+			`<a href="20241001/">20241001/</a><a href="latest/">latest/</a></body></html>`)
+	})
+	for date, status := range map[string]string{
+		"20240801": "done",
+		"20240901": "done",
+		"20241001": "in-progress",
+	} {
+		mux.HandleFunc(fmt.Sprintf("/enwiki/%s/dumpstatus.json", date), func(w http.ResponseWriter, _ *http.Request) {
+			fmt.Fprintf(w, testDumpStatus, status, date, date, date, date, date, date)
+		})
+	}
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+
+	client := retryablehttp.NewClient()
+	client.RetryMax = 0
+
+	status, errE := fetchDumpStatus(context.Background(), client, ts.URL, "enwiki", "20241001")
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, "0.8", status.Version)
+	job, _, file, ok := status.File("enwiki-20241001-pages-articles.xml.bz2")
+	require.True(t, ok)
+	assert.Equal(t, "articlesdump", job)
+	assert.Equal(t, DumpFile{
+		Size: 1234,
+		URL:  "/enwiki/20241001/enwiki-20241001-pages-articles.xml.bz2",
+		MD5:  "0123456789abcdef0123456789abcdef",
+		SHA1: "0123456789abcdef0123456789abcdef01234567",
+	}, file)
+	assert.Equal(t, JobInProgress, status.Jobs["articlesdump"].Status)
+	assert.Equal(t, JobDone, status.Jobs["pagetable"].Status)
+
+	_, errE = fetchDumpStatus(context.Background(), client, ts.URL, "enwiki", "20240820")
+	assert.ErrorIs(t, errE, ErrNotFound)
+
+	// The latest run is still in progress.
+	dump := newStatusDump(ts.URL, "enwiki", "%s-%s-pages-articles.xml.bz2", XMLBZIP2Format)
+	url, errE := dump.latestURL(context.Background(), client)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, ts.URL+"/enwiki/20240901/enwiki-20240901-pages-articles.xml.bz2", url)
+
+	runs, errE := dump.Runs(context.Background(), client)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, []DumpRun{
+		{Date: time.Date(2024, 8, 1, 0, 0, 0, 0, time.UTC), URL: ts.URL + "/enwiki/20240801/enwiki-20240801-pages-articles.xml.bz2"},
+		{Date: time.Date(2024, 9, 1, 0, 0, 0, 0, time.UTC), URL: ts.URL + "/enwiki/20240901/enwiki-20240901-pages-articles.xml.bz2"},
+	}, runs)
+
+	run, errE := dump.Run(context.Background(), client, time.Date(2024, 8, 1, 0, 0, 0, 0, time.UTC))
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, ts.URL+"/enwiki/20240801/enwiki-20240801-pages-articles.xml.bz2", run.URL)
+
+	_, errE = dump.Run(context.Background(), client, time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC))
+	require.ErrorIs(t, errE, ErrNotFound)
+	assert.Equal(t, "articlesdump", errors.Details(errE)["job"])
+	assert.Equal(t, JobInProgress, errors.Details(errE)["status"])
+	assert.Equal(t, "20241001", errors.Details(errE)["date"])
+
+	// There is no dumpstatus.json for this run.
+	_, errE = dump.Run(context.Background(), client, time.Date(2024, 8, 20, 0, 0, 0, 0, time.UTC))
+	require.ErrorIs(t, errE, ErrNotFound)
+	assert.Equal(t, "20240820", errors.Details(errE)["date"])
+
+	// But this job has completed in the latest run.
+	url, errE = newStatusDump(ts.URL, "enwiki", "%s-%s-page.sql.gz", SQLGZIPFormat).latestURL(context.Background(), client)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, ts.URL+"/enwiki/20241001/enwiki-20241001-page.sql.gz", url)
+
+	_, errE = newStatusDump(ts.URL, "enwiki", "%s-%s-redirect.sql.gz", SQLGZIPFormat).latestURL(context.Background(), client)
+	assert.ErrorIs(t, errE, ErrNotFound)
+
+	_, errE = newStatusDump(ts.URL, "enwiki", "%s-%s-redirect.sql.gz", SQLGZIPFormat).Run(context.Background(), client, time.Date(2024, 9, 1, 0, 0, 0, 0, time.UTC))
+	require.ErrorIs(t, errE, ErrNotFound)
+	assert.Equal(t, "enwiki-20240901-redirect.sql.gz", errors.Details(errE)["file"])
+}
+
+func TestDumpLatestIndex(t *testing.T) {
+	t.Parallel()
+
+	var listings, statuses atomic.Int64
+	mux := http.NewServeMux()
+	mux.HandleFunc("/index.json", func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprintf(w, `{"wikis": {"enwiki": `+testDumpStatus+`}}`,
+			"in-progress", "20241001", "20241001", "20241001", "20241001", "20241001", "20241001")
+	})
+	mux.HandleFunc("/enwiki/", func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/enwiki/" {
+			http.NotFound(w, req)
+			return
+		}
+		listings.Add(1)
+		fmt.Fprint(w, `<html><body><a href="20240901/">20240901/</a><a href="20241001/">20241001/</a></body></html>`)
+	})
+	for date, status := range map[string]string{
+		"20240901": "done",
+		"20241001": "in-progress",
+	} {
+		mux.HandleFunc(fmt.Sprintf("/enwiki/%s/dumpstatus.json", date), func(w http.ResponseWriter, _ *http.Request) {
+			statuses.Add(1)
+			fmt.Fprintf(w, testDumpStatus, status, date, date, date, date, date, date)
+		})
+	}
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+
+	client := retryablehttp.NewClient()
+	client.RetryMax = 0
+
+	// The job has completed in the latest run, so the directory listing is not used.
+	run, errE := newStatusDump(ts.URL, "enwiki", "%s-%s-page.sql.gz", SQLGZIPFormat).Latest(context.Background(), client)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, DumpRun{
+		Date: time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC),
+		URL:  ts.URL + "/enwiki/20241001/enwiki-20241001-page.sql.gz",
+	}, run)
+	assert.Equal(t, int64(0), listings.Load())
+	assert.Equal(t, int64(0), statuses.Load())
+
+	// The job is still in progress in the latest run, so we fall back to the directory listing.
+	run, errE = newStatusDump(ts.URL, "enwiki", "%s-%s-pages-articles.xml.bz2", XMLBZIP2Format).Latest(context.Background(), client)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, DumpRun{
+		Date: time.Date(2024, 9, 1, 0, 0, 0, 0, time.UTC),
+		URL:  ts.URL + "/enwiki/20240901/enwiki-20240901-pages-articles.xml.bz2",
+	}, run)
+	assert.Equal(t, int64(1), listings.Load())
+
+	// The wiki is not in index.json, so we fall back to the directory listing.
+	_, errE = newStatusDump(ts.URL, "slwiki", "%s-%s-page.sql.gz", SQLGZIPFormat).Latest(context.Background(), client)
+	require.ErrorIs(t, errE, ErrNotFound)
+	assert.Equal(t, ts.URL+"/slwiki/", errors.Details(errE)["url"])
+}
+
+func TestListingDumpRuns(t *testing.T) {
+	t.Parallel()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/entities/", func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/entities/" {
+			http.NotFound(w, req)
+			return
+		}
+		fmt.Fprint(w, `<html><body><a href="../">../</a><a href="20240801/">20240801/</a>`+
+			`<a href="20240901/">20240901/</a><a href="20241001/">20241001/</a></body></html>`)
+	})
+	mux.HandleFunc("/forbidden/", func(w http.ResponseWriter, _ *http.Request) {
+		// An error page is not scraped for runs even if it contains links.
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `<html><body><a href="20240801/">20240801/</a></body></html>`)
+	})
+	for _, date := range []string{"20240801", "20240901"} {
+		mux.HandleFunc(fmt.Sprintf("/entities/%s/wikidata-%s-all.json.bz2", date, date), func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})
+	}
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+
+	client := retryablehttp.NewClient()
+	client.RetryMax = 0
+
+	dump := newListingDump(ts.URL+"/entities/", ts.URL+"/entities/%s/wikidata-%s-all.json.bz2", JSONBZIP2Format)
+
+	runs, errE := dump.Runs(context.Background(), client)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, []DumpRun{
+		{Date: time.Date(2024, 8, 1, 0, 0, 0, 0, time.UTC), URL: ts.URL + "/entities/20240801/wikidata-20240801-all.json.bz2"},
+		{Date: time.Date(2024, 9, 1, 0, 0, 0, 0, time.UTC), URL: ts.URL + "/entities/20240901/wikidata-20240901-all.json.bz2"},
+	}, runs)
+
+	run, errE := dump.Latest(context.Background(), client)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, runs[1], run)
+
+	run, errE = dump.Run(context.Background(), client, time.Date(2024, 8, 1, 12, 0, 0, 0, time.UTC))
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, runs[0], run)
+
+	_, errE = dump.Run(context.Background(), client, time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC))
+	require.ErrorIs(t, errE, ErrNotFound)
+	assert.Equal(t, ts.URL+"/entities/20241001/wikidata-20241001-all.json.bz2", errors.Details(errE)["url"])
+	assert.Equal(t, "404 Not Found", errors.Details(errE)["status"])
+
+	_, errE = newListingDump(ts.URL+"/missing/", ts.URL+"/missing/%s/wikidata-%s-all.json.bz2", JSONBZIP2Format).Latest(context.Background(), client)
+	require.ErrorIs(t, errE, ErrNotFound)
+	assert.Equal(t, ts.URL+"/missing/", errors.Details(errE)["url"])
+
+	_, errE = newListingDump(ts.URL+"/forbidden/", ts.URL+"/forbidden/%s/wikidata-%s-all.json.bz2", JSONBZIP2Format).Runs(context.Background(), client)
+	require.ErrorIs(t, errE, x.ErrResponseBadStatus)
+	assert.Equal(t, "403 Forbidden", errors.Details(errE)["status"])
+}
@@ -21,6 +21,9 @@ const (
 	Item EntityType = iota
 	Property
 	MediaInfo
+	LexemeT
+	FormT
+	SenseT
 )
 
 func (t EntityType) MarshalJSON() ([]byte, error) {
@@ -32,6 +35,12 @@ func (t EntityType) MarshalJSON() ([]byte, error) {
 		buffer.WriteString("property")
 	case MediaInfo:
 		buffer.WriteString("mediainfo")
+	case LexemeT:
+		buffer.WriteString("lexeme")
+	case FormT:
+		buffer.WriteString("form")
+	case SenseT:
+		buffer.WriteString("sense")
 	}
 	buffer.WriteString(`"`)
 	return buffer.Bytes(), nil
@@ -50,6 +59,12 @@ func (t *EntityType) UnmarshalJSON(b []byte) error {
 		*t = Property
 	case "mediainfo":
 		*t = MediaInfo
+	case "lexeme":
+		*t = LexemeT
+	case "form":
+		*t = FormT
+	case "sense":
+		*t = SenseT
 	default:
 		errE := errors.WithMessage(ErrInvalidValue, "entity type")
 		errors.Details(errE)["value"] = s
@@ -887,3 +902,100 @@ type commonsEntity struct {
 	SiteLinks    map[string]SiteLink        `json:"sitelinks,omitempty"`
 	LastRevID    int64                      `json:"lastrevid"`
 }
+
+// Form is a form of a Wikidata lexeme.
+type Form struct {
+	ID                  string                   `json:"id"`
+	Type                *EntityType              `json:"type,omitempty"`
+	Representations     map[string]LanguageValue `json:"representations,omitempty"`
+	GrammaticalFeatures []string                 `json:"grammaticalFeatures,omitempty"` //nolint:tagliatelle
+	Claims              map[string][]Statement   `json:"claims,omitempty"`
+}
+
+// Sense is a sense of a Wikidata lexeme.
+type Sense struct {
+	ID      string                   `json:"id"`
+	Type    *EntityType              `json:"type,omitempty"`
+	Glosses map[string]LanguageValue `json:"glosses,omitempty"`
+	Claims  map[string][]Statement   `json:"claims,omitempty"`
+}
+
+// Lexeme is a Wikidata lexemes JSON dump entity.
+//
+// LexicalCategory and Language are IDs of Wikidata items.
+type Lexeme struct {
+	ID              string                   `json:"id"`
+	PageID          int64                    `json:"pageid"`
+	Namespace       int                      `json:"ns"`
+	Title           string                   `json:"title"`
+	Modified        time.Time                `json:"modified"`
+	Type            EntityType               `json:"type"`
+	Lemmas          map[string]LanguageValue `json:"lemmas,omitempty"`
+	LexicalCategory string                   `json:"lexicalCategory"` //nolint:tagliatelle
+	Language        string                   `json:"language"`
+	Claims          map[string][]Statement   `json:"claims,omitempty"`
+	Forms           []Form                   `json:"forms,omitempty"`
+	Senses          []Sense                  `json:"senses,omitempty"`
+	LastRevID       int64                    `json:"lastrevid"`
+}
+
+// lexemeClaims are claims as they are represented in Wikidata lexemes JSON dump.
+// When there are no claims, they are represented as an empty JSON array
+// instead of an empty JSON object.
+type lexemeClaims map[string][]Statement
+
+func (c *lexemeClaims) UnmarshalJSON(b []byte) error {
+	if bytes.Equal(bytes.TrimSpace(b), []byte("[]")) {
+		*c = nil
+		return nil
+	}
+	return x.UnmarshalWithoutUnknownFields(b, (*map[string][]Statement)(c))
+}
+
+// UnmarshalJSON implements json.Unmarshaler interface for Form.
+func (f *Form) UnmarshalJSON(b []byte) error {
+	type form Form
+	var d struct {
+		form
+		Claims lexemeClaims `json:"claims,omitempty"`
+	}
+	errE := x.UnmarshalWithoutUnknownFields(b, &d)
+	if errE != nil {
+		return errE
+	}
+	*f = Form(d.form)
+	f.Claims = d.Claims
+	return nil
+}
+
+// UnmarshalJSON implements json.Unmarshaler interface for Sense.
+func (s *Sense) UnmarshalJSON(b []byte) error {
+	type sense Sense
+	var d struct {
+		sense
+		Claims lexemeClaims `json:"claims,omitempty"`
+	}
+	errE := x.UnmarshalWithoutUnknownFields(b, &d)
+	if errE != nil {
+		return errE
+	}
+	*s = Sense(d.sense)
+	s.Claims = d.Claims
+	return nil
+}
+
+// UnmarshalJSON implements json.Unmarshaler interface for Lexeme.
+func (l *Lexeme) UnmarshalJSON(b []byte) error {
+	type lexeme Lexeme
+	var d struct {
+		lexeme
+		Claims lexemeClaims `json:"claims,omitempty"`
+	}
+	errE := x.UnmarshalWithoutUnknownFields(b, &d)
+	if errE != nil {
+		return errE
+	}
+	*l = Lexeme(d.lexeme)
+	l.Claims = d.Claims
+	return nil
+}
@@ -62,3 +62,32 @@ func TestAmount(t *testing.T) {
 		})
 	}
 }
+
+func TestLexeme(t *testing.T) {
+	t.Parallel()
+
+	in := `{"type":"lexeme","id":"L7","lemmas":{"en":{"language":"en","value":"cat"}},"lexicalCategory":"Q1084","language":"Q1860",` +
+		`"claims":[],"forms":[{"id":"L7-F1","representations":{"en":{"language":"en","value":"cats"}},"grammaticalFeatures":["Q146786"],` +
+		`"claims":{"P898":[{"mainsnak":{"snaktype":"value","property":"P898","hash":"1","datavalue":{"value":"kæts","type":"string"},"datatype":"string"},` +
+		`"type":"statement","id":"L7-F1$1","rank":"normal"}]}}],"senses":[{"id":"L7-S1","glosses":{"en":{"language":"en","value":"a small animal"}},"claims":[]}],` +
+		`"lastrevid":123,"pageid":456,"ns":146,"title":"Lexeme:L7","modified":"2024-09-01T00:00:00Z"}`
+
+	var l Lexeme
+	errE := x.UnmarshalWithoutUnknownFields([]byte(in), &l)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, LexemeT, l.Type)
+	assert.Equal(t, "Q1084", l.LexicalCategory)
+	assert.Nil(t, l.Claims)
+	require.Len(t, l.Forms, 1)
+	assert.Equal(t, []string{"Q146786"}, l.Forms[0].GrammaticalFeatures)
+	assert.Len(t, l.Forms[0].Claims["P898"], 1)
+	require.Len(t, l.Senses, 1)
+	assert.Equal(t, "a small animal", l.Senses[0].Glosses["en"].Value)
+
+	out, errE := x.MarshalWithoutEscapeHTML(l)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	var l2 Lexeme
+	errE = x.UnmarshalWithoutUnknownFields(out, &l2)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, l, l2)
+}
@@ -10,4 +10,13 @@ var (
 	ErrNotFound       = errors.Base("not found")
 	ErrJSONDecode     = errors.Base("cannot decode json")
 	ErrSQLParse       = errors.Base("cannot parse SQL")
+	ErrXMLDecode      = errors.Base("cannot decode xml")
+	ErrFormatMismatch = errors.Base("detected format does not match configured format")
+	ErrUnknownFormat  = errors.Base("cannot detect format")
+	// ErrCheckpointConflict is returned when the stored checkpoint has been changed by somebody else.
+	ErrCheckpointConflict = errors.Base("checkpoint changed concurrently")
+	// ErrCheckpointMismatch is returned when the checkpoint belongs to a different dump.
+	ErrCheckpointMismatch = errors.Base("checkpoint belongs to a different dump")
+	// ErrChecksumMismatch is returned when the checksum of a file does not match the published one.
+	ErrChecksumMismatch = errors.Base("checksum mismatch")
 )
@@ -9,8 +9,10 @@ require (
 	github.com/elliotchance/phpserialize v1.4.0
 	github.com/foolin/pagser v0.1.6
 	github.com/hashicorp/go-retryablehttp v0.7.7
+	github.com/klauspost/compress v1.13.6
 	github.com/klauspost/pgzip v1.2.6
 	github.com/pingcap/tidb/pkg/parser v0.0.0-20240906070337-5dae1a3135e9
+	github.com/ulikunitz/xz v0.5.12
 	gitlab.com/tozd/go/errors v0.9.0
 	golang.org/x/text v0.18.0
 )
@@ -34,11 +36,11 @@ require (
 	github.com/hashicorp/go-cleanhttp v0.5.2 // indirect
 	github.com/jbenet/go-context v0.0.0-20150711004518-d14ea06fba99 // indirect
 	github.com/kevinburke/ssh_config v1.2.0 // indirect
-	github.com/klauspost/compress v1.13.6 // indirect
 	github.com/pingcap/errors v0.11.5-0.20240311024730-e056997136bb // indirect
 	github.com/pingcap/failpoint v0.0.0-20220801062533-2eaa32854a6c // indirect
 	github.com/pingcap/log v1.1.0 // indirect
 	github.com/pjbgf/sha1cd v0.3.0 // indirect
+	github.com/pkg/errors v0.9.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/remyoudompheng/bigfft v0.0.0-20230129092748-24d4a6f8daec // indirect
 	github.com/sergi/go-diff v1.3.2-0.20230802210424-5b0b94c5c0d3 // indirect
@@ -60,7 +62,6 @@ require (
 )
 
 require (
-	github.com/pkg/errors v0.9.1 // indirect
 	github.com/stretchr/testify v1.9.0
 	gitlab.com/tozd/go/x v0.0.0-20240906084819-fda0a3bbba65
 )
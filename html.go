@@ -2,14 +2,15 @@ package mediawiki
 
 import (
 	"context"
-	"fmt"
 	"io"
 	"net/http"
 	"regexp"
+	"strings"
 
 	"github.com/foolin/pagser"
 	"github.com/hashicorp/go-retryablehttp"
 	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/x"
 )
 
 var runRegex = regexp.MustCompile(`^(\d{8})/$`)
@@ -19,21 +20,37 @@ type runs struct {
 	Links []string `pagser:"a->eachAttr(href)"`
 }
 
-func latestRun(ctx context.Context, client *retryablehttp.Client, runURL, fileFormat string) (string, errors.E) {
+// listRuns returns dates (in YYYYMMDD format) of all runs linked from a directory listing at runURL,
+// in the order they are listed (which is from the oldest to the newest).
+//
+// There is no machine-readable index of all runs, so links are scraped from the HTML of the listing.
+// For dumps which publish dumpstatus.json, it is used only as a fallback (see Dump).
+func listRuns(ctx context.Context, client *retryablehttp.Client, runURL string) ([]string, errors.E) {
 	req, err := retryablehttp.NewRequestWithContext(ctx, http.MethodGet, runURL, nil)
 	if err != nil {
 		errE := errors.WithMessage(err, "new request")
 		errors.Details(errE)["url"] = runURL
-		return "", errE
+		return nil, errE
 	}
 	resp, err := client.Do(req)
 	if err != nil {
 		errE := errors.WithMessage(err, "do")
 		errors.Details(errE)["url"] = runURL
-		return "", errE
+		return nil, errE
 	}
 	defer resp.Body.Close()
 	defer io.Copy(io.Discard, resp.Body) //nolint:errcheck
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errors.WithDetails(ErrNotFound, "url", runURL)
+	} else if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, errors.WithDetails(
+			x.ErrResponseBadStatus,
+			"status", resp.Status,
+			"body", strings.TrimSpace(string(body)),
+			"url", runURL,
+		)
+	}
 
 	p := pagser.New()
 
@@ -42,31 +59,15 @@ func latestRun(ctx context.Context, client *retryablehttp.Client, runURL, fileFo
 	if err != nil {
 		errE := errors.WithMessage(err, "parse")
 		errors.Details(errE)["url"] = runURL
-		return "", errE
+		return nil, errE
 	}
 
-	// We start with the last link.
-	for i := len(data.Links) - 1; i >= 0; i-- {
-		link := data.Links[i]
+	dates := []string{}
+	for _, link := range data.Links {
 		match := runRegex.FindStringSubmatch(link)
 		if match != nil {
-			lastDate := match[1]
-			url := fmt.Sprintf(fileFormat, lastDate, lastDate)
-
-			// It can happen that the file is missing in the dump directory. So we check.
-			resp, err := client.Head(url)
-			if err != nil {
-				errE := errors.WithMessage(err, "head")
-				errors.Details(errE)["url"] = url
-				return "", errE
-			}
-			defer resp.Body.Close()
-			defer io.Copy(io.Discard, resp.Body) //nolint:errcheck
-			if resp.StatusCode == http.StatusOK {
-				return url, nil
-			}
+			dates = append(dates, match[1])
 		}
 	}
-
-	return "", errors.WithDetails(ErrNotFound, "url", runURL)
+	return dates, nil
 }
@@ -0,0 +1,531 @@
+package mediawiki
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-retryablehttp"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/x"
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	incrementalBaseURL = "https://dumps.wikimedia.org/other/incr/"
+	runDateFormat      = "20060102"
+)
+
+// IncrementalRun is a run of adds-changes (incremental) dumps of a wiki.
+//
+// URL is the URL of the pages-meta-hist-incr XML dump with full revisions
+// and StubsURL is the URL of the stubs-meta-hist-incr XML dump with only
+// revision metadata.
+type IncrementalRun struct {
+	Date     time.Time
+	URL      string
+	StubsURL string
+}
+
+// IncrementalRuns returns all completed adds-changes (incremental) dump runs of a wiki
+// after the since date, ordered from the oldest to the newest.
+// Use "wikidatawiki" for Wikidata and "enwiki" for English Wikipedia.
+//
+// Runs which are still in progress are not returned.
+func IncrementalRuns(ctx context.Context, client *retryablehttp.Client, wiki string, since time.Time) ([]IncrementalRun, errors.E) {
+	return incrementalRuns(ctx, client, incrementalBaseURL, wiki, since)
+}
+
+func incrementalRuns(
+	ctx context.Context, client *retryablehttp.Client, baseURL, wiki string, since time.Time,
+) ([]IncrementalRun, errors.E) {
+	runURL := fmt.Sprintf("%s%s/", baseURL, wiki)
+	dates, errE := listRuns(ctx, client, runURL)
+	if errE != nil {
+		return nil, errE
+	}
+	slices.Sort(dates)
+
+	result := []IncrementalRun{}
+	for _, date := range dates {
+		d, err := time.Parse(runDateFormat, date)
+		if err != nil {
+			errE := errors.WithMessage(err, "run date")
+			errors.Details(errE)["url"] = runURL
+			errors.Details(errE)["date"] = date
+			return nil, errE
+		}
+		if !d.After(since) {
+			continue
+		}
+		done, errE := incrementalRunDone(ctx, client, fmt.Sprintf("%s%s/status.txt", runURL, date))
+		if errE != nil {
+			return nil, errE
+		}
+		if !done {
+			continue
+		}
+		result = append(result, IncrementalRun{
+			Date:     d,
+			URL:      fmt.Sprintf("%s%s/%s-%s-pages-meta-hist-incr.xml.bz2", runURL, date, wiki, date),
+			StubsURL: fmt.Sprintf("%s%s/%s-%s-stubs-meta-hist-incr.xml.gz", runURL, date, wiki, date),
+		})
+	}
+	return result, nil
+}
+
+// incrementalRunDone checks the run's status file which contains "done" once the run has completed.
+func incrementalRunDone(ctx context.Context, client *retryablehttp.Client, statusURL string) (bool, errors.E) {
+	req, err := retryablehttp.NewRequestWithContext(ctx, http.MethodGet, statusURL, nil)
+	if err != nil {
+		errE := errors.WithMessage(err, "new request")
+		errors.Details(errE)["url"] = statusURL
+		return false, errE
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		errE := errors.WithMessage(err, "do")
+		errors.Details(errE)["url"] = statusURL
+		return false, errE
+	}
+	defer resp.Body.Close()
+	defer io.Copy(io.Discard, resp.Body) //nolint:errcheck
+
+	if resp.StatusCode != http.StatusOK {
+		return false, nil
+	}
+	status, err := io.ReadAll(resp.Body)
+	if err != nil {
+		errE := errors.WithMessage(err, "read")
+		errors.Details(errE)["url"] = statusURL
+		return false, errE
+	}
+	return strings.TrimSpace(string(status)) == "done", nil
+}
+
+// ChangeType is the type of a change in an adds-changes (incremental) dump.
+type ChangeType int
+
+const (
+	Created ChangeType = iota
+	Updated
+	// Suppressed is used when the text of the revision has been suppressed (hidden),
+	// so the content after the change is not available.
+	Suppressed
+	Redirected
+	// Deleted is used when the page has been deleted. Adds-changes dumps do not include
+	// deletions, they are obtained from pages-logging dumps (see ProcessWikipediaDeletionsDump).
+	Deleted
+)
+
+func (t ChangeType) MarshalJSON() ([]byte, error) {
+	buffer := bytes.NewBufferString(`"`)
+	switch t {
+	case Created:
+		buffer.WriteString("created")
+	case Updated:
+		buffer.WriteString("updated")
+	case Suppressed:
+		buffer.WriteString("suppressed")
+	case Redirected:
+		buffer.WriteString("redirected")
+	case Deleted:
+		buffer.WriteString("deleted")
+	default:
+		errE := errors.WithMessage(ErrInvalidValue, "change type")
+		errors.Details(errE)["value"] = int(t)
+		return nil, errE
+	}
+	buffer.WriteString(`"`)
+	return buffer.Bytes(), nil
+}
+
+func (t *ChangeType) UnmarshalJSON(b []byte) error {
+	var s string
+	errE := x.Unmarshal(b, &s)
+	if errE != nil {
+		return errE
+	}
+	switch s {
+	case "created":
+		*t = Created
+	case "updated":
+		*t = Updated
+	case "suppressed":
+		*t = Suppressed
+	case "redirected":
+		*t = Redirected
+	case "deleted":
+		*t = Deleted
+	default:
+		errE := errors.WithMessage(ErrInvalidValue, "change type")
+		errors.Details(errE)["value"] = s
+		return errE
+	}
+	return nil
+}
+
+// PageChange is a change of a page in an adds-changes (incremental) dump.
+//
+// Page has Revision field set to the revision which made the change.
+// For Deleted changes, Page has only Title (with namespace prefix) set and Revision
+// has only Timestamp, Contributor, and Comment of the deletion set.
+type PageChange struct {
+	Type ChangeType `json:"type"`
+	Page Page       `json:"page"`
+}
+
+// EntityChange is a change of a Wikidata entity in an adds-changes (incremental) dump.
+//
+// For Created and Updated changes, Entity is set for items and properties and Lexeme
+// for lexemes. RedirectTarget is set for Redirected changes.
+// Page has Revision field set to the revision which made the change, but without
+// revision text (which has been decoded into Entity or Lexeme).
+// For Deleted changes, only ID and Page (as for PageChange) are set.
+type EntityChange struct {
+	Type           ChangeType `json:"type"`
+	ID             string     `json:"id"`
+	Entity         *Entity    `json:"entity,omitempty"`
+	Lexeme         *Lexeme    `json:"lexeme,omitempty"`
+	RedirectTarget string     `json:"redirect_target,omitempty"`
+	Page           Page       `json:"page"`
+}
+
+func pageChangeType(page Page) ChangeType {
+	switch {
+	case page.Revision.TextDeleted:
+		return Suppressed
+	case page.Redirect != "":
+		return Redirected
+	case page.Revision.ParentID == 0:
+		return Created
+	default:
+		return Updated
+	}
+}
+
+// unmarshalPHPMap unmarshals a JSON object into a map, but also accepts an
+// empty JSON array which is how PHP serializes empty associative arrays.
+func unmarshalPHPMap[V any](b json.RawMessage, m *map[string]V) errors.E {
+	b = bytes.TrimSpace(b)
+	if len(b) == 0 || bytes.Equal(b, []byte("[]")) || bytes.Equal(b, []byte("null")) {
+		*m = nil
+		return nil
+	}
+	return x.UnmarshalWithoutUnknownFields(b, m)
+}
+
+// decodeRevisionEntity decodes Wikibase entity JSON as it is stored in page revisions.
+// It differs from the JSON dump format in that it does not contain page metadata and
+// that empty maps are represented as empty arrays.
+func decodeRevisionEntity(page Page) (*Entity, string, errors.E) {
+	var data struct {
+		Type         EntityType      `json:"type"`
+		ID           string          `json:"id"`
+		DataType     *DataType       `json:"datatype,omitempty"`
+		Labels       json.RawMessage `json:"labels"`
+		Descriptions json.RawMessage `json:"descriptions"`
+		Aliases      json.RawMessage `json:"aliases"`
+		Claims       json.RawMessage `json:"claims"`
+		SiteLinks    json.RawMessage `json:"sitelinks"`
+		// Set for redirects.
+		Entity   string `json:"entity"`
+		Redirect string `json:"redirect"`
+	}
+	errE := x.Unmarshal([]byte(page.Revision.Text), &data)
+	if errE != nil {
+		return nil, "", errE
+	}
+	if data.Redirect != "" {
+		return nil, norm.NFC.String(data.Redirect), nil
+	}
+
+	entity := &Entity{
+		ID:           norm.NFC.String(data.ID),
+		PageID:       page.ID,
+		Namespace:    page.Namespace,
+		Title:        page.Title,
+		Modified:     page.Revision.Timestamp,
+		Type:         data.Type,
+		DataType:     data.DataType,
+		Labels:       nil,
+		Descriptions: nil,
+		Aliases:      nil,
+		Claims:       nil,
+		SiteLinks:    nil,
+		LastRevID:    page.Revision.ID,
+	}
+	errE = unmarshalPHPMap(data.Labels, &entity.Labels)
+	if errE != nil {
+		return nil, "", errors.WithMessage(errE, "labels")
+	}
+	errE = unmarshalPHPMap(data.Descriptions, &entity.Descriptions)
+	if errE != nil {
+		return nil, "", errors.WithMessage(errE, "descriptions")
+	}
+	errE = unmarshalPHPMap(data.Aliases, &entity.Aliases)
+	if errE != nil {
+		return nil, "", errors.WithMessage(errE, "aliases")
+	}
+	errE = unmarshalPHPMap(data.Claims, &entity.Claims)
+	if errE != nil {
+		return nil, "", errors.WithMessage(errE, "claims")
+	}
+	errE = unmarshalPHPMap(data.SiteLinks, &entity.SiteLinks)
+	if errE != nil {
+		return nil, "", errors.WithMessage(errE, "sitelinks")
+	}
+	return entity, "", nil
+}
+
+// decodeRevisionLexeme decodes Wikibase lexeme JSON as it is stored in page revisions.
+// See decodeRevisionEntity for how it differs from the JSON dump format.
+func decodeRevisionLexeme(page Page) (*Lexeme, string, errors.E) {
+	var data struct {
+		Type            EntityType      `json:"type"`
+		ID              string          `json:"id"`
+		Lemmas          json.RawMessage `json:"lemmas"`
+		LexicalCategory string          `json:"lexicalCategory"` //nolint:tagliatelle
+		Language        string          `json:"language"`
+		Claims          json.RawMessage `json:"claims"`
+		Forms           []Form          `json:"forms"`
+		Senses          []Sense         `json:"senses"`
+		// Set for redirects.
+		Entity   string `json:"entity"`
+		Redirect string `json:"redirect"`
+	}
+	errE := x.Unmarshal([]byte(page.Revision.Text), &data)
+	if errE != nil {
+		return nil, "", errE
+	}
+	if data.Redirect != "" {
+		return nil, norm.NFC.String(data.Redirect), nil
+	}
+
+	lexeme := &Lexeme{
+		ID:              norm.NFC.String(data.ID),
+		PageID:          page.ID,
+		Namespace:       page.Namespace,
+		Title:           page.Title,
+		Modified:        page.Revision.Timestamp,
+		Type:            data.Type,
+		Lemmas:          nil,
+		LexicalCategory: data.LexicalCategory,
+		Language:        data.Language,
+		Claims:          nil,
+		Forms:           data.Forms,
+		Senses:          data.Senses,
+		LastRevID:       page.Revision.ID,
+	}
+	errE = unmarshalPHPMap(data.Lemmas, &lexeme.Lemmas)
+	if errE != nil {
+		return nil, "", errors.WithMessage(errE, "lemmas")
+	}
+	errE = unmarshalPHPMap(data.Claims, &lexeme.Claims)
+	if errE != nil {
+		return nil, "", errors.WithMessage(errE, "claims")
+	}
+	return lexeme, "", nil
+}
+
+// LatestWikidataIncrementalRun returns the latest completed adds-changes (incremental) dump run of Wikidata.
+func LatestWikidataIncrementalRun(ctx context.Context, client *retryablehttp.Client) (IncrementalRun, errors.E) {
+	runs, errE := IncrementalRuns(ctx, client, "wikidatawiki", time.Time{})
+	if errE != nil {
+		return IncrementalRun{}, errE
+	}
+	if len(runs) == 0 {
+		return IncrementalRun{}, errors.WithDetails(ErrNotFound, "url", incrementalBaseURL+"wikidatawiki/")
+	}
+	return runs[len(runs)-1], nil
+}
+
+// ProcessWikipediaIncrementalDump downloads (unless already saved), decompresses, decodes XML,
+// and calls processChange on every revision in a Wikipedia adds-changes (incremental) dump.
+//
+// Changes of a page are passed to processChange in the order they are in the dump.
+// See ProcessWikipediaHistoryDump for details.
+func ProcessWikipediaIncrementalDump(
+	ctx context.Context, config *ProcessDumpConfig,
+	processChange func(context.Context, PageChange) errors.E,
+) errors.E {
+	return ProcessWikipediaHistoryDump(ctx, config, func(ctx context.Context, page Page) errors.E {
+		return processChange(ctx, PageChange{
+			Type: pageChangeType(page),
+			Page: page,
+		})
+	})
+}
+
+// ProcessWikidataIncrementalDump downloads (unless already saved), decompresses, decodes XML and
+// entity JSON, and calls processChange on every revision of an item, a property, or a lexeme in
+// a Wikidata adds-changes (incremental) dump. Revisions of other pages (e.g., discussion pages) are skipped.
+//
+// Only pages-meta-hist-incr dumps contain revision text, so they should be used and not stubs.
+//
+// Changes of a page are passed to processChange in the order they are in the dump.
+// See ProcessWikipediaHistoryDump for details.
+func ProcessWikidataIncrementalDump(
+	ctx context.Context, config *ProcessDumpConfig,
+	processChange func(context.Context, EntityChange) errors.E,
+) errors.E {
+	return ProcessWikipediaHistoryDump(ctx, config, func(ctx context.Context, page Page) errors.E {
+		lexeme := page.Revision.Model == "wikibase-lexeme"
+		if page.Revision.Model != "wikibase-item" && page.Revision.Model != "wikibase-property" && !lexeme {
+			return nil
+		}
+		change := EntityChange{
+			Type:           pageChangeType(page),
+			ID:             page.Title[strings.LastIndex(page.Title, ":")+1:],
+			Entity:         nil,
+			Lexeme:         nil,
+			RedirectTarget: "",
+			Page:           page,
+		}
+		change.Page.Revision.Text = ""
+		if change.Type == Suppressed {
+			return processChange(ctx, change)
+		}
+		var entity *Entity
+		var redirect string
+		var errE errors.E
+		if lexeme {
+			change.Lexeme, redirect, errE = decodeRevisionLexeme(page)
+		} else {
+			entity, redirect, errE = decodeRevisionEntity(page)
+		}
+		if errE != nil {
+			errors.Details(errE)["page"] = page.ID
+			errors.Details(errE)["revision"] = page.Revision.ID
+			return errE
+		}
+		if redirect != "" {
+			change.Type = Redirected
+			change.RedirectTarget = redirect
+		} else {
+			if change.Type == Redirected {
+				// Page is marked as a redirect, but revision contains an entity.
+				change.Type = Updated
+			}
+			change.Entity = entity
+		}
+		return processChange(ctx, change)
+	})
+}
+
+// deletionActions are actions of "delete" log entries which delete a page.
+//
+//nolint:gochecknoglobals
+var deletionActions = []string{"delete", "delete_redir"}
+
+// entityTitleRegex matches titles of Wikidata items, properties, and lexemes.
+var entityTitleRegex = regexp.MustCompile(`^(?:Q|Property:P|Lexeme:L)[1-9][0-9]*$`)
+
+// deletionPageChange returns the Deleted change for the log entry, or false
+// if the log entry is not a page deletion after the since date.
+func deletionPageChange(item LogItem, since time.Time) (PageChange, bool) {
+	if item.Type != "delete" || !slices.Contains(deletionActions, item.Action) || item.TitleDeleted || !item.Timestamp.After(since) {
+		return PageChange{}, false
+	}
+	return PageChange{
+		Type: Deleted,
+		Page: Page{
+			Title:        item.Title,
+			Namespace:    0,
+			ID:           0,
+			Redirect:     "",
+			Restrictions: "",
+			Revision: Revision{
+				ID:             0,
+				ParentID:       0,
+				Timestamp:      item.Timestamp,
+				Contributor:    item.Contributor,
+				Minor:          false,
+				Comment:        item.Comment,
+				CommentDeleted: item.CommentDeleted,
+				Origin:         0,
+				Model:          "",
+				Format:         "",
+				Text:           "",
+				TextID:         0,
+				TextBytes:      0,
+				TextDeleted:    false,
+				SHA1:           "",
+			},
+		},
+	}, true
+}
+
+// ProcessWikipediaDeletionsDump downloads (unless already saved), decompresses, decodes XML,
+// and calls processChange with a Deleted change for every page deletion after the since date
+// in a Wikipedia pages-logging XML dump (see LatestWikipediaPagesLoggingRun).
+//
+// Adds-changes (incremental) dumps do not include page deletions, so use it together with
+// ProcessWikipediaIncrementalDump to keep a copy of a wiki current. Pages-logging dumps are
+// published with regular dump runs and not daily, so deletions become known later than other
+// changes. Restored (undeleted) pages are not reported.
+//
+// Deletions are passed to processChange in the order they are in the dump (by log entry ID)
+// and from one goroutine (see Ordered in ProcessConfig). ItemsProcessingThreads is ignored.
+func ProcessWikipediaDeletionsDump(
+	ctx context.Context, config *ProcessDumpConfig, since time.Time,
+	processChange func(context.Context, PageChange) errors.E,
+) errors.E {
+	return Process(ctx, &ProcessConfig[LogItem]{
+		URL:                    config.URL,
+		Path:                   config.Path,
+		Client:                 config.Client,
+		DecompressionThreads:   config.DecompressionThreads,
+		DecodingThreads:        config.DecodingThreads,
+		ItemsProcessingThreads: config.ItemsProcessingThreads,
+		Process: func(ctx context.Context, item LogItem) errors.E {
+			change, ok := deletionPageChange(item, since)
+			if !ok {
+				return nil
+			}
+			return processChange(ctx, change)
+		},
+		Progress:         config.Progress,
+		Shard:            config.Shard,
+		Shards:           config.Shards,
+		Logger:           config.Logger,
+		Observer:         config.Observer,
+		Checksum:         config.Checksum,
+		CheckpointConfig: config.CheckpointConfig,
+		Ordered:          true,
+		FileType:         XML,
+		Compression:      GZIP,
+	})
+}
+
+// ProcessWikidataDeletionsDump downloads (unless already saved), decompresses, decodes XML,
+// and calls processChange with a Deleted change for every deletion of an item, a property,
+// or a lexeme after the since date in a Wikidata pages-logging XML dump
+// (see LatestWikidataPagesLoggingRun). Deletions of other pages are skipped.
+//
+// See ProcessWikipediaDeletionsDump for details.
+func ProcessWikidataDeletionsDump(
+	ctx context.Context, config *ProcessDumpConfig, since time.Time,
+	processChange func(context.Context, EntityChange) errors.E,
+) errors.E {
+	return ProcessWikipediaDeletionsDump(ctx, config, since, func(ctx context.Context, change PageChange) errors.E {
+		if !entityTitleRegex.MatchString(change.Page.Title) {
+			return nil
+		}
+		return processChange(ctx, EntityChange{
+			Type:           Deleted,
+			ID:             change.Page.Title[strings.LastIndex(change.Page.Title, ":")+1:],
+			Entity:         nil,
+			Lexeme:         nil,
+			RedirectTarget: "",
+			Page:           change.Page,
+		})
+	})
+}
@@ -0,0 +1,488 @@
+package mediawiki
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-retryablehttp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/go/errors"
+)
+
+const testIncremental = `<mediawiki xmlns="http://www.mediawiki.org/xml/export-0.11/" version="0.11" xml:lang="en">
+  <page>
+    <title>Q42</title>
+    <ns>0</ns>
+    <id>138</id>
+    <revision>
+      <id>1001</id>
+      <parentid>1000</parentid>
+      <timestamp>2024-04-15T14:38:04Z</timestamp>
+      <contributor>
+        <username>User</username>
+        <id>1</id>
+      </contributor>
+      <model>wikibase-item</model>
+      <format>application/json</format>
+      <text bytes="10" xml:space="preserve">{"type":"item","id":"Q42","labels":{"en":{"language":"en","value":"Douglas Adams"}},"descriptions":[],"aliases":[],"claims":[],"sitelinks":[]}</text>
+      <sha1>x</sha1>
+    </revision>
+    <revision>
+      <id>1002</id>
+      <parentid>1001</parentid>
+      <timestamp>2024-04-15T14:40:00Z</timestamp>
+      <contributor>
+        <username>User</username>
+        <id>1</id>
+      </contributor>
+      <model>wikibase-item</model>
+      <format>application/json</format>
+      <text bytes="10" deleted="deleted" />
+      <sha1 />
+    </revision>
+  </page>
+  <page>
+    <title>Property:P9999</title>
+    <ns>120</ns>
+    <id>200</id>
+    <revision>
+      <id>2001</id>
+      <timestamp>2024-04-15T15:00:00Z</timestamp>
+      <contributor>
+        <ip>192.0.2.1</ip>
+      </contributor>
+      <model>wikibase-property</model>
+      <format>application/json</format>
+      <text bytes="10" xml:space="preserve">{"type":"property","datatype":"string","id":"P9999","labels":[],"descriptions":[],"aliases":[],"claims":[]}</text>
+      <sha1>x</sha1>
+    </revision>
+  </page>
+  <page>
+    <title>Q43</title>
+    <ns>0</ns>
+    <id>139</id>
+    <redirect title="Q42" />
+    <revision>
+      <id>3001</id>
+      <parentid>3000</parentid>
+      <timestamp>2024-04-15T16:00:00Z</timestamp>
+      <contributor>
+        <username>User</username>
+        <id>1</id>
+      </contributor>
+      <model>wikibase-item</model>
+      <format>application/json</format>
+      <text bytes="10" xml:space="preserve">{"entity":"Q43","redirect":"Q42"}</text>
+      <sha1>x</sha1>
+    </revision>
+  </page>
+  <page>
+    <title>Lexeme:L7</title>
+    <ns>146</ns>
+    <id>150</id>
+    <revision>
+      <id>5001</id>
+      <timestamp>2024-04-15T18:00:00Z</timestamp>
+      <contributor>
+        <username>User</username>
+        <id>1</id>
+      </contributor>
+      <model>wikibase-lexeme</model>
+      <format>application/json</format>
+      <text bytes="10" xml:space="preserve">{"type":"lexeme","id":"L7","lemmas":{"en":{"language":"en","value":"cat"}},"lexicalCategory":"Q1084","language":"Q1860","claims":[],"nextFormId":2,"nextSenseId":2,"forms":[{"id":"L7-F1","representations":{"en":{"language":"en","value":"cats"}},"grammaticalFeatures":["Q146786"],"claims":[]}],"senses":[{"id":"L7-S1","glosses":{"en":{"language":"en","value":"domesticated mammal"}},"claims":[]}]}</text>
+      <sha1>x</sha1>
+    </revision>
+  </page>
+  <page>
+    <title>Talk:Q42</title>
+    <ns>1</ns>
+    <id>140</id>
+    <revision>
+      <id>4001</id>
+      <timestamp>2024-04-15T17:00:00Z</timestamp>
+      <contributor>
+        <username>User</username>
+        <id>1</id>
+      </contributor>
+      <model>wikitext</model>
+      <format>text/x-wiki</format>
+      <text bytes="5" xml:space="preserve">Hello</text>
+      <sha1>x</sha1>
+    </revision>
+  </page>
+</mediawiki>
+`
+
+func TestIncrementalRuns(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/wikidatawiki/":
+			fmt.Fprint(w, `<html><body><a href="../">../</a>`+
+				`<a href="20240101/">20240101/</a><a href="20240102/">20240102/</a>`+
+				`<a href="20240103/">20240103/</a><a href="20240104/">20240104/</a></body></html>`)
+		case "/wikidatawiki/20240101/status.txt", "/wikidatawiki/20240102/status.txt":
+			fmt.Fprint(w, "done\n")
+		case "/wikidatawiki/20240103/status.txt":
+			fmt.Fprint(w, "in-progress\n")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(ts.Close)
+
+	client := retryablehttp.NewClient()
+	client.Logger = nil
+
+	runs, errE := incrementalRuns(context.Background(), client, ts.URL+"/", "wikidatawiki", time.Time{})
+	require.NoError(t, errE, "% -+#.1v", errE)
+	require.Len(t, runs, 2)
+	assert.Equal(t, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), runs[0].Date)
+	assert.Equal(t, ts.URL+"/wikidatawiki/20240101/wikidatawiki-20240101-pages-meta-hist-incr.xml.bz2", runs[0].URL)
+	assert.Equal(t, ts.URL+"/wikidatawiki/20240101/wikidatawiki-20240101-stubs-meta-hist-incr.xml.gz", runs[0].StubsURL)
+	assert.Equal(t, time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), runs[1].Date)
+
+	runs, errE = incrementalRuns(context.Background(), client, ts.URL+"/", "wikidatawiki", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	require.NoError(t, errE, "% -+#.1v", errE)
+	require.Len(t, runs, 1)
+	assert.Equal(t, time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), runs[0].Date)
+}
+
+func TestProcessIncrementalDump(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "wikidatawiki-20240415-pages-meta-hist-incr.xml")
+	err := os.WriteFile(path, []byte(testIncremental), 0o600)
+	require.NoError(t, err)
+
+	t.Run("wikidata", func(t *testing.T) {
+		t.Parallel()
+
+		var mu sync.Mutex
+		changes := []EntityChange{}
+
+		errE := ProcessWikidataIncrementalDump(
+			context.Background(),
+			&ProcessDumpConfig{
+				Path: path,
+				// Both subtests process the same file, so each has its own checkpoint.
+				CheckpointConfig: &CheckpointConfig{
+					SaveInterval:   time.Minute,
+					ItemsThreshold: 1000,
+					Store:          NewMemoryCheckpointStore(),
+				},
+			},
+			func(_ context.Context, c EntityChange) errors.E {
+				mu.Lock()
+				defer mu.Unlock()
+				changes = append(changes, c)
+				return nil
+			},
+		)
+		require.NoError(t, errE, "% -+#.1v", errE)
+		require.Len(t, changes, 5)
+
+		byRevision := map[int64]EntityChange{}
+		for _, c := range changes {
+			assert.Empty(t, c.Page.Revision.Text)
+			byRevision[c.Page.Revision.ID] = c
+		}
+
+		c := byRevision[1001]
+		assert.Equal(t, Updated, c.Type)
+		assert.Equal(t, "Q42", c.ID)
+		require.NotNil(t, c.Entity)
+		assert.Equal(t, "Q42", c.Entity.ID)
+		assert.Equal(t, Item, c.Entity.Type)
+		assert.Equal(t, int64(138), c.Entity.PageID)
+		assert.Equal(t, int64(1001), c.Entity.LastRevID)
+		assert.Equal(t, time.Date(2024, 4, 15, 14, 38, 4, 0, time.UTC), c.Entity.Modified)
+		assert.Equal(t, "Douglas Adams", c.Entity.Labels["en"].Value)
+		assert.Nil(t, c.Entity.Descriptions)
+
+		c = byRevision[1002]
+		assert.Equal(t, Suppressed, c.Type)
+		assert.Equal(t, "Q42", c.ID)
+		assert.Nil(t, c.Entity)
+
+		c = byRevision[2001]
+		assert.Equal(t, Created, c.Type)
+		assert.Equal(t, "P9999", c.ID)
+		require.NotNil(t, c.Entity)
+		assert.Equal(t, Property, c.Entity.Type)
+		require.NotNil(t, c.Entity.DataType)
+		assert.Equal(t, String, *c.Entity.DataType)
+		assert.Equal(t, 120, c.Entity.Namespace)
+
+		c = byRevision[3001]
+		assert.Equal(t, Redirected, c.Type)
+		assert.Equal(t, "Q43", c.ID)
+		assert.Equal(t, "Q42", c.RedirectTarget)
+		assert.Nil(t, c.Entity)
+
+		c = byRevision[5001]
+		assert.Equal(t, Created, c.Type)
+		assert.Equal(t, "L7", c.ID)
+		assert.Nil(t, c.Entity)
+		require.NotNil(t, c.Lexeme)
+		assert.Equal(t, LexemeT, c.Lexeme.Type)
+		assert.Equal(t, int64(150), c.Lexeme.PageID)
+		assert.Equal(t, "cat", c.Lexeme.Lemmas["en"].Value)
+		assert.Equal(t, "Q1084", c.Lexeme.LexicalCategory)
+		assert.Nil(t, c.Lexeme.Claims)
+		require.Len(t, c.Lexeme.Forms, 1)
+		assert.Equal(t, "cats", c.Lexeme.Forms[0].Representations["en"].Value)
+		require.Len(t, c.Lexeme.Senses, 1)
+		assert.Equal(t, "domesticated mammal", c.Lexeme.Senses[0].Glosses["en"].Value)
+	})
+
+	t.Run("wikipedia", func(t *testing.T) {
+		t.Parallel()
+
+		var mu sync.Mutex
+		changes := map[int64]ChangeType{}
+
+		errE := ProcessWikipediaIncrementalDump(
+			context.Background(),
+			&ProcessDumpConfig{
+				Path: path,
+				// Both subtests process the same file, so each has its own checkpoint.
+				CheckpointConfig: &CheckpointConfig{
+					SaveInterval:   time.Minute,
+					ItemsThreshold: 1000,
+					Store:          NewMemoryCheckpointStore(),
+				},
+			},
+			func(_ context.Context, c PageChange) errors.E {
+				mu.Lock()
+				defer mu.Unlock()
+				changes[c.Page.Revision.ID] = c.Type
+				return nil
+			},
+		)
+		require.NoError(t, errE, "% -+#.1v", errE)
+		assert.Equal(t, map[int64]ChangeType{
+			1001: Updated,
+			1002: Suppressed,
+			2001: Created,
+			3001: Redirected,
+			4001: Created,
+			5001: Created,
+		}, changes)
+	})
+}
+
+func TestChangeTypeJSON(t *testing.T) {
+	t.Parallel()
+
+	for _, changeType := range []ChangeType{Created, Updated, Suppressed, Redirected, Deleted} {
+		data, err := changeType.MarshalJSON()
+		require.NoError(t, err)
+		var c ChangeType
+		err = c.UnmarshalJSON(data)
+		require.NoError(t, err)
+		assert.Equal(t, changeType, c)
+	}
+
+	var c ChangeType
+	err := c.UnmarshalJSON([]byte(`"foobar"`))
+	assert.ErrorIs(t, err, ErrInvalidValue)
+
+	_, err = ChangeType(100).MarshalJSON()
+	assert.ErrorIs(t, err, ErrInvalidValue)
+	_, err = json.Marshal(PageChange{Type: ChangeType(100), Page: Page{}}) //nolint:exhaustruct
+	assert.ErrorIs(t, err, ErrInvalidValue)
+}
+
+const testPagesLogging = `<mediawiki xmlns="http://www.mediawiki.org/xml/export-0.11/" version="0.11" xml:lang="en">
+  <siteinfo>
+    <sitename>Wikidata</sitename>
+  </siteinfo>
+  <logitem>
+    <id>1</id>
+    <timestamp>2024-04-10T10:00:00Z</timestamp>
+    <contributor>
+      <username>Admin</username>
+      <id>1</id>
+    </contributor>
+    <comment>Before since</comment>
+    <type>delete</type>
+    <action>delete</action>
+    <logtitle>Q1</logtitle>
+    <params xml:space="preserve" />
+  </logitem>
+  <logitem>
+    <id>2</id>
+    <timestamp>2024-04-16T10:00:00Z</timestamp>
+    <contributor>
+      <username>Admin</username>
+      <id>1</id>
+    </contributor>
+    <comment>Duplicate</comment>
+    <type>delete</type>
+    <action>delete</action>
+    <logtitle>Q43</logtitle>
+    <params xml:space="preserve" />
+  </logitem>
+  <logitem>
+    <id>3</id>
+    <timestamp>2024-04-16T11:00:00Z</timestamp>
+    <contributor>
+      <username>Admin</username>
+      <id>1</id>
+    </contributor>
+    <type>delete</type>
+    <action>restore</action>
+    <logtitle>Q44</logtitle>
+    <params xml:space="preserve" />
+  </logitem>
+  <logitem>
+    <id>4</id>
+    <timestamp>2024-04-16T12:00:00Z</timestamp>
+    <contributor>
+      <username>Admin</username>
+      <id>1</id>
+    </contributor>
+    <comment deleted="deleted" />
+    <type>delete</type>
+    <action>delete</action>
+    <logtitle>Property:P9999</logtitle>
+    <params xml:space="preserve" />
+  </logitem>
+  <logitem>
+    <id>5</id>
+    <timestamp>2024-04-16T13:00:00Z</timestamp>
+    <contributor>
+      <username>Admin</username>
+      <id>1</id>
+    </contributor>
+    <comment>Spam</comment>
+    <type>delete</type>
+    <action>delete</action>
+    <logtitle>User:Spammer</logtitle>
+    <params xml:space="preserve" />
+  </logitem>
+  <logitem>
+    <id>6</id>
+    <timestamp>2024-04-16T14:00:00Z</timestamp>
+    <contributor>
+      <username>Admin</username>
+      <id>1</id>
+    </contributor>
+    <type>block</type>
+    <action>block</action>
+    <logtitle>User:Spammer</logtitle>
+    <params xml:space="preserve">a:0:{}</params>
+  </logitem>
+  <logitem>
+    <id>7</id>
+    <timestamp>2024-04-16T15:00:00Z</timestamp>
+    <contributor>
+      <username>Admin</username>
+      <id>1</id>
+    </contributor>
+    <comment>Test lexeme</comment>
+    <type>delete</type>
+    <action>delete</action>
+    <logtitle>Lexeme:L7</logtitle>
+    <params xml:space="preserve" />
+  </logitem>
+</mediawiki>
+`
+
+func TestProcessDeletionsDump(t *testing.T) {
+	t.Parallel()
+
+	var buffer bytes.Buffer
+	w := gzip.NewWriter(&buffer)
+	_, err := w.Write([]byte(testPagesLogging))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	path := filepath.Join(t.TempDir(), "wikidatawiki-20240420-pages-logging.xml.gz")
+	err = os.WriteFile(path, buffer.Bytes(), 0o600)
+	require.NoError(t, err)
+
+	since := time.Date(2024, 4, 15, 0, 0, 0, 0, time.UTC)
+
+	t.Run("wikidata", func(t *testing.T) {
+		t.Parallel()
+
+		changes := []EntityChange{}
+		errE := ProcessWikidataDeletionsDump(
+			context.Background(),
+			&ProcessDumpConfig{
+				Path: path,
+				// Both subtests process the same file, so each has its own checkpoint.
+				CheckpointConfig: &CheckpointConfig{
+					SaveInterval:   time.Minute,
+					ItemsThreshold: 1000,
+					Store:          NewMemoryCheckpointStore(),
+				},
+			},
+			since,
+			func(_ context.Context, c EntityChange) errors.E {
+				changes = append(changes, c)
+				return nil
+			},
+		)
+		require.NoError(t, errE, "% -+#.1v", errE)
+		require.Len(t, changes, 3)
+
+		assert.Equal(t, Deleted, changes[0].Type)
+		assert.Equal(t, "Q43", changes[0].ID)
+		assert.Nil(t, changes[0].Entity)
+		assert.Equal(t, "Q43", changes[0].Page.Title)
+		assert.Equal(t, time.Date(2024, 4, 16, 10, 0, 0, 0, time.UTC), changes[0].Page.Revision.Timestamp)
+		assert.Equal(t, "Admin", changes[0].Page.Revision.Contributor.Username)
+		assert.Equal(t, "Duplicate", changes[0].Page.Revision.Comment)
+
+		assert.Equal(t, Deleted, changes[1].Type)
+		assert.Equal(t, "P9999", changes[1].ID)
+		assert.True(t, changes[1].Page.Revision.CommentDeleted)
+
+		assert.Equal(t, Deleted, changes[2].Type)
+		assert.Equal(t, "L7", changes[2].ID)
+
+		data, err := json.Marshal(changes[0])
+		require.NoError(t, err)
+		assert.Contains(t, string(data), `"type":"deleted"`)
+	})
+
+	t.Run("wikipedia", func(t *testing.T) {
+		t.Parallel()
+
+		titles := []string{}
+		errE := ProcessWikipediaDeletionsDump(
+			context.Background(),
+			&ProcessDumpConfig{
+				Path: path,
+				// Both subtests process the same file, so each has its own checkpoint.
+				CheckpointConfig: &CheckpointConfig{
+					SaveInterval:   time.Minute,
+					ItemsThreshold: 1000,
+					Store:          NewMemoryCheckpointStore(),
+				},
+			},
+			since,
+			func(_ context.Context, c PageChange) errors.E {
+				assert.Equal(t, Deleted, c.Type)
+				titles = append(titles, c.Page.Title)
+				return nil
+			},
+		)
+		require.NoError(t, errE, "% -+#.1v", errE)
+		assert.Equal(t, []string{"Q43", "Property:P9999", "User:Spammer", "Lexeme:L7"}, titles)
+	})
+}
@@ -0,0 +1,112 @@
+package mediawiki
+
+import (
+	"context"
+	"iter"
+
+	"gitlab.com/tozd/go/errors"
+)
+
+// Items returns an iterator over all items in a dump, using the same download, decompression,
+// and decoding stages as Process. Process and ProcessBatch fields of config are ignored.
+//
+// Processing starts when iteration starts and is done again for every iteration.
+// Every iteration uses its own MemoryCheckpointStore (other fields of CheckpointConfig
+// are used as configured), so it processes the whole dump and does not resume from
+// nor update a checkpoint of an earlier iteration or of Process. An item is completed
+// in the checkpoint only after the loop body for it returns.
+// Breaking out of the loop cancels processing and waits for it to stop.
+// If processing fails, the error is yielded as the last value.
+//
+// Items are yielded from the goroutine iterating, so yielded values can be
+// used without locking, but the order of items is not guaranteed unless
+// Ordered is set in config.
+func Items[T any](ctx context.Context, config *ProcessConfig[T]) iter.Seq2[T, error] {
+	return items(ctx, func(ctx context.Context, process func(context.Context, T) errors.E) errors.E {
+		c := *config
+		c.Process = process
+		c.ProcessBatch = nil
+		c.CheckpointConfig = iterationCheckpointConfig(config.CheckpointConfig)
+		return Process(ctx, &c)
+	})
+}
+
+// iterationCheckpointConfig returns a copy of config with a new MemoryCheckpointStore.
+func iterationCheckpointConfig(config *CheckpointConfig) *CheckpointConfig {
+	c := CheckpointConfig{
+		SaveInterval:    saveInterval,
+		ItemsThreshold:  itemsThreshold,
+		CheckpointFile:  "",
+		Store:           nil,
+		ResetOnMismatch: false,
+		Logger:          nil,
+	}
+	if config != nil {
+		c = *config
+	}
+	c.CheckpointFile = ""
+	c.Store = NewMemoryCheckpointStore()
+	return &c
+}
+
+// iterValue is a value passed to the iterating goroutine. The iterating goroutine
+// closes done once the loop body for the value returns.
+type iterValue[T any] struct {
+	value T
+	done  chan struct{}
+}
+
+// items returns an iterator over items passed to the process callback by run.
+func items[T any](
+	ctx context.Context, run func(context.Context, func(context.Context, T) errors.E) errors.E,
+) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		ctx, cancel := context.WithCancel(ctx)
+		values := make(chan iterValue[T])
+		result := make(chan errors.E, 1)
+
+		go func() {
+			defer close(values)
+			result <- run(ctx, func(ctx context.Context, value T) errors.E {
+				// We check first so that we stop as soon as possible after cancellation.
+				if ctx.Err() != nil {
+					return errors.WithStack(ctx.Err())
+				}
+				done := make(chan struct{})
+				select {
+				case <-ctx.Done():
+					return errors.WithStack(ctx.Err())
+				case values <- iterValue[T]{value: value, done: done}:
+				}
+				// We return (and the item is completed) only after the loop body for it returns.
+				select {
+				case <-ctx.Done():
+					return errors.WithStack(ctx.Err())
+				case <-done:
+					return nil
+				}
+			})
+		}()
+
+		// If we return early, we cancel processing and wait for it to stop.
+		defer func() {
+			cancel()
+			for range values {
+				// Drain values until the goroutine closes them.
+			}
+		}()
+
+		for value := range values {
+			if !yield(value.value, nil) {
+				return
+			}
+			close(value.done)
+		}
+
+		errE := <-result
+		if errE != nil {
+			var zero T
+			yield(zero, errE)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package mediawiki_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/citadel2024/go-mediawiki"
+)
+
+func TestItems(t *testing.T) {
+	t.Parallel()
+
+	tempDir := t.TempDir()
+
+	var ndjson bytes.Buffer
+	for i := range 1000 {
+		fmt.Fprintf(&ndjson, `{"n":%d}`+"\n", i)
+	}
+	path := filepath.Join(tempDir, "items.ndjson")
+	err := os.WriteFile(path, ndjson.Bytes(), 0o600)
+	require.NoError(t, err)
+
+	config := func() *mediawiki.ProcessConfig[testNumber] {
+		return &mediawiki.ProcessConfig[testNumber]{
+			Path:        path,
+			FileType:    mediawiki.NDJSON,
+			Compression: mediawiki.NoCompression,
+		}
+	}
+
+	t.Run("all", func(t *testing.T) {
+		t.Parallel()
+
+		seen := map[int]bool{}
+		for i, err := range mediawiki.Items(context.Background(), config()) {
+			require.NoError(t, err)
+			seen[i.N] = true
+		}
+		assert.Len(t, seen, 1000)
+	})
+
+	t.Run("again", func(t *testing.T) {
+		t.Parallel()
+
+		// Every iteration processes the whole dump.
+		seq := mediawiki.Items(context.Background(), config())
+		for range 2 {
+			count := 0
+			for _, err := range seq {
+				require.NoError(t, err)
+				count++
+			}
+			assert.Equal(t, 1000, count)
+		}
+	})
+
+	t.Run("ordered", func(t *testing.T) {
+		t.Parallel()
+
+		c := config()
+		c.Ordered = true
+		numbers := []int{}
+		for i, err := range mediawiki.Items(context.Background(), c) {
+			require.NoError(t, err)
+			numbers = append(numbers, i.N)
+		}
+		require.Len(t, numbers, 1000)
+		for i, n := range numbers {
+			require.Equal(t, i, n)
+		}
+	})
+
+	t.Run("break", func(t *testing.T) {
+		t.Parallel()
+
+		count := 0
+		for _, err := range mediawiki.Items(context.Background(), config()) {
+			require.NoError(t, err)
+			count++
+			if count == 10 {
+				break
+			}
+		}
+		assert.Equal(t, 10, count)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		count := 0
+		var lastErr error
+		for _, err := range mediawiki.Items(ctx, config()) {
+			if err != nil {
+				lastErr = err
+				continue
+			}
+			count++
+			if count == 10 {
+				cancel()
+			}
+		}
+		assert.ErrorIs(t, lastErr, context.Canceled)
+		assert.Less(t, count, 1000)
+	})
+}
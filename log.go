@@ -0,0 +1,25 @@
+package mediawiki
+
+import (
+	"context"
+	"log/slog"
+)
+
+// discardHandler is a slog.Handler which discards all records.
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
+
+// discardLogger is used when no logger is configured, so that logging is silent by default.
+var discardLogger = slog.New(discardHandler{}) //nolint:gochecknoglobals
+
+// loggerOrDiscard returns logger or discardLogger if logger is nil.
+func loggerOrDiscard(logger *slog.Logger) *slog.Logger {
+	if logger == nil {
+		return discardLogger
+	}
+	return logger
+}
@@ -0,0 +1,182 @@
+package mediawiki
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"gitlab.com/tozd/go/errors"
+)
+
+// metricsDurationBuckets are upper bounds (in seconds) of buckets of the callback duration histogram.
+var metricsDurationBuckets = []float64{ //nolint:gochecknoglobals
+	0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10,
+}
+
+var (
+	_ Observer     = (*Metrics)(nil)
+	_ http.Handler = (*Metrics)(nil)
+)
+
+type queueLength struct {
+	length   int
+	capacity int
+}
+
+// Metrics is an Observer which collects metrics of the processing pipeline.
+//
+// It implements http.Handler which exposes collected metrics in Prometheus text format:
+//
+//   - mediawiki_rows_read_total: rows read from the dump.
+//   - mediawiki_items_decoded_total: items successfully decoded.
+//   - mediawiki_items_processed_total: items passed to the Process (or ProcessBatch) callback.
+//   - mediawiki_item_errors_total{stage}: items which failed to decode or process, per stage.
+//   - mediawiki_queue_length{queue} and mediawiki_queue_capacity{queue}: last observed
+//     occupancy of rows and items queues.
+//   - mediawiki_callback_duration_seconds: histogram of Process (or ProcessBatch) callback durations.
+//
+// Metrics can be shared between multiple Process calls, in which case they are summed up.
+type Metrics struct {
+	mu             sync.Mutex
+	rowsRead       int64
+	itemsDecoded   int64
+	itemsProcessed int64
+	itemErrors     map[ErrorStage]int64
+	queues         map[string]queueLength
+	// durationBuckets are non-cumulative counts of the histogram, with the last one for +Inf.
+	durationBuckets []int64
+	durationSum     float64
+	durationCount   int64
+}
+
+// NewMetrics returns a new Metrics with all metrics at zero.
+func NewMetrics() *Metrics {
+	return &Metrics{
+		mu:              sync.Mutex{},
+		rowsRead:        0,
+		itemsDecoded:    0,
+		itemsProcessed:  0,
+		itemErrors:      map[ErrorStage]int64{DecodeStage: 0, ProcessStage: 0},
+		queues:          map[string]queueLength{},
+		durationBuckets: make([]int64, len(metricsDurationBuckets)+1),
+		durationSum:     0,
+		durationCount:   0,
+	}
+}
+
+// RowRead implements Observer interface.
+func (m *Metrics) RowRead(_ context.Context) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rowsRead++
+}
+
+// ItemDecoded implements Observer interface.
+func (m *Metrics) ItemDecoded(_ context.Context) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.itemsDecoded++
+}
+
+// ItemsProcessed implements Observer interface.
+func (m *Metrics) ItemsProcessed(_ context.Context, items int, duration time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.itemsProcessed += int64(items)
+	seconds := duration.Seconds()
+	// If there is no such bucket, the index is of the +Inf bucket.
+	i, _ := slices.BinarySearch(metricsDurationBuckets, seconds)
+	m.durationBuckets[i]++
+	m.durationSum += seconds
+	m.durationCount++
+}
+
+// ItemFailed implements Observer interface.
+func (m *Metrics) ItemFailed(_ context.Context, stage ErrorStage) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.itemErrors[stage]++
+}
+
+// QueueLength implements Observer interface.
+func (m *Metrics) QueueLength(_ context.Context, queue string, length, capacity int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.queues[queue] = queueLength{length: length, capacity: capacity}
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// WritePrometheus writes collected metrics to w in Prometheus text format.
+func (m *Metrics) WritePrometheus(w io.Writer) errors.E {
+	var b bytes.Buffer
+
+	m.mu.Lock()
+	fmt.Fprintf(&b, "# HELP mediawiki_rows_read_total Rows read from the dump.\n")
+	fmt.Fprintf(&b, "# TYPE mediawiki_rows_read_total counter\n")
+	fmt.Fprintf(&b, "mediawiki_rows_read_total %d\n", m.rowsRead)
+	fmt.Fprintf(&b, "# HELP mediawiki_items_decoded_total Items successfully decoded.\n")
+	fmt.Fprintf(&b, "# TYPE mediawiki_items_decoded_total counter\n")
+	fmt.Fprintf(&b, "mediawiki_items_decoded_total %d\n", m.itemsDecoded)
+	fmt.Fprintf(&b, "# HELP mediawiki_items_processed_total Items passed to the callback.\n")
+	fmt.Fprintf(&b, "# TYPE mediawiki_items_processed_total counter\n")
+	fmt.Fprintf(&b, "mediawiki_items_processed_total %d\n", m.itemsProcessed)
+
+	fmt.Fprintf(&b, "# HELP mediawiki_item_errors_total Items which failed, per stage.\n")
+	fmt.Fprintf(&b, "# TYPE mediawiki_item_errors_total counter\n")
+	stages := make([]string, 0, len(m.itemErrors))
+	for stage := range m.itemErrors {
+		stages = append(stages, string(stage))
+	}
+	slices.Sort(stages)
+	for _, stage := range stages {
+		fmt.Fprintf(&b, "mediawiki_item_errors_total{stage=%q} %d\n", stage, m.itemErrors[ErrorStage(stage)])
+	}
+
+	queues := make([]string, 0, len(m.queues))
+	for queue := range m.queues {
+		queues = append(queues, queue)
+	}
+	slices.Sort(queues)
+	fmt.Fprintf(&b, "# HELP mediawiki_queue_length Elements in the queue.\n")
+	fmt.Fprintf(&b, "# TYPE mediawiki_queue_length gauge\n")
+	for _, queue := range queues {
+		fmt.Fprintf(&b, "mediawiki_queue_length{queue=%q} %d\n", queue, m.queues[queue].length)
+	}
+	fmt.Fprintf(&b, "# HELP mediawiki_queue_capacity Capacity of the queue.\n")
+	fmt.Fprintf(&b, "# TYPE mediawiki_queue_capacity gauge\n")
+	for _, queue := range queues {
+		fmt.Fprintf(&b, "mediawiki_queue_capacity{queue=%q} %d\n", queue, m.queues[queue].capacity)
+	}
+
+	fmt.Fprintf(&b, "# HELP mediawiki_callback_duration_seconds Duration of callback calls.\n")
+	fmt.Fprintf(&b, "# TYPE mediawiki_callback_duration_seconds histogram\n")
+	cumulative := int64(0)
+	for i, bound := range metricsDurationBuckets {
+		cumulative += m.durationBuckets[i]
+		fmt.Fprintf(&b, "mediawiki_callback_duration_seconds_bucket{le=%q} %d\n", formatFloat(bound), cumulative)
+	}
+	cumulative += m.durationBuckets[len(metricsDurationBuckets)]
+	fmt.Fprintf(&b, "mediawiki_callback_duration_seconds_bucket{le=\"+Inf\"} %d\n", cumulative)
+	fmt.Fprintf(&b, "mediawiki_callback_duration_seconds_sum %s\n", formatFloat(m.durationSum))
+	fmt.Fprintf(&b, "mediawiki_callback_duration_seconds_count %d\n", m.durationCount)
+	m.mu.Unlock()
+
+	_, err := w.Write(b.Bytes())
+	return errors.WithStack(err)
+}
+
+// ServeHTTP implements http.Handler interface. It responds with collected metrics
+// in Prometheus text format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_ = m.WritePrometheus(w)
+}
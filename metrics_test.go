@@ -0,0 +1,88 @@
+package mediawiki_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/go/errors"
+
+	"github.com/citadel2024/go-mediawiki"
+)
+
+func TestMetrics(t *testing.T) {
+	t.Parallel()
+
+	tempDir := t.TempDir()
+
+	var ndjson bytes.Buffer
+	for i := range 100 {
+		if i == 10 {
+			fmt.Fprintf(&ndjson, `{"n":"invalid"}`+"\n")
+			continue
+		}
+		fmt.Fprintf(&ndjson, `{"n":%d}`+"\n", i)
+	}
+	path := filepath.Join(tempDir, "items.ndjson")
+	err := os.WriteFile(path, ndjson.Bytes(), 0o600)
+	require.NoError(t, err)
+
+	metrics := mediawiki.NewMetrics()
+
+	errE := mediawiki.Process(context.Background(), &mediawiki.ProcessConfig[testNumber]{
+		Path:        path,
+		FileType:    mediawiki.NDJSON,
+		Compression: mediawiki.NoCompression,
+		ErrorPolicy: mediawiki.SkipOnError,
+		Observer:    metrics,
+		Process: func(_ context.Context, i testNumber) errors.E {
+			if i.N == 20 {
+				return errors.New("failed")
+			}
+			return nil
+		},
+		DecodingThreads:        2,
+		ItemsProcessingThreads: 3,
+		CheckpointConfig: &mediawiki.CheckpointConfig{
+			SaveInterval:   time.Minute,
+			ItemsThreshold: 1000,
+			Store:          mediawiki.NewMemoryCheckpointStore(),
+		},
+	})
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	// Durations are not deterministic, so we record one more known duration.
+	metrics.ItemsProcessed(context.Background(), 0, time.Hour)
+
+	recorder := httptest.NewRecorder()
+	metrics.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", recorder.Header().Get("Content-Type"))
+	body := recorder.Body.String()
+
+	for _, line := range []string{
+		"# TYPE mediawiki_rows_read_total counter",
+		"mediawiki_rows_read_total 100",
+		"mediawiki_items_decoded_total 99",
+		"mediawiki_items_processed_total 99",
+		`mediawiki_item_errors_total{stage="decode"} 1`,
+		`mediawiki_item_errors_total{stage="process"} 1`,
+		"# TYPE mediawiki_queue_length gauge",
+		`mediawiki_queue_capacity{queue="rows"} 2`,
+		`mediawiki_queue_capacity{queue="items"} 3`,
+		"# TYPE mediawiki_callback_duration_seconds histogram",
+		`mediawiki_callback_duration_seconds_bucket{le="10"} 99`,
+		`mediawiki_callback_duration_seconds_bucket{le="+Inf"} 100`,
+		"mediawiki_callback_duration_seconds_count 100",
+	} {
+		assert.Contains(t, body, line+"\n")
+	}
+}
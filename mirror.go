@@ -0,0 +1,193 @@
+package mediawiki
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/go-retryablehttp"
+	"gitlab.com/tozd/go/errors"
+)
+
+// MirrorHeader is the response header which MirrorTransport sets to the mirror which served the response.
+const MirrorHeader = "X-Dump-Mirror"
+
+// maxMirrorDrain is the maximum number of bytes read from a failed response before it is closed.
+const maxMirrorDrain = 64 * 1024
+
+var _ http.RoundTripper = (*MirrorTransport)(nil)
+
+// MirrorConfig configures mirrors of the dumps site (https://dumps.wikimedia.org/).
+//
+// Mirrors is an ordered list of base URLs of mirrors, e.g., "https://dumps.example.com/wikimedia".
+// A mirror can also be a local directory laid out like the dumps site, e.g., "file:///srv/dumps".
+// Include "https://dumps.wikimedia.org" in the list to fall back to the dumps site itself.
+//
+// Transport is used for HTTP mirrors. If it is nil, http.DefaultTransport is used.
+// Logger is used for diagnostic events. If it is nil, nothing is logged.
+type MirrorConfig struct {
+	Mirrors   []string
+	Transport http.RoundTripper
+	Logger    *slog.Logger
+}
+
+type mirror struct {
+	// base is the base URL of the mirror, without the trailing slash.
+	base string
+	// prefix is prepended to paths of requested files. For local directories
+	// it is just the scheme because the directory is served by the transport.
+	prefix    string
+	transport http.RoundTripper
+}
+
+// MirrorTransport is a http.RoundTripper which serves GET and HEAD requests for files
+// on the dumps site from mirrors. Mirrors are tried in order and it fails over to the next
+// mirror on errors and on not found, too many requests, and server error responses
+// (mirrors can lag behind the dumps site). If all mirrors fail, the last error or
+// response is returned. Other requests are passed through unchanged.
+//
+// The mirror which served the response is set in the MirrorHeader response header.
+//
+// Because all requests go through the transport, it is used both for discovery of
+// dump runs (e.g., LatestWikipediaPagesArticlesRun) and for downloading dumps.
+// Discovered URLs are still URLs on the dumps site.
+type MirrorTransport struct {
+	mirrors   []mirror
+	transport http.RoundTripper
+	logger    *slog.Logger
+}
+
+// NewMirrorTransport returns a new MirrorTransport for config.
+func NewMirrorTransport(config *MirrorConfig) (*MirrorTransport, errors.E) {
+	if len(config.Mirrors) == 0 {
+		return nil, errors.WithMessage(ErrInvalidValue, "no mirrors")
+	}
+	transport := config.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	mirrors := []mirror{}
+	for _, m := range config.Mirrors {
+		u, err := url.Parse(m)
+		if err != nil {
+			errE := errors.WithMessage(err, "parse mirror")
+			errors.Details(errE)["mirror"] = m
+			return nil, errE
+		}
+		switch u.Scheme {
+		case "http", "https":
+			mirrors = append(mirrors, mirror{
+				base:      strings.TrimSuffix(m, "/"),
+				prefix:    strings.TrimSuffix(m, "/"),
+				transport: transport,
+			})
+		case "file":
+			if u.Host != "" || u.Path == "" {
+				return nil, errors.WithDetails(ErrInvalidValue, "mirror", m)
+			}
+			mirrors = append(mirrors, mirror{
+				base:      strings.TrimSuffix(m, "/"),
+				prefix:    "file://",
+				transport: http.NewFileTransport(http.Dir(u.Path)),
+			})
+		default:
+			return nil, errors.WithDetails(ErrInvalidValue, "mirror", m)
+		}
+	}
+	return &MirrorTransport{
+		mirrors:   mirrors,
+		transport: transport,
+		logger:    loggerOrDiscard(config.Logger),
+	}, nil
+}
+
+// UseMirrors configures client to use mirrors from config for all requests
+// to the dumps site. It wraps the existing transport of the client.
+func UseMirrors(client *retryablehttp.Client, config *MirrorConfig) errors.E {
+	c := *config
+	if c.Transport == nil {
+		c.Transport = client.HTTPClient.Transport
+	}
+	transport, errE := NewMirrorTransport(&c)
+	if errE != nil {
+		return errE
+	}
+	client.HTTPClient.Transport = transport
+	return nil
+}
+
+// mirrorFailed returns true if the mirror failed to serve the response and the next mirror should be tried.
+func mirrorFailed(resp *http.Response) bool {
+	return resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusTooManyRequests ||
+		resp.StatusCode >= http.StatusInternalServerError
+}
+
+// RoundTrip implements http.RoundTripper interface.
+func (t *MirrorTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	target := req.URL.String()
+	if (req.Method != http.MethodGet && req.Method != http.MethodHead) || !strings.HasPrefix(target, dumpsBaseURL+"/") {
+		return t.transport.RoundTrip(req) //nolint:wrapcheck
+	}
+	path := strings.TrimPrefix(target, dumpsBaseURL)
+
+	var lastResp *http.Response
+	var lastErr error
+	for i, m := range t.mirrors {
+		if err := req.Context().Err(); err != nil {
+			break
+		}
+		if lastResp != nil {
+			// We failed over, so we discard the previous response.
+			io.Copy(io.Discard, io.LimitReader(lastResp.Body, maxMirrorDrain)) //nolint:errcheck
+			lastResp.Body.Close()
+		}
+		lastResp, lastErr = nil, nil
+
+		mirrorURL, err := url.Parse(m.prefix + path)
+		if err != nil {
+			lastErr = errors.WithStack(err)
+			continue
+		}
+		mirrorReq := req.Clone(req.Context())
+		mirrorReq.URL = mirrorURL
+		mirrorReq.Host = ""
+
+		resp, err := m.transport.RoundTrip(mirrorReq)
+		if err != nil {
+			lastErr = errors.WithStack(err)
+		} else {
+			lastResp = resp
+			lastResp.Request = req
+			lastResp.Header.Set(MirrorHeader, m.base)
+			if resp.ContentLength < 0 {
+				// Transport for local directories does not set ContentLength from the header.
+				if length, err := strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64); err == nil {
+					resp.ContentLength = length
+				}
+			}
+		}
+
+		if err == nil && !mirrorFailed(resp) {
+			t.logger.Debug("served from mirror", "url", target, "mirror", m.base)
+			return lastResp, nil
+		}
+		if i < len(t.mirrors)-1 {
+			if err != nil {
+				t.logger.Warn("mirror failed, failing over", "url", target, "mirror", m.base, "error", err)
+			} else {
+				t.logger.Warn("mirror failed, failing over", "url", target, "mirror", m.base, "status", resp.Status)
+			}
+		}
+	}
+
+	if lastResp != nil {
+		return lastResp, nil
+	}
+	if lastErr == nil {
+		lastErr = errors.WithStack(req.Context().Err())
+	}
+	return nil, lastErr
+}
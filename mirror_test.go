@@ -0,0 +1,130 @@
+package mediawiki_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-retryablehttp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/go/errors"
+
+	"github.com/citadel2024/go-mediawiki"
+)
+
+func writeMirrorFile(t *testing.T, dir, path, content string) {
+	t.Helper()
+
+	path = filepath.Join(dir, filepath.FromSlash(path))
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+}
+
+func TestMirrors(t *testing.T) {
+	t.Parallel()
+
+	// A local directory laid out like the dumps site.
+	dir := t.TempDir()
+	for date, status := range map[string]string{
+		"20240801": "done",
+		"20240901": "done",
+		"20241001": "in-progress",
+	} {
+		name := fmt.Sprintf("enwiki-%s-pages-articles.xml.bz2", date)
+		writeMirrorFile(t, dir, fmt.Sprintf("enwiki/%s/dumpstatus.json", date), fmt.Sprintf(
+			`{"jobs":{"articlesdump":{"status":"%s","files":{"%s":{"url":"/enwiki/%s/%s"}}}},"version":"0.8"}`,
+			status, name, date, name,
+		))
+		if status == "done" {
+			writeMirrorFile(t, dir, fmt.Sprintf("enwiki/%s/%s", date, name), "")
+		}
+	}
+	var ndjson strings.Builder
+	for i := range 100 {
+		fmt.Fprintf(&ndjson, `{"n":%d}`+"\n", i)
+	}
+	writeMirrorFile(t, dir, "other/test/dump.ndjson", ndjson.String())
+
+	// A mirror which is failing.
+	var failed atomic.Int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		failed.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(ts.Close)
+
+	client := retryablehttp.NewClient()
+	client.RetryMax = 0
+	client.Logger = nil
+	errE := mediawiki.UseMirrors(client, &mediawiki.MirrorConfig{
+		Mirrors:   []string{ts.URL + "/", "file://" + filepath.ToSlash(dir)},
+		Transport: nil,
+		Logger:    nil,
+	})
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	url, errE := mediawiki.LatestWikipediaPagesArticlesRun(context.Background(), client, "enwiki")
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, "https://dumps.wikimedia.org/enwiki/20240901/enwiki-20240901-pages-articles.xml.bz2", url)
+	assert.Positive(t, failed.Load())
+
+	resp, err := client.Head("https://dumps.wikimedia.org/enwiki/20241001/enwiki-20241001-pages-articles.xml.bz2")
+	require.NoError(t, err)
+	resp.Body.Close()
+	// All mirrors failed and we get the response from the last one.
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Equal(t, "file://"+filepath.ToSlash(dir), resp.Header.Get(mediawiki.MirrorHeader))
+
+	var output bytes.Buffer
+	var mu sync.Mutex
+	logger := slog.New(slog.NewJSONHandler(&lockedWriter{w: &output, mu: &mu}, nil))
+
+	var processed atomic.Int64
+	errE = mediawiki.Process(context.Background(), &mediawiki.ProcessConfig[testNumber]{
+		URL:                    "https://dumps.wikimedia.org/other/test/dump.ndjson",
+		Client:                 client,
+		FileType:               mediawiki.NDJSON,
+		Compression:            mediawiki.NoCompression,
+		ItemsProcessingThreads: 1,
+		Logger:                 logger,
+		CheckpointConfig: &mediawiki.CheckpointConfig{
+			SaveInterval:   time.Minute,
+			ItemsThreshold: 1000,
+			Store:          mediawiki.NewMemoryCheckpointStore(),
+		},
+		Process: func(_ context.Context, _ testNumber) errors.E {
+			processed.Add(1)
+			return nil
+		},
+	})
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, int64(100), processed.Load())
+
+	found := false
+	for _, line := range strings.Split(strings.TrimSpace(output.String()), "\n") {
+		var event map[string]any
+		require.NoError(t, json.Unmarshal([]byte(line), &event))
+		if event["msg"] == "downloading from mirror" {
+			found = true
+			assert.Equal(t, "file://"+filepath.ToSlash(dir), event["mirror"])
+		}
+	}
+	assert.True(t, found)
+
+	for _, mirrors := range [][]string{{}, {"ftp://example.com"}, {"file://host/dumps"}} {
+		_, errE = mediawiki.NewMirrorTransport(&mediawiki.MirrorConfig{Mirrors: mirrors, Transport: nil, Logger: nil})
+		assert.ErrorIs(t, errE, mediawiki.ErrInvalidValue)
+	}
+}
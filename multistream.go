@@ -0,0 +1,348 @@
+package mediawiki
+
+import (
+	"bufio"
+	"compress/bzip2"
+	"context"
+	"encoding/xml"
+	"io"
+	"os"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/cosnicolaou/pbzip2"
+	"github.com/hashicorp/go-retryablehttp"
+	"gitlab.com/tozd/go/errors"
+	"golang.org/x/text/unicode/norm"
+)
+
+// MultistreamConfig is a configuration for OpenMultistreamDump.
+//
+// Path is the path to a local pages-articles-multistream XML dump and
+// IndexPath is the path to its corresponding multistream-index file. Both are required.
+//
+// DecompressionThreads controls decompression of the index file and DecodingThreads
+// controls how many streams of the dump are decompressed and decoded in parallel.
+type MultistreamConfig struct {
+	Path                 string
+	IndexPath            string
+	DecompressionThreads int
+	DecodingThreads      int
+}
+
+type multistreamEntry struct {
+	Offset int64
+	ID     int64
+}
+
+// MultistreamDump provides random access to pages in a pages-articles-multistream XML dump.
+//
+// The index is loaded into memory once, so repeated lookups only read and decompress
+// the bz2 streams which contain requested pages.
+type MultistreamDump struct {
+	file            *os.File
+	size            int64
+	byID            map[int64]int64
+	byTitle         map[string]multistreamEntry
+	offsets         []int64
+	decodingThreads int
+}
+
+// OpenMultistreamDump loads the multistream index and opens the multistream dump.
+// Close should be called when the dump is not needed anymore.
+func OpenMultistreamDump(ctx context.Context, config *MultistreamConfig) (*MultistreamDump, errors.E) {
+	decompressionThreads := config.DecompressionThreads
+	if decompressionThreads == 0 {
+		decompressionThreads = runtime.GOMAXPROCS(0)
+	}
+	decodingThreads := config.DecodingThreads
+	if decodingThreads == 0 {
+		decodingThreads = runtime.GOMAXPROCS(0)
+	}
+
+	dump := &MultistreamDump{
+		file:            nil,
+		size:            0,
+		byID:            map[int64]int64{},
+		byTitle:         map[string]multistreamEntry{},
+		offsets:         nil,
+		decodingThreads: decodingThreads,
+	}
+
+	errE := dump.loadIndex(ctx, config.IndexPath, decompressionThreads)
+	if errE != nil {
+		return nil, errE
+	}
+
+	file, err := os.Open(config.Path)
+	if err != nil {
+		errE := errors.WithMessage(err, "open")
+		errors.Details(errE)["path"] = config.Path
+		return nil, errE
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		errE := errors.WithMessage(err, "stat")
+		errors.Details(errE)["path"] = config.Path
+		return nil, errE
+	}
+	dump.file = file
+	dump.size = info.Size()
+
+	return dump, nil
+}
+
+func (d *MultistreamDump) loadIndex(ctx context.Context, indexPath string, decompressionThreads int) errors.E {
+	indexFile, err := os.Open(indexPath)
+	if err != nil {
+		errE := errors.WithMessage(err, "open")
+		errors.Details(errE)["path"] = indexPath
+		return errE
+	}
+	defer indexFile.Close()
+
+	var reader io.Reader = indexFile
+	if strings.HasSuffix(indexPath, ".bz2") {
+		reader = pbzip2.NewReader(
+			ctx, indexFile,
+			pbzip2.DecompressionOptions(
+				pbzip2.BZConcurrency(decompressionThreads),
+			),
+		)
+	}
+
+	bufReader := bufio.NewReader(reader)
+	lineNumber := 0
+	for {
+		line, err := bufReader.ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			errE := errors.WithMessage(err, "read index")
+			errors.Details(errE)["path"] = indexPath
+			return errE
+		}
+		lineNumber++
+		line = strings.TrimRight(line, "\r\n")
+		if line != "" {
+			// Format is "offset:id:title". Title can contain colons.
+			parts := strings.SplitN(line, ":", 3)
+			if len(parts) != 3 { //nolint:mnd
+				errE := errors.WithMessage(ErrInvalidValue, "index line")
+				errors.Details(errE)["path"] = indexPath
+				errors.Details(errE)["line"] = lineNumber
+				errors.Details(errE)["value"] = line
+				return errE
+			}
+			offset, errOffset := strconv.ParseInt(parts[0], 10, 64)
+			id, errID := strconv.ParseInt(parts[1], 10, 64)
+			if errOffset != nil || errID != nil {
+				errE := errors.WithMessage(ErrInvalidValue, "index line")
+				errors.Details(errE)["path"] = indexPath
+				errors.Details(errE)["line"] = lineNumber
+				errors.Details(errE)["value"] = line
+				return errE
+			}
+			d.byID[id] = offset
+			d.byTitle[norm.NFC.String(parts[2])] = multistreamEntry{Offset: offset, ID: id}
+			if len(d.offsets) == 0 || d.offsets[len(d.offsets)-1] != offset {
+				d.offsets = append(d.offsets, offset)
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	// Index is sorted by offsets, but we make sure.
+	slices.Sort(d.offsets)
+	d.offsets = slices.Compact(d.offsets)
+
+	return nil
+}
+
+// Len returns the number of pages in the index.
+func (d *MultistreamDump) Len() int {
+	return len(d.byID)
+}
+
+// Close closes the multistream dump file.
+func (d *MultistreamDump) Close() error {
+	return d.file.Close()
+}
+
+// PagesByID decompresses and decodes only streams containing pages with given page IDs
+// and calls processPage on each of those pages. processPage can be called concurrently
+// and pages are not passed in any particular order.
+//
+// If any of the IDs is not in the index, ErrNotFound is returned before any page is processed.
+func (d *MultistreamDump) PagesByID(ctx context.Context, ids []int64, processPage func(context.Context, Page) errors.E) errors.E {
+	streams := map[int64]map[int64]bool{}
+	for _, id := range ids {
+		offset, ok := d.byID[id]
+		if !ok {
+			return errors.WithDetails(ErrNotFound, "id", id)
+		}
+		if streams[offset] == nil {
+			streams[offset] = map[int64]bool{}
+		}
+		streams[offset][id] = true
+	}
+	return d.pages(ctx, streams, processPage)
+}
+
+// PagesByTitle decompresses and decodes only streams containing pages with given titles
+// and calls processPage on each of those pages. Titles should include the namespace
+// prefix and use spaces and not underscores. processPage can be called concurrently
+// and pages are not passed in any particular order.
+//
+// If any of the titles is not in the index, ErrNotFound is returned before any page is processed.
+func (d *MultistreamDump) PagesByTitle(ctx context.Context, titles []string, processPage func(context.Context, Page) errors.E) errors.E {
+	streams := map[int64]map[int64]bool{}
+	for _, title := range titles {
+		entry, ok := d.byTitle[norm.NFC.String(title)]
+		if !ok {
+			return errors.WithDetails(ErrNotFound, "title", title)
+		}
+		if streams[entry.Offset] == nil {
+			streams[entry.Offset] = map[int64]bool{}
+		}
+		streams[entry.Offset][entry.ID] = true
+	}
+	return d.pages(ctx, streams, processPage)
+}
+
+// streamEnd returns the offset at which the stream starting at offset ends.
+func (d *MultistreamDump) streamEnd(offset int64) int64 {
+	i, found := slices.BinarySearch(d.offsets, offset)
+	if found {
+		i++
+	}
+	if i < len(d.offsets) {
+		return d.offsets[i]
+	}
+	return d.size
+}
+
+func (d *MultistreamDump) pages(
+	ctx context.Context, streams map[int64]map[int64]bool, processPage func(context.Context, Page) errors.E,
+) errors.E {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	offsets := make(chan int64, len(streams))
+	for offset := range streams {
+		offsets <- offset
+	}
+	close(offsets)
+
+	var wg sync.WaitGroup
+	errs := make(chan errors.E, d.decodingThreads)
+	for range min(d.decodingThreads, len(streams)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for offset := range offsets {
+				errE := d.stream(ctx, offset, streams[offset], processPage)
+				if errE != nil {
+					errs <- errE
+					cancel()
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	allErrors := []errors.E{}
+	for errE := range errs {
+		allErrors = append(allErrors, errE)
+	}
+	if len(allErrors) == 0 {
+		return nil
+	}
+
+	// If there is any non-context-canceled error, return them.
+	nonCanceledErrors := []error{}
+	for _, err := range allErrors {
+		if !errors.Is(err, context.Canceled) {
+			nonCanceledErrors = append(nonCanceledErrors, err)
+		}
+	}
+	if len(nonCanceledErrors) > 0 {
+		return errors.Join(nonCanceledErrors...)
+	}
+	return allErrors[0]
+}
+
+func (d *MultistreamDump) stream(
+	ctx context.Context, offset int64, ids map[int64]bool, processPage func(context.Context, Page) errors.E,
+) errors.E {
+	end := d.streamEnd(offset)
+	iter := newPageIterator(bzip2.NewReader(io.NewSectionReader(d.file, offset, end-offset)))
+	remaining := len(ids)
+	for remaining > 0 && iter.More() {
+		if ctx.Err() != nil {
+			return errors.WithStack(ctx.Err())
+		}
+		var data []byte
+		errE := iter.Next(&data)
+		if errE != nil {
+			if errors.Is(errE, io.EOF) {
+				break
+			}
+			errors.Details(errE)["offset"] = offset
+			return errE
+		}
+		var page Page
+		err := xml.Unmarshal(data, &page)
+		if err != nil {
+			errE := errors.Prefix(err, ErrXMLDecode)
+			errors.Details(errE)["offset"] = offset
+			return errE
+		}
+		if !ids[page.ID] {
+			continue
+		}
+		remaining--
+		errE = processPage(ctx, page)
+		if errE != nil {
+			return errE
+		}
+	}
+	if remaining > 0 {
+		errE := errors.WithMessage(ErrNotFound, "pages in stream")
+		errors.Details(errE)["offset"] = offset
+		errors.Details(errE)["missing"] = remaining
+		return errE
+	}
+	return nil
+}
+
+// WikipediaPagesArticlesMultistreamDump returns Wikipedia pages-articles-multistream XML dump.
+// Use "enwiki" for English Wikipedia.
+func WikipediaPagesArticlesMultistreamDump(language string) Dump {
+	return xmlDump(Wiki(language), PagesArticlesMultistreamKind, XMLBZIP2Format)
+}
+
+// MultistreamIndexURL returns URL of the index of pages-articles-multistream XML dump at dumpURL.
+// The index is published in the same run as the dump.
+func MultistreamIndexURL(dumpURL string) string {
+	return strings.TrimSuffix(dumpURL, ".xml.bz2") + "-index.txt.bz2"
+}
+
+// LatestWikipediaPagesArticlesMultistreamRun returns URLs of the latest completed run of Wikipedia
+// pages-articles-multistream XML dump and its index.
+// Use "enwiki" for English Wikipedia.
+func LatestWikipediaPagesArticlesMultistreamRun(
+	ctx context.Context, client *retryablehttp.Client, language string,
+) (string, string, errors.E) {
+	dumpURL, errE := WikipediaPagesArticlesMultistreamDump(language).latestURL(ctx, client)
+	if errE != nil {
+		return "", "", errE
+	}
+	return dumpURL, MultistreamIndexURL(dumpURL), nil
+}
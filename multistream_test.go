@@ -0,0 +1,52 @@
+package mediawiki_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/go/errors"
+
+	"github.com/citadel2024/go-mediawiki"
+)
+
+func TestMultistreamDump(t *testing.T) {
+	t.Parallel()
+
+	dump, errE := mediawiki.OpenMultistreamDump(context.Background(), &mediawiki.MultistreamConfig{
+		Path:      "testdata/enwiki-testdata-pages-articles-multistream.xml.bz2",
+		IndexPath: "testdata/enwiki-testdata-pages-articles-multistream-index.txt.bz2",
+	})
+	require.NoError(t, errE, "% -+#.1v", errE)
+	defer dump.Close()
+
+	assert.Equal(t, 10, dump.Len())
+
+	var mu sync.Mutex
+	pages := map[int64]mediawiki.Page{}
+	processPage := func(_ context.Context, p mediawiki.Page) errors.E {
+		mu.Lock()
+		defer mu.Unlock()
+		pages[p.ID] = p
+		return nil
+	}
+
+	errE = dump.PagesByID(context.Background(), []int64{5, 10, 4}, processPage)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Len(t, pages, 3)
+	assert.Equal(t, "Page 10", pages[10].Title)
+	assert.Equal(t, "Text of page 5.", pages[5].Revision.Text)
+
+	clear(pages)
+
+	errE = dump.PagesByTitle(context.Background(), []string{"Talk:Page: 7", "Page 1"}, processPage)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Len(t, pages, 2)
+	assert.Equal(t, 1, pages[7].Namespace)
+	assert.Equal(t, int64(1001), pages[1].Revision.ID)
+
+	errE = dump.PagesByID(context.Background(), []int64{11}, processPage)
+	assert.ErrorIs(t, errE, mediawiki.ErrNotFound)
+}
@@ -0,0 +1,66 @@
+package mediawiki
+
+import (
+	"context"
+	"time"
+)
+
+// queueSampleInterval is how often Process reports lengths of its internal queues to the observer.
+const queueSampleInterval = time.Second
+
+// Observer observes the processing pipeline of Process.
+//
+// Process reads rows from the dump, decodes items from rows, and calls Process
+// (or ProcessBatch) callback on decoded items. Rows and items are passed between
+// these stages through queues named "rows" and "items".
+//
+// Methods are called concurrently from multiple goroutines and should return quickly.
+// See Metrics for an implementation which collects metrics.
+type Observer interface {
+	// RowRead is called for every row read from the dump and passed on to be decoded.
+	RowRead(ctx context.Context)
+	// ItemDecoded is called for every item successfully decoded.
+	ItemDecoded(ctx context.Context)
+	// ItemsProcessed is called after every call of the Process (or ProcessBatch) callback
+	// with the number of items passed to the callback and how long the call took.
+	ItemsProcessed(ctx context.Context, items int, duration time.Duration)
+	// ItemFailed is called for every item which failed to decode or process.
+	ItemFailed(ctx context.Context, stage ErrorStage)
+	// QueueLength is called periodically with the number of elements in the queue and its capacity.
+	QueueLength(ctx context.Context, queue string, length, capacity int)
+}
+
+var _ Observer = nopObserver{}
+
+// nopObserver is an Observer which does nothing.
+type nopObserver struct{}
+
+func (nopObserver) RowRead(context.Context)                            {}
+func (nopObserver) ItemDecoded(context.Context)                        {}
+func (nopObserver) ItemsProcessed(context.Context, int, time.Duration) {}
+func (nopObserver) ItemFailed(context.Context, ErrorStage)             {}
+func (nopObserver) QueueLength(context.Context, string, int, int)      {}
+
+// observerOrNop returns observer or nopObserver if observer is nil.
+func observerOrNop(observer Observer) Observer { //nolint:ireturn
+	if observer == nil {
+		return nopObserver{}
+	}
+	return observer
+}
+
+// observeQueues reports lengths of rows and items queues to the observer until the context is canceled.
+func observeQueues[T any](ctx context.Context, observer Observer, rows chan []byte, items chan OutputData[T]) {
+	ticker := time.NewTicker(queueSampleInterval)
+	defer ticker.Stop()
+
+	for {
+		observer.QueueLength(ctx, "rows", len(rows), cap(rows))
+		observer.QueueLength(ctx, "items", len(items), cap(items))
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
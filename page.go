@@ -0,0 +1,220 @@
+package mediawiki
+
+import (
+	"encoding/xml"
+	"time"
+
+	"gitlab.com/tozd/go/errors"
+	"golang.org/x/text/unicode/norm"
+)
+
+const deletedAttr = "deleted"
+
+// Contributor is a contributor of a page revision in a MediaWiki XML dump.
+//
+// Registered users have Username and ID set, anonymous users have IP set.
+// If the contributor has been hidden, Deleted is true and other fields are empty.
+type Contributor struct {
+	Username string `json:"username,omitempty"`
+	ID       int64  `json:"id,omitempty"`
+	IP       string `json:"ip,omitempty"`
+	Deleted  bool   `json:"deleted,omitempty"`
+}
+
+// Revision is a page revision in a MediaWiki XML dump.
+//
+// In stub dumps Text is not available, but TextID and TextBytes are.
+type Revision struct {
+	ID             int64       `json:"id"`
+	ParentID       int64       `json:"parentid,omitempty"`
+	Timestamp      time.Time   `json:"timestamp"`
+	Contributor    Contributor `json:"contributor"`
+	Minor          bool        `json:"minor,omitempty"`
+	Comment        string      `json:"comment,omitempty"`
+	CommentDeleted bool        `json:"comment_deleted,omitempty"`
+	Origin         int64       `json:"origin,omitempty"`
+	Model          string      `json:"model"`
+	Format         string      `json:"format"`
+	Text           string      `json:"text,omitempty"`
+	TextID         int64       `json:"text_id,omitempty"`
+	TextBytes      int64       `json:"text_bytes,omitempty"`
+	TextDeleted    bool        `json:"text_deleted,omitempty"`
+	SHA1           string      `json:"sha1,omitempty"`
+}
+
+// Page is a page in a MediaWiki XML dump (e.g., pages-articles dump).
+//
+// Redirect is the title of the redirect target if the page is a redirect.
+type Page struct {
+	Title        string   `json:"title"`
+	Namespace    int      `json:"ns"`
+	ID           int64    `json:"id"`
+	Redirect     string   `json:"redirect,omitempty"`
+	Restrictions string   `json:"restrictions,omitempty"`
+	Revision     Revision `json:"revision"`
+}
+
+// LogItem is a log entry in a MediaWiki pages-logging XML dump.
+//
+// Type and Action are the type of the logged action (e.g., "delete") and the action itself
+// (e.g., "delete" or "restore"). Title is the full title (with namespace prefix) of the page
+// the action was performed on. If the comment or the title has been hidden, CommentDeleted
+// or TitleDeleted is true and the field is empty.
+type LogItem struct {
+	ID             int64       `json:"id"`
+	Timestamp      time.Time   `json:"timestamp"`
+	Contributor    Contributor `json:"contributor"`
+	Comment        string      `json:"comment,omitempty"`
+	CommentDeleted bool        `json:"comment_deleted,omitempty"`
+	Type           string      `json:"type"`
+	Action         string      `json:"action"`
+	Title          string      `json:"title,omitempty"`
+	TitleDeleted   bool        `json:"title_deleted,omitempty"`
+	Params         string      `json:"params,omitempty"`
+}
+
+type xmlDeletable struct {
+	Deleted string `xml:"deleted,attr"`
+	Value   string `xml:",chardata"`
+}
+
+type xmlContributor struct {
+	Deleted  string `xml:"deleted,attr"`
+	Username string `xml:"username"`
+	ID       int64  `xml:"id"`
+	IP       string `xml:"ip"`
+}
+
+type xmlText struct {
+	Deleted string `xml:"deleted,attr"`
+	Bytes   int64  `xml:"bytes,attr"`
+	ID      int64  `xml:"id,attr"`
+	Value   string `xml:",chardata"`
+}
+
+type xmlRevision struct {
+	ID          int64          `xml:"id"`
+	ParentID    int64          `xml:"parentid"`
+	Timestamp   time.Time      `xml:"timestamp"`
+	Contributor xmlContributor `xml:"contributor"`
+	Minor       *struct{}      `xml:"minor"`
+	Comment     xmlDeletable   `xml:"comment"`
+	Origin      int64          `xml:"origin"`
+	Model       string         `xml:"model"`
+	Format      string         `xml:"format"`
+	Text        xmlText        `xml:"text"`
+	SHA1        string         `xml:"sha1"`
+}
+
+func (r *xmlRevision) toRevision() Revision {
+	return Revision{
+		ID:        r.ID,
+		ParentID:  r.ParentID,
+		Timestamp: r.Timestamp,
+		Contributor: Contributor{
+			Username: norm.NFC.String(r.Contributor.Username),
+			ID:       r.Contributor.ID,
+			IP:       r.Contributor.IP,
+			Deleted:  r.Contributor.Deleted == deletedAttr,
+		},
+		Minor:          r.Minor != nil,
+		Comment:        norm.NFC.String(r.Comment.Value),
+		CommentDeleted: r.Comment.Deleted == deletedAttr,
+		Origin:         r.Origin,
+		Model:          r.Model,
+		Format:         r.Format,
+		Text:           norm.NFC.String(r.Text.Value),
+		TextID:         r.Text.ID,
+		TextBytes:      r.Text.Bytes,
+		TextDeleted:    r.Text.Deleted == deletedAttr,
+		SHA1:           r.SHA1,
+	}
+}
+
+type xmlRedirect struct {
+	Title string `xml:"title,attr"`
+}
+
+type xmlPage struct {
+	Title        string       `xml:"title"`
+	Namespace    int          `xml:"ns"`
+	ID           int64        `xml:"id"`
+	Redirect     *xmlRedirect `xml:"redirect"`
+	Restrictions string       `xml:"restrictions"`
+}
+
+func (p *xmlPage) toPage() Page {
+	page := Page{
+		Title:        norm.NFC.String(p.Title),
+		Namespace:    p.Namespace,
+		ID:           p.ID,
+		Restrictions: p.Restrictions,
+	}
+	if p.Redirect != nil {
+		page.Redirect = norm.NFC.String(p.Redirect.Title)
+	}
+	return page
+}
+
+type xmlLogItem struct {
+	ID          int64          `xml:"id"`
+	Timestamp   time.Time      `xml:"timestamp"`
+	Contributor xmlContributor `xml:"contributor"`
+	Comment     xmlDeletable   `xml:"comment"`
+	Type        string         `xml:"type"`
+	Action      string         `xml:"action"`
+	LogTitle    xmlDeletable   `xml:"logtitle"`
+	Params      string         `xml:"params"`
+}
+
+// UnmarshalXML implements xml.Unmarshaler interface for LogItem.
+func (l *LogItem) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	var e xmlLogItem
+	err := d.DecodeElement(&e, &start)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	*l = LogItem{
+		ID:        e.ID,
+		Timestamp: e.Timestamp,
+		Contributor: Contributor{
+			Username: norm.NFC.String(e.Contributor.Username),
+			ID:       e.Contributor.ID,
+			IP:       e.Contributor.IP,
+			Deleted:  e.Contributor.Deleted == deletedAttr,
+		},
+		Comment:        norm.NFC.String(e.Comment.Value),
+		CommentDeleted: e.Comment.Deleted == deletedAttr,
+		Type:           e.Type,
+		Action:         e.Action,
+		Title:          norm.NFC.String(e.LogTitle.Value),
+		TitleDeleted:   e.LogTitle.Deleted == deletedAttr,
+		Params:         e.Params,
+	}
+	return nil
+}
+
+// UnmarshalXML implements xml.Unmarshaler interface for Page.
+//
+// It decodes a page element with at most one revision.
+func (p *Page) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	var e struct {
+		xmlPage
+		Revisions []xmlRevision `xml:"revision"`
+	}
+	err := d.DecodeElement(&e, &start)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if len(e.Revisions) > 1 {
+		errE := errors.WithMessage(ErrUnexpectedType, "multiple revisions")
+		errors.Details(errE)["page"] = e.ID
+		errors.Details(errE)["revisions"] = len(e.Revisions)
+		return errE
+	}
+	*p = e.toPage()
+	if len(e.Revisions) == 1 {
+		p.Revision = e.Revisions[0].toRevision()
+	}
+	return nil
+}
@@ -0,0 +1,221 @@
+package mediawiki_test
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/go/errors"
+
+	"github.com/citadel2024/go-mediawiki"
+)
+
+const testPagesArticles = `<mediawiki xmlns="http://www.mediawiki.org/xml/export-0.11/" version="0.11" xml:lang="en">
+  <siteinfo>
+    <sitename>Wikipedia</sitename>
+    <namespaces>
+      <namespace key="0" case="first-letter" />
+    </namespaces>
+  </siteinfo>
+  <page>
+    <title>AccessibleComputing</title>
+    <ns>0</ns>
+    <id>10</id>
+    <redirect title="Computer accessibility" />
+    <revision>
+      <id>1219062925</id>
+      <parentid>1219062840</parentid>
+      <timestamp>2024-04-15T14:38:04Z</timestamp>
+      <contributor>
+        <username>Asparagusus</username>
+        <id>43603280</id>
+      </contributor>
+      <minor />
+      <comment>Restored revision</comment>
+      <origin>1219062925</origin>
+      <model>wikitext</model>
+      <format>text/x-wiki</format>
+      <text bytes="111" sha1="kmysdltgexdwkv2xsml3j44jb56dxvn" xml:space="preserve">#REDIRECT [[Computer accessibility]]
+{{Rcat shell|
+{{R from move}}
+}}</text>
+      <sha1>kmysdltgexdwkv2xsml3j44jb56dxvn</sha1>
+    </revision>
+  </page>
+  <page>
+    <title>Anarchism</title>
+    <ns>0</ns>
+    <id>12</id>
+    <revision>
+      <id>1234</id>
+      <timestamp>2024-04-16T10:00:00Z</timestamp>
+      <contributor>
+        <ip>192.0.2.1</ip>
+      </contributor>
+      <comment deleted="deleted" />
+      <model>wikitext</model>
+      <format>text/x-wiki</format>
+      <text bytes="36" xml:space="preserve">'''Anarchism''' is &lt;b&gt;a&lt;/b&gt; theory.</text>
+      <sha1>abc</sha1>
+    </revision>
+  </page>
+</mediawiki>
+`
+
+func TestProcessXML(t *testing.T) {
+	t.Parallel()
+
+	tempDir := t.TempDir()
+
+	xmlPath := filepath.Join(tempDir, "pages-articles.xml")
+	err := os.WriteFile(xmlPath, []byte(testPagesArticles), 0o600)
+	require.NoError(t, err)
+
+	gzipPath := filepath.Join(tempDir, "pages-articles.xml.gz")
+	f, err := os.Create(gzipPath)
+	require.NoError(t, err)
+	w := gzip.NewWriter(f)
+	_, err = w.Write([]byte(testPagesArticles))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	require.NoError(t, f.Close())
+
+	for _, test := range []struct {
+		path        string
+		compression mediawiki.Compression
+		fileType    mediawiki.FileType
+	}{
+		{xmlPath, mediawiki.NoCompression, mediawiki.XML},
+		{gzipPath, mediawiki.GZIP, mediawiki.XML},
+		{xmlPath, mediawiki.NoCompression, mediawiki.XMLRevision},
+		{gzipPath, mediawiki.GZIP, mediawiki.XMLRevision},
+	} {
+		t.Run(fmt.Sprintf("%s/%s", filepath.Base(test.path), test.fileType), func(t *testing.T) {
+			t.Parallel()
+
+			var mu sync.Mutex
+			pages := map[int64]mediawiki.Page{}
+
+			errE := mediawiki.Process(context.Background(), &mediawiki.ProcessConfig[mediawiki.Page]{
+				Path: test.path,
+				Process: func(_ context.Context, p mediawiki.Page) errors.E {
+					mu.Lock()
+					defer mu.Unlock()
+					pages[p.ID] = p
+					return nil
+				},
+				FileType:    test.fileType,
+				Compression: test.compression,
+				CheckpointConfig: &mediawiki.CheckpointConfig{
+					SaveInterval:   time.Minute,
+					ItemsThreshold: 1000,
+					CheckpointFile: fmt.Sprintf("%s.%s.checkpoint.json", test.path, test.fileType),
+				},
+			})
+			require.NoError(t, errE, "% -+#.1v", errE)
+			require.Len(t, pages, 2)
+
+			p := pages[10]
+			assert.Equal(t, "AccessibleComputing", p.Title)
+			assert.Equal(t, 0, p.Namespace)
+			assert.Equal(t, "Computer accessibility", p.Redirect)
+			assert.Equal(t, int64(1219062925), p.Revision.ID)
+			assert.Equal(t, int64(1219062840), p.Revision.ParentID)
+			assert.Equal(t, time.Date(2024, 4, 15, 14, 38, 4, 0, time.UTC), p.Revision.Timestamp)
+			assert.Equal(t, "Asparagusus", p.Revision.Contributor.Username)
+			assert.Equal(t, int64(43603280), p.Revision.Contributor.ID)
+			assert.True(t, p.Revision.Minor)
+			assert.Equal(t, "wikitext", p.Revision.Model)
+			assert.Equal(t, "text/x-wiki", p.Revision.Format)
+			assert.Equal(t, int64(111), p.Revision.TextBytes)
+			assert.Equal(t, "#REDIRECT [[Computer accessibility]]\n{{Rcat shell|\n{{R from move}}\n}}", p.Revision.Text)
+			assert.Equal(t, "kmysdltgexdwkv2xsml3j44jb56dxvn", p.Revision.SHA1)
+
+			p = pages[12]
+			assert.Equal(t, "", p.Redirect)
+			assert.False(t, p.Revision.Minor)
+			assert.Equal(t, "192.0.2.1", p.Revision.Contributor.IP)
+			assert.True(t, p.Revision.CommentDeleted)
+			assert.Equal(t, "'''Anarchism''' is <b>a</b> theory.", p.Revision.Text)
+		})
+	}
+}
+
+func TestProcessWikipediaHistoryDump(t *testing.T) {
+	t.Parallel()
+
+	var b strings.Builder
+	b.WriteString("<mediawiki>\n")
+	for pageID := 1; pageID <= 5; pageID++ {
+		fmt.Fprintf(&b, "  <page>\n    <title>Page %d</title>\n    <ns>0</ns>\n    <id>%d</id>\n", pageID, pageID)
+		for revision := 1; revision <= 20; revision++ {
+			fmt.Fprintf(&b, "    <revision>\n      <id>%d</id>\n", pageID*100+revision)
+			if revision > 1 {
+				fmt.Fprintf(&b, "      <parentid>%d</parentid>\n", pageID*100+revision-1)
+			}
+			fmt.Fprintf(&b, "      <timestamp>2024-01-01T00:00:%02dZ</timestamp>\n", revision)
+			b.WriteString("      <contributor>\n        <username>User</username>\n        <id>1</id>\n      </contributor>\n")
+			b.WriteString("      <model>wikitext</model>\n      <format>text/x-wiki</format>\n")
+			fmt.Fprintf(&b, "      <text bytes=\"10\" id=\"%d\" />\n      <sha1>x</sha1>\n    </revision>\n", pageID*100+revision)
+		}
+		b.WriteString("  </page>\n")
+	}
+	b.WriteString("</mediawiki>\n")
+
+	path := filepath.Join(t.TempDir(), "stub-meta-history.xml")
+	err := os.WriteFile(path, []byte(b.String()), 0o600)
+	require.NoError(t, err)
+
+	var mu sync.Mutex
+	revisions := map[int64][]int64{}
+	// otherPage is closed when the first revision of a page other than the first page is processed.
+	otherPage := make(chan struct{})
+	var otherPageOnce sync.Once
+
+	errE := mediawiki.ProcessWikipediaHistoryDump(
+		context.Background(),
+		&mediawiki.ProcessDumpConfig{
+			Path:                   path,
+			ItemsProcessingThreads: 4,
+			CheckpointConfig:       testCheckpointConfig(),
+		},
+		func(_ context.Context, p mediawiki.Page) errors.E {
+			if p.Revision.ID == 101 {
+				// Revisions of other pages are processed while the first page is still being processed.
+				select {
+				case <-otherPage:
+				case <-time.After(10 * time.Second):
+					assert.Fail(t, "revisions of other pages are not processed in parallel")
+				}
+			} else if p.ID != 1 {
+				otherPageOnce.Do(func() { close(otherPage) })
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if len(revisions[p.ID]) > 0 {
+				assert.Equal(t, revisions[p.ID][len(revisions[p.ID])-1], p.Revision.ParentID)
+			}
+			revisions[p.ID] = append(revisions[p.ID], p.Revision.ID)
+			assert.Equal(t, fmt.Sprintf("Page %d", p.ID), p.Title)
+			assert.Equal(t, p.Revision.ID, p.Revision.TextID)
+			return nil
+		},
+	)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	// Revisions of each page are processed in the order they are in the dump.
+	require.Len(t, revisions, 5)
+	for pageID, ids := range revisions {
+		require.Len(t, ids, 20)
+		for i, id := range ids {
+			assert.Equal(t, pageID*100+int64(i)+1, id)
+		}
+	}
+}
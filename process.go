@@ -6,34 +6,44 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"encoding/xml"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"os"
+	"path"
 	"runtime"
 	"strings"
 	"sync"
 	"time"
 	"unicode/utf8"
 
-	"github.com/cosnicolaou/pbzip2"
 	"github.com/hashicorp/go-retryablehttp"
-	gzip "github.com/klauspost/pgzip"
+	"github.com/klauspost/compress/zstd"
 	"github.com/pingcap/tidb/pkg/parser"
 	"github.com/pingcap/tidb/pkg/parser/ast"
 	"github.com/pingcap/tidb/pkg/parser/test_driver"
+	"github.com/ulikunitz/xz"
 	"gitlab.com/tozd/go/errors"
 	"gitlab.com/tozd/go/x"
 	"golang.org/x/text/unicode/norm"
 )
 
 const (
-	progressPrintRate = 30 * time.Second
+	progressPrintRate    = 30 * time.Second
+	defaultReorderWindow = 64 << 20
+	defaultBatchSize     = 1000
+	// partitionQueueSize is the number of items queued for each goroutine calling
+	// the callback when items are partitioned.
+	partitionQueueSize = 1000
 )
 
 type iterator interface {
 	More() bool
 	Next(b *[]byte) errors.E
+	// Offset returns the number of bytes consumed from the reader up to the end of the last row.
+	Offset() int64
 }
 
 type jsonIterator json.Decoder
@@ -50,13 +60,42 @@ func (i *jsonIterator) Next(b *[]byte) errors.E {
 	return nil
 }
 
+func (i *jsonIterator) Offset() int64 {
+	return (*json.Decoder)(i).InputOffset()
+}
+
 func newJSONIterator(r io.Reader) iterator { //nolint:ireturn
 	return (*jsonIterator)(json.NewDecoder(r))
 }
 
+// skipJSONArraySeparator consumes whitespace and the comma which follow a value in a JSON array.
+// It returns the number of consumed bytes.
+func skipJSONArraySeparator(reader *bufio.Reader) (int64, errors.E) {
+	consumed := int64(0)
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return consumed, nil
+			}
+			return consumed, errors.WithMessage(err, "read byte")
+		}
+		switch b {
+		case ' ', '\t', '\r', '\n':
+			consumed++
+		case ',':
+			return consumed + 1, nil
+		default:
+			_ = reader.UnreadByte()
+			return consumed, nil
+		}
+	}
+}
+
 type statementIterator struct {
 	reader *bufio.Reader
 	buffer *bytes.Buffer
+	offset int64
 }
 
 func (i *statementIterator) More() bool {
@@ -69,6 +108,7 @@ func (i *statementIterator) More() bool {
 
 func (i *statementIterator) Next(b *[]byte) errors.E {
 	line, err := i.reader.ReadBytes('\n')
+	i.offset += int64(len(line))
 	if err != nil {
 		if errors.Is(err, io.EOF) && i.buffer.Len() > 0 {
 			*b = i.buffer.Bytes()
@@ -89,10 +129,243 @@ func (i *statementIterator) Next(b *[]byte) errors.E {
 	return nil
 }
 
+func (i *statementIterator) Offset() int64 {
+	return i.offset
+}
+
 func newStatementIterator(r io.Reader) *statementIterator {
 	return &statementIterator{
 		reader: bufio.NewReader(r),
 		buffer: new(bytes.Buffer),
+		offset: 0,
+	}
+}
+
+// pageIterator extracts page elements (and logitem elements of pages-logging dumps)
+// from a MediaWiki XML dump. It depends on start and end tags being on their own lines,
+// which is how MediaWiki formats its XML dumps. Everything outside
+// of these elements (e.g., siteinfo) is skipped.
+type pageIterator struct {
+	reader *bufio.Reader
+	eof    bool
+	offset int64
+}
+
+// xmlItemTags maps start tags of elements extracted by pageIterator to their end tags.
+//
+//nolint:gochecknoglobals
+var xmlItemTags = map[string][]byte{
+	"<page>":    []byte("</page>"),
+	"<logitem>": []byte("</logitem>"),
+}
+
+func (i *pageIterator) More() bool {
+	return !i.eof
+}
+
+func (i *pageIterator) Next(b *[]byte) errors.E {
+	var buffer *bytes.Buffer
+	var end []byte
+	for {
+		line, err := i.reader.ReadBytes('\n')
+		i.offset += int64(len(line))
+		if err != nil && !errors.Is(err, io.EOF) {
+			return errors.WithMessage(err, "read bytes")
+		}
+		trimmed := bytes.TrimSpace(line)
+		if buffer == nil {
+			if e, ok := xmlItemTags[string(trimmed)]; ok {
+				end = e
+				buffer = new(bytes.Buffer)
+				buffer.Write(trimmed)
+				buffer.WriteByte('\n')
+			}
+		} else {
+			buffer.Write(line)
+			if bytes.Equal(trimmed, end) {
+				*b = buffer.Bytes()
+				return nil
+			}
+		}
+		if err != nil {
+			i.eof = true
+			if buffer != nil {
+				return errors.WithMessage(io.ErrUnexpectedEOF, "page")
+			}
+			return errors.WithStack(err)
+		}
+	}
+}
+
+func (i *pageIterator) Offset() int64 {
+	return i.offset
+}
+
+func newPageIterator(r io.Reader) *pageIterator {
+	return &pageIterator{
+		reader: bufio.NewReader(r),
+		eof:    false,
+		offset: 0,
+	}
+}
+
+// revisionIterator extracts revision elements from a MediaWiki XML dump by streaming
+// tokens with xml.Decoder. Each row is a page element with only one revision element,
+// so a page with a long history is never held in memory at once. Everything outside
+// of page elements (e.g., siteinfo) is skipped. Elements are re-encoded without
+// attributes with a namespace prefix (e.g., xml:space).
+type revisionIterator struct {
+	decoder *xml.Decoder
+	eof     bool
+	// page is the encoded current page element up to its next revision element,
+	// or nil outside of page elements.
+	page      *bytes.Buffer
+	pageDepth int
+	depth     int
+}
+
+func (i *revisionIterator) More() bool {
+	return !i.eof
+}
+
+func (i *revisionIterator) token() (xml.Token, errors.E) {
+	token, err := i.decoder.RawToken()
+	if err != nil {
+		i.eof = true
+		if errors.Is(err, io.EOF) {
+			if i.page != nil {
+				return nil, errors.WithMessage(io.ErrUnexpectedEOF, "page")
+			}
+			return nil, errors.WithStack(err)
+		}
+		return nil, errors.WithMessage(err, "xml token")
+	}
+	switch t := token.(type) {
+	case xml.StartElement:
+		i.depth++
+		attrs := make([]xml.Attr, 0, len(t.Attr))
+		for _, attr := range t.Attr {
+			if attr.Name.Space == "" {
+				attrs = append(attrs, attr)
+			}
+		}
+		t.Attr = attrs
+		return t, nil
+	case xml.EndElement:
+		i.depth--
+		return t, nil
+	case xml.CharData:
+		// RawToken's data is valid only until the next call.
+		return t.Copy(), nil
+	}
+	// Comments, processing instructions, and directives are skipped.
+	return nil, nil //nolint:nilnil
+}
+
+func (i *revisionIterator) Next(b *[]byte) errors.E {
+	for {
+		token, errE := i.token()
+		if errE != nil {
+			return errE
+		}
+		if token == nil {
+			continue
+		}
+		if i.page == nil {
+			if t, ok := token.(xml.StartElement); ok && t.Name.Local == "page" {
+				i.page = new(bytes.Buffer)
+				i.pageDepth = i.depth
+				errE = encodeXMLTokens(i.page, token)
+				if errE != nil {
+					return errE
+				}
+			}
+			continue
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			if i.depth == i.pageDepth+1 && t.Name.Local == "revision" {
+				return i.revision(b, t)
+			}
+		case xml.EndElement:
+			if i.depth < i.pageDepth {
+				// End of the page. Elements after revisions are not needed anymore.
+				i.page = nil
+				continue
+			}
+		}
+		errE = encodeXMLTokens(i.page, token)
+		if errE != nil {
+			return errE
+		}
+	}
+}
+
+// revision reads the rest of the revision element which starts with start
+// and stores it into b, together with the current page.
+func (i *revisionIterator) revision(b *[]byte, start xml.StartElement) errors.E {
+	row := bytes.NewBuffer(bytes.Clone(i.page.Bytes()))
+	errE := encodeXMLTokens(row, start)
+	if errE != nil {
+		return errE
+	}
+	for i.depth > i.pageDepth {
+		token, errE := i.token()
+		if errE != nil {
+			return errE
+		}
+		errE = encodeXMLTokens(row, token)
+		if errE != nil {
+			return errE
+		}
+	}
+	row.WriteString("</page>")
+	*b = row.Bytes()
+	return nil
+}
+
+// Offset returns -1 because reading cannot be resumed in the middle of a page.
+func (i *revisionIterator) Offset() int64 {
+	return -1
+}
+
+// encodeXMLTokens encodes tokens into buffer. Tokens do not have to be balanced.
+func encodeXMLTokens(buffer *bytes.Buffer, tokens ...xml.Token) errors.E {
+	for _, token := range tokens {
+		var err error
+		switch t := token.(type) {
+		case xml.StartElement:
+			buffer.WriteByte('<')
+			buffer.WriteString(t.Name.Local)
+			for _, attr := range t.Attr {
+				buffer.WriteByte(' ')
+				buffer.WriteString(attr.Name.Local)
+				buffer.WriteString(`="`)
+				err = xml.EscapeText(buffer, []byte(attr.Value))
+				buffer.WriteByte('"')
+			}
+			buffer.WriteByte('>')
+		case xml.EndElement:
+			buffer.WriteString("</")
+			buffer.WriteString(t.Name.Local)
+			buffer.WriteByte('>')
+		case xml.CharData:
+			err = xml.EscapeText(buffer, t)
+		}
+		if err != nil {
+			return errors.WithMessage(err, "escape xml")
+		}
+	}
+	return nil
+}
+
+func newRevisionIterator(r io.Reader) *revisionIterator {
+	return &revisionIterator{
+		decoder:   xml.NewDecoder(r),
+		eof:       false,
+		page:      nil,
+		pageDepth: 0,
+		depth:     0,
 	}
 }
 
@@ -102,6 +375,15 @@ const (
 	JSONArray FileType = iota
 	NDJSON
 	SQLDump
+	// XML is a MediaWiki XML export dump where each page element (or logitem element
+	// in pages-logging dumps) is one item.
+	XML
+	// XMLRevision is a MediaWiki XML export dump where each revision element is one item,
+	// decoded as a page element with only that revision element. Use it for history dumps.
+	XMLRevision
+	// AutoFileType detects the file type from the first non-whitespace byte of
+	// the decompressed data and falls back to the file extension.
+	AutoFileType
 )
 
 type Compression int
@@ -113,18 +395,125 @@ const (
 	BZIP2Tar
 	GZIP
 	GZIPTar
+	ZSTD
+	ZSTDTar
+	XZ
+	XZTar
+	// AutoCompression detects the compression from magic bytes and falls
+	// back to the file extension.
+	AutoCompression
 )
 
+// isTar returns true if the compression is a tar archive, possibly compressed.
+func (c Compression) isTar() bool {
+	switch c {
+	case Tar, BZIP2Tar, GZIPTar, ZSTDTar, XZTar:
+		return true
+	case NoCompression, BZIP2, GZIP, ZSTD, XZ, AutoCompression:
+		return false
+	}
+	return false
+}
+
 // ProcessConfig is a configuration for low-level Process function.
 //
-// URL or Path, Process, FileType, and Compression are required.
+// URL or Path, Process or ProcessBatch, FileType, and Compression are required.
 // If URL is provided and Path does not already exist, Client is required, too.
 //
+// ProcessBatch can be provided instead of Process to process items in batches of
+// at most BatchSize items (by default 1000). If BatchLinger is set, a batch which
+// is not yet full is passed to ProcessBatch at latest BatchLinger after its first item
+// has been added. Checkpoint progress is updated only after ProcessBatch returns
+// successfully for the whole batch. ProcessBatch can be called concurrently
+// (controlled by ItemsProcessingThreads) and should not retain the batch slice.
+//
+// By default, any error decoding or processing an item stops processing (AbortOnError).
+// With SkipOnError error policy, items which fail to decode or for which Process (or ProcessBatch)
+// returns an error are skipped. With DeadLetterOnError error policy, they are also written
+// as DeadLetter records (NDJSON) to DeadLetter writer and can be later replayed with
+// ReplayDeadLetters. OnError is called for every skipped item (e.g., to count them).
+// Errors related to reading the file itself always stop processing.
+//
+// FileType can be AutoFileType and Compression can be AutoCompression to detect them
+// from the data itself (falling back to the file extension of Path or URL).
+// Explicitly set FileType and Compression are checked against the data as well
+// and ErrFormatMismatch is returned if they do not match.
+//
+// If Ordered is true, Process callback (or ProcessBatch) is called on items in the order they are in
+// the file (for SQL dumps, in the order of rows in INSERT statements). Decoding is still
+// done in parallel, but the callback is called from only one goroutine (ItemsProcessingThreads
+// is ignored), unless Partition is set. At most ReorderWindow bytes of rows (by default 64 MiB) are read and decoded ahead
+// of the oldest row not yet passed to the callback, so the memory used by buffered decoded items
+// is bounded by the size of rows and not their number (one SQL INSERT statement can contain
+// thousands of items). When the window is full, reading the file waits. A row larger than
+// ReorderWindow is read only once all previous rows have been passed to the callback.
+//
+// If Ordered is true and Partition is set, the callback is called from ItemsProcessingThreads
+// goroutines and items are assigned to them by the value Partition returns for them. Items
+// with the same value are passed to the callback in the order they are in the file and one
+// after the other, while items with different values can be processed in parallel.
+// Items are assigned in the order they are in the file, so when items queued for one goroutine
+// reach 1000, assigning waits for it. Partition is not used if Ordered is false.
+//
+// If Shards is larger than 1, only rows of shard Shard (from 0 to Shards-1) are decoded
+// and processed. Rows are assigned to shards round-robin, so processing the same file with
+// all shards (e.g., on different machines) processes every item exactly once. For SQL dumps,
+// INSERT statements are sharded. All tar archive members are sharded together.
+// Rows are assigned to shards only after decompression and not by compressed units (e.g.,
+// bzip2 streams of a multistream dump), so sharding does not divide downloading nor
+// decompression: every shard downloads (unless the file at Path already exists), reads,
+// and decompresses the whole file. Only decoding and processing (usually the slowest part)
+// is divided between shards. Each shard has its own checkpoint
+// file (e.g., "checkpoint.shard-2-of-8.json" for shard 2 of 8 and the default checkpoint file).
+// If CheckpointConfig.Store is set, it is used as-is, so it has to be different for each shard.
+//
+// Progress is stored in a checkpoint (see CheckpointConfig) and Process resumes after the
+// last row up to which all rows have been processed. If Compression is NoCompression, GZIP,
+// BZIP2 (or their tar variants), and FileType is JSONArray, NDJSON, or XML (both set explicitly),
+// the checkpoint stores the offset after that row and where in the file reading can restart
+// to get to it (see Restart): the offset itself for files without compression, the start of
+// the gzip member or of the bzip2 block in which the row is, and the header of the tar archive
+// member. Reading then restarts there (by seeking the file at Path or by using a Range
+// request for URL), data up to the row is decompressed but not decoded, and rows before it
+// are not read at all. A gzip file which is not made of multiple gzip members can restart only
+// at its start. Other files (e.g., zstd and xz files, or when the format is detected) and
+// SQL dumps are read, decompressed, and decoded into rows from the start and rows which have
+// already been processed are skipped (for SQL dumps, statements other than INSERT are not
+// skipped because CREATE TABLE provides column names).
+//
+// The checkpoint is bound to the dump (its URL, Path, size, and fingerprint) and to FileType,
+// Compression, and shard. Resuming from a checkpoint of a different dump fails with
+// ErrCheckpointMismatch (see CheckpointConfig.ResetOnMismatch). If no checkpoint file is
+// configured, each dump uses its own checkpoint file in the working directory
+// (e.g., "checkpoint.latest-all.json.bz2.0123456789abcdef.json").
+//
+// Logger is used for structured diagnostic events (e.g., resuming from a checkpoint or skipping
+// an item which failed), with url and path attributes. If it is nil, nothing is logged.
+// It is used for the checkpoint as well, unless CheckpointConfig has its own Logger.
+//
+// Observer, if set, observes the processing pipeline: rows read, items decoded and processed,
+// failed items, occupancy of internal queues, and durations of Process (or ProcessBatch) calls.
+// Use Metrics to collect them and expose them in Prometheus text format.
+//
 // If just URL is provided, but not Path, then Process downloads and processes
 // the file at URL, but does not save it. If both URL and Path are provided,
 // and there file at Path does not exist, then Process saves the file at Path
 // while downloading and processing the file at URL. If the file at Path already
 // exists, then Process just uses it as-is and does not download anything from URL.
+// The file is downloaded to Path+".partial" and moved to Path once complete. If the
+// download is interrupted, the partial file is kept (with metadata in Path+".partial.json")
+// and the next Process reads the already downloaded data from it and resumes the download
+// with a Range request. If the file at URL has changed since (based on its ETag or
+// Last-Modified), the download starts from the beginning.
+//
+// If Checksum is set, the file is verified against the published checksums file (Client is
+// then required). A file downloaded to Path is verified while it is being downloaded and on
+// mismatch, Process returns ErrChecksumMismatch and the file is not moved to Path (see ChecksumConfig).
+// A file which already exists at Path is verified before it is processed (so it is read twice)
+// and on mismatch it is removed from Path. A file downloaded without saving it is verified
+// once it has been read in full and on mismatch Process returns ErrChecksumMismatch after
+// its items have already been processed. Such a file is then always read from the start
+// and not with a Range request when resuming from a checkpoint.
 //
 // Client should set User-Agent header with contact information, e.g.:
 //
@@ -132,6 +521,9 @@ const (
 //	client.RequestLogHook = func(logger retryablehttp.Logger, req *http.Request, retry int) {
 //		req.Header.Set("User-Agent", "My bot (user@example.com)")
 //	}
+//
+// To download from mirrors of the dumps site, configure Client with UseMirrors.
+// Process logs which mirror served the downloaded file.
 type ProcessConfig[T any] struct {
 	URL                    string
 	Path                   string
@@ -144,21 +536,88 @@ type ProcessConfig[T any] struct {
 	FileType               FileType
 	Compression            Compression
 	CheckpointConfig       *CheckpointConfig
+	Logger                 *slog.Logger
+	Observer               Observer
+	Checksum               *ChecksumConfig
+	Ordered                bool
+	ReorderWindow          int
+	Partition              func(T) uint64
+	ProcessBatch           func(context.Context, []T) errors.E
+	BatchSize              int
+	BatchLinger            time.Duration
+	ErrorPolicy            ErrorPolicy
+	DeadLetter             io.Writer
+	OnError                func(context.Context, DeadLetter)
+	Shard                  int
+	Shards                 int
 }
 
 // getFileRows is a goroutine which downloads a file from URL, optionally saves it to Path,
 // We only use one goroutine for downloading and processing the file.
+//
+// If offset is not zero, it is the offset in the decompressed file (or tar archive member) just after
+// the row skip, where reading can continue instead of reading and skipping all rows up to skip.
+// For compressed files and tar archives, restart is where reading restarts to get to offset.
 func getFileRows[T any]( //nolint:maintidx
-	ctx context.Context, config *ProcessConfig[T], wg *sync.WaitGroup,
-	output chan<- []byte, errs chan<- errors.E, cm *CheckpointManager,
+	ctx context.Context, config *ProcessConfig[T], wg *sync.WaitGroup, fileTypeState *x.SyncVar[FileType],
+	skip int, offset int64, restart *Restart, cm *CheckpointManager, window *reorderWindow, output chan<- []byte, errs chan<- errors.E,
 ) {
 	defer wg.Done()
 
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
+	observer := observerOrNop(config.Observer)
+
 	var compressedReader io.Reader
 	var compressedSize int64
+	// mirror is the mirror which served the downloaded file, if any (see MirrorTransport).
+	var mirror string
+
+	// Files without compression can be read directly at the offset and files with gzip or bzip2
+	// compression (and tar archives) can restart at a gzip member, bzip2 block, or tar archive
+	// member, if we do not have to detect the format from the start of the file. SQL dumps cannot
+	// be resumed at an offset because columns are known only from CREATE TABLE statement at
+	// the start of the file.
+	restartable := config.Compression != AutoCompression && restartableCompression(config.Compression) &&
+		(config.FileType == JSONArray || config.FileType == NDJSON || config.FileType == XML)
+	// resume is where in the file reading restarts, if resuming at the offset.
+	var resume *Restart
+	// A file streamed without saving it can be verified only when it is read from the start.
+	verifiable := config.Checksum == nil || config.Path != ""
+	if restartable && offset > 0 && verifiable {
+		if config.Compression == NoCompression {
+			resume = &Restart{Offset: offset, Decompressed: offset, CRC: 0, Level: 0, Entry: 0}
+		} else {
+			resume = restart
+		}
+	}
+	seekable := resume != nil
+	// seeked is true when reading starts at resume.Offset.
+	seeked := false
+
+	// Checksums are published for names of files at URL.
+	checksumName := fileName("", config.URL)
+	if config.URL == "" {
+		checksumName = fileName(config.Path, "")
+	}
+
+	if config.Path != "" && config.Checksum != nil {
+		// If the file is already available, we verify it before we use it.
+		_, err := os.Stat(config.Path)
+		if err == nil {
+			checksum, errE := newDownloadChecksum(ctx, config.Client, config.Checksum, checksumName)
+			if errE != nil {
+				errs <- errE
+				return
+			}
+			errE = verifyFileChecksum(config.Path, checksum)
+			if errE != nil {
+				errs <- errE
+				return
+			}
+		}
+	}
 
 	if config.Path != "" {
 		// If we file is already available, we use it.
@@ -181,18 +640,87 @@ func getFileRows[T any]( //nolint:maintidx
 				errs <- errE
 				return
 			}
-			_, err = compressedFile.Seek(0, io.SeekStart)
+			start := int64(0)
+			if seekable && resume.Offset <= compressedSize {
+				start = resume.Offset
+				seeked = true
+			}
+			_, err = compressedFile.Seek(start, io.SeekStart)
 			if err != nil {
 				errE := errors.WithMessage(err, "seek start")
 				errors.Details(errE)["path"] = config.Path
 				errs <- errE
 				return
 			}
+			// Progress is reported for the rest of the file.
+			compressedSize -= start
 		}
 	}
 
+	if compressedReader == nil && seekable && config.Path == "" {
+		// We do not have to save the file, so we can request the file from the offset on.
+		rangeReader, errE := newRangeResponse(ctx, config.Client, config.URL, "", resume.Offset)
+		if errE != nil {
+			errs <- errE
+			return
+		}
+		defer rangeReader.Close()
+		// Progress is reported for the rest of the file.
+		compressedSize = rangeReader.Size() - resume.Offset
+		compressedReader = rangeReader
+		mirror = rangeReader.mirror
+		seeked = true
+	}
+
+	if compressedReader == nil && config.Path != "" {
+		// File does not already exist. We download the file and save it, resuming
+		// a previously interrupted download, if possible.
+		var checksum *downloadChecksum
+		if config.Checksum != nil {
+			var errE errors.E
+			checksum, errE = newDownloadChecksum(ctx, config.Client, config.Checksum, checksumName)
+			if errE != nil {
+				errs <- errE
+				return
+			}
+		}
+		downloadOffset := int64(0)
+		if seekable {
+			downloadOffset = resume.Offset
+		}
+		download, start, errE := newPathDownload(ctx, config.Client, config.URL, config.Path, downloadOffset, checksum)
+		if errE != nil {
+			errs <- errE
+			return
+		}
+		defer func() {
+			err := download.Close()
+			if err != nil {
+				errs <- errors.WithStack(err)
+			}
+		}()
+		if seekable && start == downloadOffset {
+			// Already downloaded data up to the offset is not read.
+			seeked = true
+		}
+		// Progress is reported for the rest of the file.
+		compressedSize = download.Size() - start
+		compressedReader = download
+		mirror = download.mirror
+	}
+
+	// streamChecksum is the checksum of the file downloaded without saving it.
+	var streamChecksum *downloadChecksum
 	if compressedReader == nil {
-		// File does not already exist. We download the file and optionally save it.
+		// We download the file without saving it.
+		if config.Checksum != nil {
+			var errE errors.E
+			streamChecksum, errE = newDownloadChecksum(ctx, config.Client, config.Checksum, checksumName)
+			if errE != nil {
+				errs <- errE
+				return
+			}
+		}
 		req, err := retryablehttp.NewRequestWithContext(ctx, http.MethodGet, config.URL, nil)
 		if err != nil {
 			errE := errors.WithMessage(err, "new request")
@@ -208,28 +736,40 @@ func getFileRows[T any]( //nolint:maintidx
 		}
 		defer downloadReader.Close()
 		compressedSize = downloadReader.Size()
-		if config.Path != "" {
-			compressedFile, err := os.Create(config.Path)
-			if err != nil {
-				errE := errors.WithMessage(err, "create")
-				errors.Details(errE)["path"] = config.Path
-				errs <- errE
-				return
-			}
-			defer func() {
-				info, err := os.Stat(config.Path)
-				if err != nil || downloadReader.Size() != info.Size() {
-					// Incomplete file. Delete.
-					_ = os.Remove(config.Path)
-				}
-			}()
-			defer compressedFile.Close()
-			compressedReader = io.TeeReader(downloadReader, compressedFile)
-		} else {
-			compressedReader = downloadReader
+		compressedReader = downloadReader
+		mirror = downloadReader.Header.Get(MirrorHeader)
+		if streamChecksum != nil {
+			// The checksum is verified once the whole file has been read.
+			compressedReader = io.TeeReader(downloadReader, streamChecksum)
 		}
 	}
 
+	// finish reads the rest of the file, so that the whole file is written out to
+	// compressedFile and hashed, and verifies the checksum of the streamed file.
+	finish := func() {
+		_, err := io.Copy(io.Discard, compressedReader)
+		if streamChecksum == nil {
+			return
+		}
+		if err != nil {
+			errE := errors.WithMessage(err, "read")
+			errors.Details(errE)["url"] = config.URL
+			errs <- errE
+			return
+		}
+		errE := checksumMismatch(streamChecksum.hash, streamChecksum.expected)
+		if errE != nil {
+			errors.Details(errE)["url"] = config.URL
+			errs <- errE
+		}
+	}
+
+	if mirror != "" {
+		loggerOrDiscard(config.Logger).InfoContext(ctx, "downloading from mirror",
+			"stage", "download", "url", config.URL, "path", config.Path, "mirror", mirror,
+		)
+	}
+
 	countingReader := &x.CountingReader{Reader: compressedReader}
 	ticker := x.NewTicker(ctx, countingReader, compressedSize, progressPrintRate)
 	defer ticker.Stop()
@@ -241,65 +781,226 @@ func getFileRows[T any]( //nolint:maintidx
 		}
 	}()
 
+	name := fileName(config.Path, config.URL)
+
+	// We sniff magic bytes to detect the compression and then, after decompression,
+	// if the stream is a tar archive. Detected compression has to match the configured one.
+	// When reading starts at the offset, there is nothing to sniff.
+	bufferedReader := bufio.NewReaderSize(countingReader, fileTypeSniffSize)
+	compression, sniffed := NoCompression, false
+	if !seeked {
+		header, _ := bufferedReader.Peek(compressionSniffSize)
+		compression, sniffed = sniffCompression(header)
+	}
+	if !sniffed {
+		// There is no data, so we use the configured compression or the one from the file name.
+		compression = config.Compression
+		if compression == AutoCompression {
+			compression = compressionFromName(name)
+		}
+	}
+
+	// points are restart points of the compressed file, if we record them.
+	var points *restartPoints
+	if restartable && compression.withoutTar() != NoCompression {
+		points = &restartPoints{mu: sync.Mutex{}, points: nil}
+	}
+	var startRestart *Restart
+	if seeked {
+		startRestart = resume
+	}
+
 	var decompressedReader io.Reader
-	switch config.Compression {
-	case BZIP2, BZIP2Tar:
-		decompressedReader = pbzip2.NewReader(
-			ctx, countingReader,
-			pbzip2.DecompressionOptions(
-				pbzip2.BZConcurrency(config.DecompressionThreads),
-			),
-		)
-	case GZIP, GZIPTar:
-		gzipReader, err := gzip.NewReader(countingReader)
-		if err != nil {
-			errs <- errors.WithMessage(err, "new gzip reader")
+	switch compression.withoutTar() {
+	case BZIP2:
+		decompressedReader = newBzip2BlocksReader(ctx, bufferedReader, config.DecompressionThreads, startRestart, points)
+	case GZIP:
+		start, decompressed := int64(0), int64(0)
+		if startRestart != nil {
+			start, decompressed = startRestart.Offset, startRestart.Decompressed
+		}
+		gzipReader, errE := newGzipMembersReader(bufferedReader, start, decompressed, points)
+		if errE != nil {
+			errs <- errE
 			return
 		}
 		defer gzipReader.Close()
 		decompressedReader = gzipReader
-	case NoCompression, Tar:
-		decompressedReader = countingReader
+	case ZSTD:
+		zstdReader, err := zstd.NewReader(
+			bufferedReader,
+			zstd.WithDecoderConcurrency(config.DecompressionThreads),
+		)
+		if err != nil {
+			errs <- errors.WithMessage(err, "new zstd reader")
+			return
+		}
+		defer zstdReader.Close()
+		decompressedReader = zstdReader
+	case XZ:
+		// XZ decompression is not parallelized, so DecompressionThreads is not used.
+		xzReader, err := xz.NewReader(bufferedReader)
+		if err != nil {
+			errs <- errors.WithMessage(err, "new xz reader")
+			return
+		}
+		decompressedReader = xzReader
+	case NoCompression:
+		decompressedReader = bufferedReader
 	default:
-		panic(errors.Errorf("unknown compression: %d", config.Compression))
+		panic(errors.Errorf("unknown compression: %d", compression))
+	}
+
+	decompressedBufferedReader := bufio.NewReaderSize(decompressedReader, fileTypeSniffSize)
+	if sniffed {
+		// Sniffing for tar requires reading most of the first tar header, which delays processing
+		// of streamed data. A tar archive starts with the name of its first file, so if tar is not
+		// expected and data starts like a known file type, it is not a tar archive.
+		maybeTar := config.Compression == AutoCompression || config.Compression.isTar()
+		if !maybeTar {
+			_, knownFileType := sniffFileType(decompressedBufferedReader)
+			maybeTar = !knownFileType
+		}
+		if maybeTar && sniffTar(decompressedBufferedReader) {
+			compression = compression.withTar()
+		}
+		var errE errors.E
+		compression, errE = resolveCompression(config.Compression, compression)
+		if errE != nil {
+			errors.Details(errE)["name"] = name
+			errs <- errE
+			return
+		}
+	}
+
+	// start is the offset in the decompressed file at which reading starts.
+	start := int64(0)
+	if seeked {
+		// We continue at the offset or at the header of the tar archive member
+		// in which the offset is, skipping decompressed data before it.
+		start = offset
+		if compression.isTar() {
+			start = resume.Entry
+		}
+		_, err := decompressedBufferedReader.Discard(int(start - resume.Decompressed))
+		if err != nil {
+			errs <- errors.WithMessage(err, "discard")
+			return
+		}
 	}
 
-	if config.Compression == Tar || config.Compression == GZIPTar || config.Compression == BZIP2Tar {
-		decompressedReader = tar.NewReader(decompressedReader)
+	var tarReader *tar.Reader
+	// tarCountingReader counts data read by tarReader.
+	var tarCountingReader *x.CountingReader
+	// nextHeader is the offset in the decompressed file of the next tar header.
+	nextHeader := start
+	if compression.isTar() {
+		tarCountingReader = x.NewCountingReader(decompressedBufferedReader)
+		tarReader = tar.NewReader(tarCountingReader)
 	}
+	fileType := config.FileType
+	fileTypeResolved := false
 	var count = 0
-	// TotalItems is the number of items we have processed so far.
-	skip := cm.currentCheckpoint.ProcessedPosition
-	// WE NEED TO USE SAME GOROUTINE CONFIGURATION TO CONTINUE FROM THE LAST CHECKPOINT.
-	// For example, TotalItems is 100, and the size of the channel is 10, we can not make sure the last 10 items are processed, so retry them.
-	rollbackItemsToMakeSureProcessed := config.DecodingThreads
-	skip = max(0, skip-rollbackItemsToMakeSureProcessed)
-	fmt.Println("Will skip items:", skip)
 	for {
-		if config.Compression == Tar || config.Compression == GZIPTar || config.Compression == BZIP2Tar {
+		reader := decompressedBufferedReader
+		memberName := name
+		// entryRestart is where reading restarts for rows of the current tar archive member.
+		var entryRestart *Restart
+		if tarReader != nil {
 			// Go to the first or next file in gzip/tar.
-			_, err := decompressedReader.(*tar.Reader).Next()
+			h, err := tarReader.Next()
 			if err != nil {
 				// When there are no more files in gzip/tar, Next returns io.EOF.
 				if errors.Is(err, io.EOF) {
-					// Make sure the whole file is written out to compressedFile.
-					_, _ = io.Copy(io.Discard, compressedReader)
+					finish()
 				} else {
 					errs <- errors.WithMessage(err, "tar reader next")
 				}
 				return
 			}
+			reader = bufio.NewReaderSize(tarReader, fileTypeSniffSize)
+			memberName = path.Base(h.Name)
+			header := nextHeader
+			nextHeader = tarHeaderOffset(start+tarCountingReader.Count(), h.Size)
+			if restartable {
+				if points != nil {
+					entryRestart = points.last(header)
+				} else {
+					entryRestart = &Restart{Offset: header, Decompressed: header, CRC: 0, Level: 0, Entry: 0}
+				}
+				if entryRestart != nil {
+					r := *entryRestart
+					r.Entry = header
+					entryRestart = &r
+				}
+			}
+		}
+
+		if !fileTypeResolved {
+			// We detect the file type from the first file only.
+			detected, sniffed := JSONArray, false
+			if !seeked {
+				detected, sniffed = sniffFileType(reader)
+			}
+			var errE errors.E
+			fileType, errE = resolveFileType(config.FileType, detected, sniffed, memberName)
+			if errE != nil {
+				errors.Details(errE)["name"] = memberName
+				errs <- errE
+				return
+			}
+			errE = fileTypeState.Store(fileType)
+			if errE != nil {
+				errs <- errE
+				return
+			}
+			fileTypeResolved = true
+		}
+
+		// base is the offset in the decompressed file (or tar archive member) at which the iterator starts reading.
+		base := int64(0)
+		var iterReader io.Reader = reader
+		if seeked {
+			// Reading continues just after the row skip. Only the first tar
+			// archive member starts in the middle.
+			seeked = false
+			count = skip
+			base = offset
+			if tarReader != nil {
+				_, err := reader.Discard(int(offset))
+				if err != nil {
+					errs <- errors.WithMessage(err, "discard")
+					return
+				}
+			}
+			if fileType == JSONArray {
+				// We continue in the middle of the array, after a value. We remove the comma
+				// separating values and make the rest look like a JSON array.
+				consumed, errE := skipJSONArraySeparator(reader)
+				if errE != nil {
+					errs <- errE
+					return
+				}
+				iterReader = io.MultiReader(strings.NewReader("["), reader)
+				base += consumed - 1
+			}
 		}
 
 		var iter iterator
-		switch config.FileType {
+		switch fileType {
 		case JSONArray, NDJSON:
-			iter = newJSONIterator(decompressedReader)
+			iter = newJSONIterator(iterReader)
 		case SQLDump:
-			iter = newStatementIterator(decompressedReader)
+			iter = newStatementIterator(iterReader)
+		case XML:
+			iter = newPageIterator(iterReader)
+		case XMLRevision:
+			iter = newRevisionIterator(iterReader)
+		case AutoFileType:
+			panic(errors.New("file type not resolved"))
 		}
 
-		if config.FileType == JSONArray {
+		if fileType == JSONArray {
 			// Read open bracket.
 			_, err := (*json.Decoder)(iter.(*jsonIterator)).Token()
 			if err != nil {
@@ -321,19 +1022,51 @@ func getFileRows[T any]( //nolint:maintidx
 				return
 			}
 			count++
-			if count < skip {
+			if count <= skip && !passThrough(fileType, row) {
+				// Already processed in a previous run.
 				continue
 			}
+			if !inShard(config.Shard, config.Shards, fileType, count, row) {
+				continue
+			}
+			if window != nil {
+				// In ordered mode, we wait for space in the reorder window.
+				// The window tells the resequencer which row comes next.
+				errE := window.add(ctx, count, len(row))
+				if errE != nil {
+					errs <- errE
+					return
+				}
+			}
+			rowOffset := int64(-1)
+			rowRestart := entryRestart
+			// Offsets are tracked only in files which are configured to be restartable (with explicit
+			// compression and a file type which can be read from the middle), where reading can continue at them.
+			if restartable && iter.Offset() >= 0 {
+				rowOffset = base + iter.Offset()
+				if tarReader == nil && points != nil {
+					rowRestart = points.last(rowOffset)
+				}
+				if rowRestart == nil && compression != NoCompression {
+					// There is no restart point before the row.
+					rowOffset = -1
+				}
+			}
+			if rowOffset < 0 {
+				rowRestart = nil
+			}
+			cm.StartRow(count, rowOffset, rowRestart)
 			rowWithLineNumber := AddLineNumber(count, row)
 			select {
 			case <-ctx.Done():
 				errs <- errors.WithStack(ctx.Err())
 				return
 			case output <- rowWithLineNumber:
+				observer.RowRead(ctx)
 			}
 		}
 
-		if config.FileType == JSONArray {
+		if fileType == JSONArray {
 			// Read closing bracket.
 			_, err := (*json.Decoder)(iter.(*jsonIterator)).Token()
 			if err != nil {
@@ -348,14 +1081,13 @@ func getFileRows[T any]( //nolint:maintidx
 			}
 		}
 
-		if config.Compression != Tar && config.Compression != GZIPTar && config.Compression != BZIP2Tar {
+		if tarReader == nil {
 			// Only tar can have multiple files.
 			break
 		}
 	}
 
-	// Make sure the whole file is written out to compressedFile.
-	_, _ = io.Copy(io.Discard, compressedReader)
+	finish()
 }
 
 // Similar to strings.ToValidUTF8, but makes sure that the number
@@ -406,57 +1138,158 @@ func makeValid(s string) string {
 type OutputData[T any] struct {
 	Value      T
 	LineNumber int // to pass to checkpoint manager
+	// Index is the index of the item among items decoded from the same row.
+	Index int
+	// Items is the number of items decoded from the same row. Rows without any
+	// items (e.g., SQL statements other than INSERT) are passed on with Items
+	// set to 0, Index set to -1, and without Value, so that ordered processing
+	// can move past them.
+	Items int
+
+	// skipped is true for items which failed to decode and were skipped
+	// because of the error policy.
+	skipped bool
+	// format and data are raw data of the item, kept only for DeadLetterOnError error policy.
+	format string
+	data   []byte
 }
 
-func decodeJSON[T any](ctx context.Context, r []byte, output chan<- OutputData[T], errs chan<- errors.E) {
-	lineNumber, data, _ := ParseLineNumber(r)
+func decodeJSON[T any](data []byte) (T, errors.E) {
 	var e T
 	errE := x.UnmarshalWithoutUnknownFields(data, &e)
-	outputData := OutputData[T]{
-		Value:      e,
-		LineNumber: lineNumber,
-	}
 	if errE != nil {
-		errs <- errors.Prefix(errE, ErrJSONDecode)
-		return
+		return e, errors.Prefix(errE, ErrJSONDecode)
+	}
+	return e, nil
+}
+
+func decodeXML[T any](data []byte) (T, errors.E) {
+	var e T
+	err := xml.Unmarshal(data, &e)
+	if err != nil {
+		return e, errors.Prefix(err, ErrXMLDecode)
 	}
-	select {
-	case <-ctx.Done():
-		errs <- errors.WithStack(ctx.Err())
-		return
-	case output <- outputData:
+	return e, nil
+}
+
+// sqlInsertValues converts one list of values of an INSERT statement to JSON.
+func sqlInsertValues(columns []string, values []ast.ExprNode) ([]byte, errors.E) {
+	v := make(map[string]interface{})
+	for i, column := range values {
+		c, ok := column.(*test_driver.ValueExpr)
+		if !ok {
+			errE := errors.WithMessage(ErrUnexpectedType, "insert value")
+			errors.Details(errE)["type"] = fmt.Sprintf("%T", column)
+			errors.Details(errE)["column"] = i
+			return nil, errE
+		}
+		if i >= len(columns) {
+			errE := errors.WithMessage(ErrUnexpectedType, "insert value without column")
+			errors.Details(errE)["column"] = i
+			return nil, errE
+		}
+		z := c.GetValue()
+		switch zz := z.(type) {
+		case string:
+			// We have to make strings valid UTF-8 strings, otherwise they get "fixed"
+			// during JSON encoding/decoding process, which can change their length,
+			// which then breaks PHP decoding in DecodeImageMetadata, which is based
+			// on data lengths in bytes. This is why we have to fix them and preserve
+			// string length (and that of all substrings) at the same time.
+			z = makeValid(zz)
+		case *test_driver.MyDecimal:
+			// Decimals (e.g., page_random) would otherwise be encoded as empty JSON objects.
+			z = json.Number(zz.String())
+		}
+		v[columns[i]] = z
 	}
+	// We marshal to JSON to decode to a struct if provided.
+	return x.MarshalWithoutEscapeHTML(v)
 }
 
-func decodeRows[T any](
+func decodeRows[T any]( //nolint:maintidx
 	ctx context.Context, config *ProcessConfig[T], wg *sync.WaitGroup, decodeRowsState *x.SyncVar[[]string],
-	input <-chan []byte, output chan<- OutputData[T], errs chan<- errors.E,
+	fileTypeState *x.SyncVar[FileType], input <-chan []byte, output chan<- OutputData[T], errs chan<- errors.E,
+	handler *errorHandler,
 ) {
 	defer wg.Done()
 	sqlParser := parser.New()
 	var columns []string
+	var fileType *FileType
+
+	// send passes on the item. It returns false if decoding should stop.
+	send := func(outputData OutputData[T]) bool {
+		select {
+		case <-ctx.Done():
+			errs <- errors.WithStack(ctx.Err())
+			return false
+		case output <- outputData:
+			if outputData.Items > 0 && !outputData.skipped {
+				handler.observer.ItemDecoded(ctx)
+			}
+			return true
+		}
+	}
+
+	// fail handles an error for the item according to the error policy. If the error is handled,
+	// the item is passed on as skipped. It returns false if decoding should stop.
+	fail := func(errE errors.E, outputData OutputData[T], format string, data []byte) bool {
+		errE = handler.handle(ctx, errE, DeadLetter{
+			Stage:      DecodeStage,
+			LineNumber: outputData.LineNumber,
+			Index:      outputData.Index,
+			Format:     format,
+			Data:       data,
+			Columns:    columns,
+			Error:      "",
+			Details:    nil,
+		})
+		if errE != nil {
+			errs <- errE
+			return false
+		}
+		outputData.skipped = true
+		return send(outputData)
+	}
+
 	for {
 		select {
-		case row, ok := <-input:
+		case rowWithLineNumber, ok := <-input:
 			if !ok {
 				return
 			}
-			if config.FileType == SQLDump {
+			lineNumber, row, err := ParseLineNumber(rowWithLineNumber)
+			if err != nil {
+				errs <- errors.WithStack(err)
+				return
+			}
+			if fileType == nil {
+				// File type is resolved by getFileRows before any row is sent.
+				t := fileTypeState.Load()
+				fileType = &t
+			}
+			// Row without items, used also for rows which failed as a whole.
+			empty := OutputData[T]{
+				LineNumber: lineNumber,
+				Index:      -1,
+				Items:      0,
+			}
+			if *fileType == SQLDump {
 				rowString := x.ByteSlice2String(row)
 				stmt, err := sqlParser.ParseOneStmt(rowString, "", "")
 				if err != nil {
 					errE := errors.Prefix(err, ErrSQLParse)
 					errors.Details(errE)["row"] = string(row)
-					errs <- errE
-					return
+					if !fail(errE, empty, deadLetterSQL, row) {
+						return
+					}
+					continue
 				}
 				switch s := stmt.(type) {
-				case *ast.SetStmt:
-					continue
-				case *ast.DropTableStmt:
-					continue
-				case *ast.AlterTableStmt:
-					continue
+				case *ast.SetStmt, *ast.DropTableStmt, *ast.AlterTableStmt:
+					if !send(empty) {
+						return
+					}
 				case *ast.CreateTableStmt:
 					cols := []string{}
 					for _, col := range s.Cols {
@@ -469,52 +1302,89 @@ func decodeRows[T any](
 						return
 					}
 					columns = cols
+					if !send(empty) {
+						return
+					}
 				case *ast.InsertStmt:
 					if columns == nil {
 						// Wait for another goroutine to process CreateTableStmt.
-						columns = decodeRowsState.Load()
+						cols, errE := decodeRowsState.LoadContext(ctx)
+						if errE != nil {
+							errs <- errE
+							return
+						}
+						columns = cols
 					}
-					for _, r := range s.Lists {
-						v := make(map[string]interface{})
-						for i, column := range r {
-							c, ok := column.(*test_driver.ValueExpr)
-							if !ok {
-								errE := errors.WithMessage(ErrUnexpectedType, "insert value")
-								errors.Details(errE)["type"] = fmt.Sprintf("%T", column)
-								errors.Details(errE)["column"] = i
-								errors.Details(errE)["row"] = string(row)
-								errs <- errE
+					for index, r := range s.Lists {
+						outputData := OutputData[T]{
+							LineNumber: lineNumber,
+							Index:      index,
+							Items:      len(s.Lists),
+						}
+						d, errE := sqlInsertValues(columns, r)
+						if errE != nil {
+							errors.Details(errE)["row"] = string(row)
+							// Only the failing list of values is recorded, not the whole row.
+							t, err := sqlInsertTuple(s, r)
+							if err != nil {
+								errs <- err
 								return
 							}
-							z := c.GetValue()
-							zz, ok := z.(string)
-							if ok {
-								// We have to make strings valid UTF-8 strings, otherwise they get "fixed"
-								// during JSON encoding/decoding process, which can change their length,
-								// which then breaks PHP decoding in DecodeImageMetadata, which is based
-								// on data lengths in bytes. This is why we have to fix them and preserve
-								// string length (and that of all substrings) at the same time.
-								z = makeValid(zz)
+							if !fail(errE, outputData, deadLetterSQL, t) {
+								return
 							}
-							v[columns[i]] = z
+							continue
 						}
-						// We marshal to JSON to decode to a struct if provided.
-						d, errE := x.MarshalWithoutEscapeHTML(v)
+						outputData.Value, errE = decodeJSON[T](d)
 						if errE != nil {
-							errs <- errE
+							if !fail(errE, outputData, deadLetterJSON, d) {
+								return
+							}
+							continue
+						}
+						if handler.keepData() {
+							outputData.format = deadLetterJSON
+							outputData.data = d
+						}
+						if !send(outputData) {
 							return
 						}
-						decodeJSON(ctx, d, output, errs)
 					}
 				default:
 					errE := errors.WithMessage(ErrUnexpectedType, "statement")
 					errors.Details(errE)["type"] = fmt.Sprintf("%T", stmt)
 					errors.Details(errE)["row"] = string(row)
-					errs <- errE
-					return
+					if !fail(errE, empty, deadLetterSQL, row) {
+						return
+					}
 				}
 			} else {
-				decodeJSON(ctx, row, output, errs)
+				outputData := OutputData[T]{
+					LineNumber: lineNumber,
+					Index:      0,
+					Items:      1,
+				}
+				format := deadLetterJSON
+				var errE errors.E
+				if *fileType == XML || *fileType == XMLRevision {
+					format = deadLetterXML
+					outputData.Value, errE = decodeXML[T](row)
+				} else {
+					outputData.Value, errE = decodeJSON[T](row)
+				}
+				if errE != nil {
+					if !fail(errE, outputData, format, row) {
+						return
+					}
+					continue
+				}
+				if handler.keepData() {
+					outputData.format = format
+					outputData.data = row
+				}
+				if !send(outputData) {
+					return
+				}
 			}
 		case <-ctx.Done():
 			errs <- errors.WithStack(ctx.Err())
@@ -525,7 +1395,7 @@ func decodeRows[T any](
 
 func processItems[T any](
 	ctx context.Context, config *ProcessConfig[T], wg *sync.WaitGroup,
-	input <-chan OutputData[T], errs chan<- errors.E, cm *CheckpointManager,
+	input <-chan OutputData[T], errs chan<- errors.E, cm *CheckpointManager, handler *errorHandler,
 ) {
 	defer wg.Done()
 
@@ -535,13 +1405,205 @@ func processItems[T any](
 			if !ok {
 				return
 			}
+			if i.Items == 0 || i.skipped {
+				// Row without items or an item which failed to decode.
+				if err := cm.CompleteItem(i.LineNumber, i.Items); err != nil {
+					errs <- errors.WithStack(err)
+					return
+				}
+				continue
+			}
+			start := time.Now()
 			err := config.Process(ctx, i.Value)
+			handler.observer.ItemsProcessed(ctx, 1, time.Since(start))
 			if err != nil {
-				errs <- err
+				err = handler.handle(ctx, err, processDeadLetter(i))
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+			if err := cm.CompleteItem(i.LineNumber, i.Items); err != nil {
+				errs <- errors.WithStack(err)
+				return
+			}
+		case <-ctx.Done():
+			errs <- errors.WithStack(ctx.Err())
+			return
+		}
+	}
+}
+
+func processBatches[T any](
+	ctx context.Context, config *ProcessConfig[T], wg *sync.WaitGroup,
+	input <-chan OutputData[T], errs chan<- errors.E, cm *CheckpointManager, handler *errorHandler,
+) {
+	defer wg.Done()
+
+	batch := make([]T, 0, config.BatchSize)
+	items := make([]OutputData[T], 0, config.BatchSize)
+	var timer *time.Timer
+	// linger is nil (and blocks forever) when there is no batch waiting.
+	var linger <-chan time.Time
+
+	flush := func() errors.E {
+		if timer != nil {
+			timer.Stop()
+			timer = nil
+			linger = nil
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+		start := time.Now()
+		errE := config.ProcessBatch(ctx, batch)
+		handler.observer.ItemsProcessed(ctx, len(batch), time.Since(start))
+		if errE != nil {
+			// The whole batch failed, so the error policy applies to all its items.
+			for _, i := range items {
+				err := handler.handle(ctx, errE, processDeadLetter(i))
+				if err != nil {
+					return err
+				}
+			}
+		}
+		// Only now that the whole batch has been processed we update the checkpoint.
+		for _, i := range items {
+			if err := cm.CompleteItem(i.LineNumber, i.Items); err != nil {
+				return errors.WithStack(err)
+			}
+		}
+		batch = make([]T, 0, config.BatchSize)
+		items = make([]OutputData[T], 0, config.BatchSize)
+		return nil
+	}
+
+	for {
+		select {
+		case i, ok := <-input:
+			if !ok {
+				errE := flush()
+				if errE != nil {
+					errs <- errE
+				}
 				return
 			}
-			if err := cm.UpdateProgressAndMaybeSave(i.LineNumber, ""); err != nil {
-				fmt.Println("Failed to update progress:", err)
+			if i.Items == 0 || i.skipped {
+				// Row without items or an item which failed to decode.
+				if err := cm.CompleteItem(i.LineNumber, i.Items); err != nil {
+					errs <- errors.WithStack(err)
+					return
+				}
+				continue
+			}
+			if len(batch) == 0 && config.BatchLinger > 0 {
+				timer = time.NewTimer(config.BatchLinger)
+				linger = timer.C
+			}
+			batch = append(batch, i.Value)
+			items = append(items, i)
+			if len(batch) >= config.BatchSize {
+				errE := flush()
+				if errE != nil {
+					errs <- errE
+					return
+				}
+			}
+		case <-linger:
+			errE := flush()
+			if errE != nil {
+				errs <- errE
+				return
+			}
+		case <-ctx.Done():
+			if timer != nil {
+				timer.Stop()
+			}
+			errs <- errors.WithStack(ctx.Err())
+			return
+		}
+	}
+}
+
+// resequenceItems buffers items until all items of the next row are available and then
+// passes them on in order. Rows in the order they were read are in the window,
+// so each passed row frees space in the window.
+func resequenceItems[T any](
+	ctx context.Context, wg *sync.WaitGroup, window *reorderWindow,
+	input <-chan OutputData[T], output chan<- OutputData[T], errs chan<- errors.E,
+) {
+	defer wg.Done()
+
+	pending := map[int][]OutputData[T]{}
+	for {
+		select {
+		case i, ok := <-input:
+			if !ok {
+				if len(pending) > 0 {
+					errE := errors.New("rows missing at the end of ordered processing")
+					if next, ok := window.next(); ok {
+						errors.Details(errE)["next"] = next
+					}
+					errors.Details(errE)["pending"] = len(pending)
+					errs <- errE
+				}
+				return
+			}
+			pending[i.LineNumber] = append(pending[i.LineNumber], i)
+			for len(pending) > 0 {
+				// All pending rows have been added to the window before they were sent
+				// for decoding, so the window is not empty.
+				next, _ := window.next()
+				row := pending[next]
+				// All items of a row are decoded by the same goroutine, so they are already in order.
+				if len(row) == 0 || len(row) < row[0].Items {
+					break
+				}
+				delete(pending, next)
+				for _, o := range row {
+					select {
+					case <-ctx.Done():
+						errs <- errors.WithStack(ctx.Err())
+						return
+					case output <- o:
+					}
+				}
+				window.remove()
+			}
+		case <-ctx.Done():
+			errs <- errors.WithStack(ctx.Err())
+			return
+		}
+	}
+}
+
+// partitionItems passes each item to the output for the value partition returns for it,
+// so items with the same value are passed on in the order they are received.
+func partitionItems[T any](
+	ctx context.Context, wg *sync.WaitGroup, partition func(T) uint64,
+	input <-chan OutputData[T], outputs []chan OutputData[T], errs chan<- errors.E,
+) {
+	defer wg.Done()
+
+	for {
+		select {
+		case i, ok := <-input:
+			if !ok {
+				return
+			}
+			var p uint64
+			if i.Items == 0 || i.skipped {
+				// Row without items or an item which failed to decode has no value,
+				// it just has to be completed by any goroutine.
+				p = uint64(i.LineNumber) //nolint:gosec
+			} else {
+				p = partition(i.Value)
+			}
+			select {
+			case <-ctx.Done():
+				errs <- errors.WithStack(ctx.Err())
+				return
+			case outputs[p%uint64(len(outputs))] <- i:
 			}
 		case <-ctx.Done():
 			errs <- errors.WithStack(ctx.Err())
@@ -551,12 +1613,27 @@ func processItems[T any](
 }
 
 // Process is a low-level function which decompresses a file (supports Compression compressions),
-// extacts JSONs or SQL statements from it (stored in FileType types), decodes JSONs or SQL statements, and
-// calls Process callback on each decoded JSON or SQL statement. All that in parallel fashion, controlled by
+// extacts JSONs, SQL statements, or XML pages from it (stored in FileType types), decodes them, and
+// calls Process callback on each decoded item. All that in parallel fashion, controlled by
 // DecompressionThreads, DecodingThreads, and ItemsProcessingThreads. File is downloaded from a HTTP URL and is
 // processed already during download. Downloaded file is optionally saved (to a file at Path) and followup
 // calls to Process can use a saved file (if same Path is provided).
-func Process[T any](ctx context.Context, config *ProcessConfig[T]) errors.E {
+func Process[T any](ctx context.Context, config *ProcessConfig[T]) (errReturn errors.E) { //nolint:nonamedreturns
+	if (config.Process == nil) == (config.ProcessBatch == nil) {
+		return errors.New("exactly one of Process and ProcessBatch has to be provided")
+	}
+	if config.ErrorPolicy == DeadLetterOnError && config.DeadLetter == nil {
+		return errors.New("DeadLetter is required for DeadLetterOnError error policy")
+	}
+	if config.Shards < 0 || config.Shard < 0 || config.Shard >= max(config.Shards, 1) {
+		errE := errors.WithMessage(ErrInvalidValue, "shard")
+		errors.Details(errE)["shard"] = config.Shard
+		errors.Details(errE)["shards"] = config.Shards
+		return errE
+	}
+	logger := loggerOrDiscard(config.Logger).With("url", config.URL, "path", config.Path)
+	observer := observerOrNop(config.Observer)
+	handler := newErrorHandler(config.ErrorPolicy, config.DeadLetter, config.OnError, logger, observer)
 	if config.DecompressionThreads == 0 {
 		config.DecompressionThreads = runtime.GOMAXPROCS(0)
 	}
@@ -566,6 +1643,17 @@ func Process[T any](ctx context.Context, config *ProcessConfig[T]) errors.E {
 	if config.ItemsProcessingThreads == 0 {
 		config.ItemsProcessingThreads = runtime.GOMAXPROCS(0)
 	}
+	if config.ProcessBatch != nil && config.BatchSize == 0 {
+		config.BatchSize = defaultBatchSize
+	}
+	if config.Ordered {
+		if config.Partition == nil {
+			config.ItemsProcessingThreads = 1
+		}
+		if config.ReorderWindow == 0 {
+			config.ReorderWindow = defaultReorderWindow
+		}
+	}
 
 	// We call cancel on any error from goroutines. The expectation is that all
 	// goroutines return soon afterwards.
@@ -578,21 +1666,84 @@ func Process[T any](ctx context.Context, config *ProcessConfig[T]) errors.E {
 	// mainWgChan is closed when mainWg is done.
 	mainWgChan := make(chan struct{})
 
-	errs := make(chan errors.E, 1+config.DecodingThreads+config.ItemsProcessingThreads)
+	errs := make(chan errors.E, 3+config.DecodingThreads+config.ItemsProcessingThreads)
 	defer close(errs)
 
 	rows := make(chan []byte, config.DecodingThreads)
 	items := make(chan OutputData[T], config.ItemsProcessingThreads)
-	var cm *CheckpointManager
+	if config.Observer != nil {
+		go observeQueues(ctx, observer, rows, items)
+	}
+	dump, errE := dumpIdentity(ctx, config)
+	if errE != nil {
+		return errE
+	}
+	checkpointConfig := CheckpointConfig{
+		SaveInterval:    saveInterval,
+		ItemsThreshold:  itemsThreshold,
+		CheckpointFile:  "",
+		Store:           nil,
+		ResetOnMismatch: false,
+	}
 	if config.CheckpointConfig != nil {
-		cm = NewCheckpointManagerWithConfig(config.CheckpointConfig)
-	} else {
-		cm = NewCheckpointManager()
+		checkpointConfig = *config.CheckpointConfig
+	}
+	if checkpointConfig.Logger == nil {
+		checkpointConfig.Logger = logger
 	}
+	if checkpointConfig.CheckpointFile == "" && checkpointConfig.Store == nil {
+		// Each dump has its own checkpoint file by default.
+		checkpointConfig.CheckpointFile = dumpCheckpointFile(config.Path, config.URL)
+	}
+	// Each shard has its own checkpoint.
+	checkpointConfig.CheckpointFile = shardCheckpointFile(checkpointConfig.CheckpointFile, config.Shard, config.Shards)
+	cm, errE := LoadCheckpointManager(&checkpointConfig)
+	if errE != nil {
+		if checkpointConfig.Store == nil {
+			errors.Details(errE)["checkpointFile"] = checkpointConfig.CheckpointFile
+		}
+		return errE
+	}
+	// Progress since the checkpoint was last saved is saved when Process returns,
+	// unless somebody else changed the checkpoint in the meantime.
+	defer func() {
+		if errors.Is(errReturn, ErrCheckpointConflict) {
+			cm.stopAutoSave()
+			return
+		}
+		err := cm.Close()
+		if err != nil {
+			errE := errors.WithMessage(err, "close checkpoint")
+			if errReturn == nil {
+				errReturn = errE
+			} else {
+				errReturn = errors.Join(errReturn, errE)
+			}
+		}
+	}()
+	errE = cm.bind(dump, checkpointConfig.ResetOnMismatch)
+	if errE != nil {
+		if checkpointConfig.Store == nil {
+			errors.Details(errE)["checkpointFile"] = checkpointConfig.CheckpointFile
+		}
+		return errE
+	}
+	// ProcessedPosition is the low watermark, all rows up to and including it have been processed.
+	skip := cm.currentCheckpoint.ProcessedPosition
+	if skip > 0 {
+		logger.InfoContext(ctx, "resuming from checkpoint", "stage", "checkpoint", "line", skip, "offset", cm.currentCheckpoint.Offset)
+	}
+
+	var window *reorderWindow
+	if config.Ordered {
+		window = newReorderWindow(config.ReorderWindow)
+	}
+
 	var getFileRowsWg sync.WaitGroup
 	mainWg.Add(1)
 	getFileRowsWg.Add(1)
-	go getFileRows(ctx, config, &getFileRowsWg, rows, errs, cm)
+	fileTypeState := x.NewSyncVar[FileType]()
+	go getFileRows(ctx, config, &getFileRowsWg, fileTypeState, skip, cm.currentCheckpoint.Offset, cm.currentCheckpoint.Restart, cm, window, rows, errs)
 	go func() {
 		getFileRowsWg.Wait()
 		mainWg.Done()
@@ -606,7 +1757,7 @@ func Process[T any](ctx context.Context, config *ProcessConfig[T]) errors.E {
 	mainWg.Add(1)
 	for range config.DecodingThreads {
 		decodeRowsWg.Add(1)
-		go decodeRows(ctx, config, &decodeRowsWg, decodeRowsState, rows, items, errs)
+		go decodeRows(ctx, config, &decodeRowsWg, decodeRowsState, fileTypeState, rows, items, errs, handler)
 	}
 	go func() {
 		decodeRowsWg.Wait()
@@ -616,11 +1767,58 @@ func Process[T any](ctx context.Context, config *ProcessConfig[T]) errors.E {
 		close(items)
 	}()
 
+	processItemsInput := items
+	if config.Ordered {
+		orderedItems := make(chan OutputData[T], config.ItemsProcessingThreads)
+		var resequenceItemsWg sync.WaitGroup
+		mainWg.Add(1)
+		resequenceItemsWg.Add(1)
+		go resequenceItems(ctx, &resequenceItemsWg, window, items, orderedItems, errs)
+		go func() {
+			resequenceItemsWg.Wait()
+			mainWg.Done()
+			// All goroutines using orderedItems channel as output are done,
+			// we can close the channel.
+			close(orderedItems)
+		}()
+		processItemsInput = orderedItems
+	}
+
+	// By default, all goroutines processing items read from the same channel.
+	processItemsInputs := make([]<-chan OutputData[T], config.ItemsProcessingThreads)
+	for i := range processItemsInputs {
+		processItemsInputs[i] = processItemsInput
+	}
+	if config.Ordered && config.Partition != nil {
+		partitions := make([]chan OutputData[T], config.ItemsProcessingThreads)
+		for i := range partitions {
+			partitions[i] = make(chan OutputData[T], partitionQueueSize)
+			processItemsInputs[i] = partitions[i]
+		}
+		var partitionItemsWg sync.WaitGroup
+		mainWg.Add(1)
+		partitionItemsWg.Add(1)
+		go partitionItems(ctx, &partitionItemsWg, config.Partition, processItemsInput, partitions, errs)
+		go func() {
+			partitionItemsWg.Wait()
+			mainWg.Done()
+			// All goroutines using partitions channels as output are done,
+			// we can close the channels.
+			for _, partition := range partitions {
+				close(partition)
+			}
+		}()
+	}
+
 	var processItemWg sync.WaitGroup
 	mainWg.Add(1)
-	for range config.ItemsProcessingThreads {
+	for _, input := range processItemsInputs {
 		processItemWg.Add(1)
-		go processItems(ctx, config, &processItemWg, items, errs, cm)
+		if config.ProcessBatch != nil {
+			go processBatches(ctx, config, &processItemWg, input, errs, cm, handler)
+		} else {
+			go processItems(ctx, config, &processItemWg, input, errs, cm, handler)
+		}
 	}
 	go func() {
 		processItemWg.Wait()
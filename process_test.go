@@ -1,14 +1,28 @@
 package mediawiki_test
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
 	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/hashicorp/go-retryablehttp"
+	"github.com/klauspost/compress/zstd"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/ulikunitz/xz"
 	"gitlab.com/tozd/go/errors"
 
 	"github.com/citadel2024/go-mediawiki"
@@ -57,8 +71,9 @@ func TestCompressionRemote(t *testing.T) {
 					atomic.AddInt64(&itemCounter, int64(1))
 					return nil
 				},
-				FileType:    test.dumpType,
-				Compression: test.compression,
+				FileType:         test.dumpType,
+				Compression:      test.compression,
+				CheckpointConfig: testCheckpointConfig(),
 			})
 			require.NoError(t, err, "% -+#.1v", err)
 			assert.Equal(t, int64(test.items), itemCounter)
@@ -81,8 +96,9 @@ func TestCompressionLocal(t *testing.T) {
 					atomic.AddInt64(&itemCounter, int64(1))
 					return nil
 				},
-				FileType:    test.dumpType,
-				Compression: test.compression,
+				FileType:         test.dumpType,
+				Compression:      test.compression,
+				CheckpointConfig: testCheckpointConfig(),
 			})
 			require.NoError(t, err, "% -+#.1v", err)
 			assert.Equal(t, int64(test.items), itemCounter)
@@ -90,6 +106,1174 @@ func TestCompressionLocal(t *testing.T) {
 	}
 }
 
+func TestCompressionZSTDXZ(t *testing.T) {
+	t.Parallel()
+
+	tempDir := t.TempDir()
+
+	var jsonArray bytes.Buffer
+	jsonArray.WriteString("[\n")
+	for i := range 10 {
+		if i > 0 {
+			jsonArray.WriteString(",\n")
+		}
+		fmt.Fprintf(&jsonArray, `{"id":"Q%d"}`, i)
+	}
+	jsonArray.WriteString("\n]\n")
+
+	// Two files in tar with 5 items each.
+	var tarArchive bytes.Buffer
+	tw := tar.NewWriter(&tarArchive)
+	for file := range 2 {
+		var ndjson bytes.Buffer
+		for i := range 5 {
+			fmt.Fprintf(&ndjson, `{"id":"%d-%d"}`+"\n", file, i)
+		}
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: fmt.Sprintf("%d.ndjson", file), Mode: 0o600, Size: int64(ndjson.Len())}))
+		_, err := tw.Write(ndjson.Bytes())
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+
+	for _, test := range []struct {
+		name        string
+		data        []byte
+		compression mediawiki.Compression
+		dumpType    mediawiki.FileType
+		newWriter   func(io.Writer) (io.WriteCloser, error)
+	}{
+		{"all.json.zst", jsonArray.Bytes(), mediawiki.ZSTD, mediawiki.JSONArray, newZSTDWriter},
+		{"html.json.tar.zst", tarArchive.Bytes(), mediawiki.ZSTDTar, mediawiki.NDJSON, newZSTDWriter},
+		{"all.json.xz", jsonArray.Bytes(), mediawiki.XZ, mediawiki.JSONArray, newXZWriter},
+		{"html.json.tar.xz", tarArchive.Bytes(), mediawiki.XZTar, mediawiki.NDJSON, newXZWriter},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			path := filepath.Join(tempDir, test.name)
+			f, err := os.Create(path)
+			require.NoError(t, err)
+			w, err := test.newWriter(f)
+			require.NoError(t, err)
+			_, err = w.Write(test.data)
+			require.NoError(t, err)
+			require.NoError(t, w.Close())
+			require.NoError(t, f.Close())
+
+			itemCounter := int64(0)
+
+			errE := mediawiki.Process(context.Background(), &mediawiki.ProcessConfig[interface{}]{
+				Path: path,
+				Process: func(_ context.Context, _ interface{}) errors.E {
+					atomic.AddInt64(&itemCounter, int64(1))
+					return nil
+				},
+				FileType:    test.dumpType,
+				Compression: test.compression,
+				CheckpointConfig: &mediawiki.CheckpointConfig{
+					SaveInterval:   time.Minute,
+					ItemsThreshold: 1000,
+					CheckpointFile: path + ".checkpoint.json",
+				},
+			})
+			require.NoError(t, errE, "% -+#.1v", errE)
+			assert.Equal(t, int64(10), itemCounter)
+		})
+	}
+}
+
+func TestAutoDetection(t *testing.T) {
+	t.Parallel()
+
+	tempDir := t.TempDir()
+
+	jsonArray := []byte("\n  [{\"id\":\"Q1\"},{\"id\":\"Q2\"}]\n")
+	ndjson := []byte("{\"id\":\"Q1\"}\n{\"id\":\"Q2\"}\n{\"id\":\"Q3\"}\n")
+
+	var tarArchive bytes.Buffer
+	tw := tar.NewWriter(&tarArchive)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "data.ndjson", Mode: 0o600, Size: int64(len(ndjson))}))
+	_, err := tw.Write(ndjson)
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+
+	for _, test := range []struct {
+		name        string
+		data        []byte
+		newWriter   func(io.Writer) (io.WriteCloser, error)
+		compression mediawiki.Compression
+		fileType    mediawiki.FileType
+		items       int64
+		err         error
+	}{
+		{"array.data", jsonArray, nil, mediawiki.AutoCompression, mediawiki.AutoFileType, 2, nil},
+		{"items.data.gz", ndjson, newGzipWriter, mediawiki.AutoCompression, mediawiki.AutoFileType, 3, nil},
+		{"items.tar.zst", tarArchive.Bytes(), newZSTDWriter, mediawiki.AutoCompression, mediawiki.AutoFileType, 3, nil},
+		{"items.tar.xz", tarArchive.Bytes(), newXZWriter, mediawiki.XZTar, mediawiki.NDJSON, 3, nil},
+		{"empty.ndjson", nil, nil, mediawiki.AutoCompression, mediawiki.AutoFileType, 0, nil},
+		{"empty.data", nil, nil, mediawiki.AutoCompression, mediawiki.AutoFileType, 0, mediawiki.ErrUnknownFormat},
+		{"items.gz", ndjson, newGzipWriter, mediawiki.BZIP2, mediawiki.NDJSON, 0, mediawiki.ErrFormatMismatch},
+		{"archive.tar.gz", tarArchive.Bytes(), newGzipWriter, mediawiki.GZIP, mediawiki.AutoFileType, 0, mediawiki.ErrFormatMismatch},
+		{"items.tar.gz", ndjson, newGzipWriter, mediawiki.GZIPTar, mediawiki.AutoFileType, 0, mediawiki.ErrFormatMismatch},
+		{"items.ndjson", ndjson, nil, mediawiki.AutoCompression, mediawiki.JSONArray, 0, mediawiki.ErrFormatMismatch},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			path := filepath.Join(tempDir, test.name)
+			f, err := os.Create(path)
+			require.NoError(t, err)
+			var w io.WriteCloser = f
+			if test.newWriter != nil {
+				w, err = test.newWriter(f)
+				require.NoError(t, err)
+			}
+			_, err = w.Write(test.data)
+			require.NoError(t, err)
+			require.NoError(t, w.Close())
+			if test.newWriter != nil {
+				require.NoError(t, f.Close())
+			}
+
+			itemCounter := int64(0)
+
+			errE := mediawiki.Process(context.Background(), &mediawiki.ProcessConfig[interface{}]{
+				Path: path,
+				Process: func(_ context.Context, _ interface{}) errors.E {
+					atomic.AddInt64(&itemCounter, int64(1))
+					return nil
+				},
+				FileType:    test.fileType,
+				Compression: test.compression,
+				CheckpointConfig: &mediawiki.CheckpointConfig{
+					SaveInterval:   time.Minute,
+					ItemsThreshold: 1000,
+					CheckpointFile: path + ".checkpoint.json",
+				},
+			})
+			if test.err != nil {
+				assert.ErrorIs(t, errE, test.err)
+			} else {
+				require.NoError(t, errE, "% -+#.1v", errE)
+				assert.Equal(t, test.items, itemCounter)
+			}
+		})
+	}
+}
+
+type testNumber struct {
+	N int `json:"n" xml:"n"`
+}
+
+func TestOrdered(t *testing.T) {
+	t.Parallel()
+
+	tempDir := t.TempDir()
+
+	var ndjson bytes.Buffer
+	for i := range 2000 {
+		fmt.Fprintf(&ndjson, `{"n":%d}`+"\n", i)
+	}
+
+	var sql bytes.Buffer
+	sql.WriteString("DROP TABLE IF EXISTS `t`;\nCREATE TABLE `t` (\n  `n` int(10) NOT NULL\n);\n")
+	for i := range 100 {
+		sql.WriteString("INSERT INTO `t` VALUES ")
+		for j := range 20 {
+			if j > 0 {
+				sql.WriteString(",")
+			}
+			fmt.Fprintf(&sql, "(%d)", i*20+j)
+		}
+		sql.WriteString(";\n")
+	}
+
+	for _, test := range []struct {
+		name     string
+		data     []byte
+		fileType mediawiki.FileType
+	}{
+		{"items.ndjson", ndjson.Bytes(), mediawiki.NDJSON},
+		{"items.sql", sql.Bytes(), mediawiki.SQLDump},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			path := filepath.Join(tempDir, test.name)
+			err := os.WriteFile(path, test.data, 0o600)
+			require.NoError(t, err)
+
+			numbers := []int{}
+
+			errE := mediawiki.Process(context.Background(), &mediawiki.ProcessConfig[testNumber]{
+				Path:            path,
+				DecodingThreads: 8,
+				Process: func(_ context.Context, i testNumber) errors.E {
+					// No locking needed because in ordered mode the callback is called from one goroutine.
+					numbers = append(numbers, i.N)
+					return nil
+				},
+				FileType:      test.fileType,
+				Compression:   mediawiki.NoCompression,
+				Ordered:       true,
+				ReorderWindow: 256,
+				CheckpointConfig: &mediawiki.CheckpointConfig{
+					SaveInterval:   time.Minute,
+					ItemsThreshold: 100000,
+					CheckpointFile: path + ".checkpoint.json",
+				},
+			})
+			require.NoError(t, errE, "% -+#.1v", errE)
+			require.Len(t, numbers, 2000)
+			for i, n := range numbers {
+				require.Equal(t, i, n)
+			}
+		})
+	}
+}
+
+func TestProcessBatch(t *testing.T) {
+	t.Parallel()
+
+	tempDir := t.TempDir()
+
+	var ndjson bytes.Buffer
+	for i := range 2500 {
+		fmt.Fprintf(&ndjson, `{"n":%d}`+"\n", i)
+	}
+	path := filepath.Join(tempDir, "items.ndjson")
+	err := os.WriteFile(path, ndjson.Bytes(), 0o600)
+	require.NoError(t, err)
+
+	t.Run("sizes", func(t *testing.T) {
+		t.Parallel()
+
+		var mu sync.Mutex
+		sizes := []int{}
+		numbers := map[int]bool{}
+
+		errE := mediawiki.Process(context.Background(), &mediawiki.ProcessConfig[testNumber]{
+			Path:                   path,
+			ItemsProcessingThreads: 1,
+			ProcessBatch: func(_ context.Context, batch []testNumber) errors.E {
+				mu.Lock()
+				defer mu.Unlock()
+				sizes = append(sizes, len(batch))
+				for _, i := range batch {
+					numbers[i.N] = true
+				}
+				return nil
+			},
+			BatchSize:   1000,
+			FileType:    mediawiki.NDJSON,
+			Compression: mediawiki.NoCompression,
+			CheckpointConfig: &mediawiki.CheckpointConfig{
+				SaveInterval:   time.Minute,
+				ItemsThreshold: 100000,
+				CheckpointFile: filepath.Join(tempDir, "sizes.checkpoint.json"),
+			},
+		})
+		require.NoError(t, errE, "% -+#.1v", errE)
+		assert.Equal(t, []int{1000, 1000, 500}, sizes)
+		assert.Len(t, numbers, 2500)
+	})
+
+	t.Run("checkpoint", func(t *testing.T) {
+		t.Parallel()
+
+		checkpointPath := filepath.Join(tempDir, "checkpoint.checkpoint.json")
+		batches := 0
+
+		errE := mediawiki.Process(context.Background(), &mediawiki.ProcessConfig[testNumber]{
+			Path: path,
+			ProcessBatch: func(_ context.Context, _ []testNumber) errors.E {
+				batches++
+				if batches == 3 {
+					return errors.New("test error")
+				}
+				return nil
+			},
+			BatchSize:   10,
+			Ordered:     true,
+			FileType:    mediawiki.NDJSON,
+			Compression: mediawiki.NoCompression,
+			CheckpointConfig: &mediawiki.CheckpointConfig{
+				SaveInterval:   time.Minute,
+				ItemsThreshold: 1,
+				CheckpointFile: checkpointPath,
+			},
+		})
+		require.Error(t, errE)
+
+		data, err := os.ReadFile(checkpointPath)
+		require.NoError(t, err)
+		var checkpoint mediawiki.Checkpoint
+		require.NoError(t, json.Unmarshal(data, &checkpoint))
+		// Only the first two batches were acknowledged.
+		assert.Equal(t, 20, checkpoint.ProcessedPosition)
+		assert.Equal(t, 20, checkpoint.TotalItems)
+	})
+
+	t.Run("linger", func(t *testing.T) {
+		t.Parallel()
+
+		firstBatch := make(chan int, 1)
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Length", "32")
+			if r.Header.Get("Range") != "" {
+				// Fingerprint of the dump is requested before processing starts.
+				fmt.Fprint(w, `{"n":0}`+"\n"+`{"n":1}`+"\n"+`{"n":2}`+"\n"+`{"n":3}`+"\n")
+				return
+			}
+			fmt.Fprint(w, `{"n":0}`+"\n"+`{"n":1}`+"\n"+`{"n":2}`+"\n")
+			w.(http.Flusher).Flush()
+			// We wait for the first batch before sending the rest.
+			select {
+			case <-firstBatch:
+			case <-time.After(5 * time.Second):
+			}
+			fmt.Fprint(w, `{"n":3}`+"\n")
+		}))
+		t.Cleanup(ts.Close)
+
+		client := retryablehttp.NewClient()
+		client.Logger = nil
+
+		var mu sync.Mutex
+		sizes := []int{}
+
+		errE := mediawiki.Process(context.Background(), &mediawiki.ProcessConfig[testNumber]{
+			URL:                    ts.URL + "/items.ndjson",
+			Client:                 client,
+			ItemsProcessingThreads: 1,
+			ProcessBatch: func(_ context.Context, batch []testNumber) errors.E {
+				mu.Lock()
+				defer mu.Unlock()
+				if len(sizes) == 0 {
+					firstBatch <- len(batch)
+				}
+				sizes = append(sizes, len(batch))
+				return nil
+			},
+			BatchSize:   100,
+			BatchLinger: 50 * time.Millisecond,
+			FileType:    mediawiki.NDJSON,
+			Compression: mediawiki.NoCompression,
+			CheckpointConfig: &mediawiki.CheckpointConfig{
+				SaveInterval:   time.Minute,
+				ItemsThreshold: 100000,
+				CheckpointFile: filepath.Join(tempDir, "linger.checkpoint.json"),
+			},
+		})
+		require.NoError(t, errE, "% -+#.1v", errE)
+		assert.Equal(t, []int{3, 1}, sizes)
+	})
+}
+
+func TestErrorPolicy(t *testing.T) {
+	t.Parallel()
+
+	tempDir := t.TempDir()
+
+	// Items 9, 19, ... cannot be decoded into testNumber.
+	var ndjson bytes.Buffer
+	for i := range 200 {
+		if i%10 == 9 {
+			fmt.Fprintf(&ndjson, `{"n":"%d"}`+"\n", i)
+		} else {
+			fmt.Fprintf(&ndjson, `{"n":%d}`+"\n", i)
+		}
+	}
+	ndjsonPath := filepath.Join(tempDir, "items.ndjson")
+	require.NoError(t, os.WriteFile(ndjsonPath, ndjson.Bytes(), 0o600))
+
+	var sql bytes.Buffer
+	sql.WriteString("CREATE TABLE `t` (\n  `n` int(10) NOT NULL\n);\n")
+	sql.WriteString("INSERT INTO `t` VALUES (0),(1),(2);\n")
+	sql.WriteString("INSERT INTO `t` VALUES (3),(4,;\n")
+	sql.WriteString("INSERT INTO `t` VALUES (5),(6),(7);\n")
+	sql.WriteString("INSERT INTO `t` VALUES (8),(NOW()),(9);\n")
+	sqlPath := filepath.Join(tempDir, "items.sql")
+	require.NoError(t, os.WriteFile(sqlPath, sql.Bytes(), 0o600))
+
+	for _, test := range []struct {
+		name      string
+		path      string
+		fileType  mediawiki.FileType
+		ordered   bool
+		failed    int
+		processed int
+	}{
+		// 20 items fail to decode (9, 19, ...), 20 items fail to process (0, 10, ...).
+		{"ndjson", ndjsonPath, mediawiki.NDJSON, false, 20 + 20, 200 - 20 - 20},
+		{"ndjson-ordered", ndjsonPath, mediawiki.NDJSON, true, 20 + 20, 200 - 20 - 20},
+		// One statement fails to parse, one item fails to decode, item 0 fails to process.
+		{"sql", sqlPath, mediawiki.SQLDump, false, 3, 7},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			config := func(policy mediawiki.ErrorPolicy, deadLetter io.Writer, processed *int64, failed *int64) *mediawiki.ProcessConfig[testNumber] {
+				return &mediawiki.ProcessConfig[testNumber]{
+					Path: test.path,
+					Process: func(_ context.Context, i testNumber) errors.E {
+						if i.N%10 == 0 {
+							return errors.New("test error")
+						}
+						atomic.AddInt64(processed, 1)
+						return nil
+					},
+					FileType:    test.fileType,
+					Compression: mediawiki.NoCompression,
+					Ordered:     test.ordered,
+					ErrorPolicy: policy,
+					DeadLetter:  deadLetter,
+					OnError: func(_ context.Context, _ mediawiki.DeadLetter) {
+						atomic.AddInt64(failed, 1)
+					},
+					CheckpointConfig: &mediawiki.CheckpointConfig{
+						SaveInterval:   time.Minute,
+						ItemsThreshold: 100000,
+						CheckpointFile: filepath.Join(tempDir, test.name+fmt.Sprintf("-%d.checkpoint.json", policy)),
+					},
+				}
+			}
+
+			var processed, failed int64
+			errE := mediawiki.Process(context.Background(), config(mediawiki.AbortOnError, nil, &processed, &failed))
+			assert.Error(t, errE)
+			assert.Equal(t, int64(0), failed)
+
+			processed, failed = 0, 0
+			errE = mediawiki.Process(context.Background(), config(mediawiki.SkipOnError, nil, &processed, &failed))
+			require.NoError(t, errE, "% -+#.1v", errE)
+			assert.Equal(t, int64(test.processed), processed)
+			assert.Equal(t, int64(test.failed), failed)
+
+			var deadLetters bytes.Buffer
+			processed, failed = 0, 0
+			errE = mediawiki.Process(context.Background(), config(mediawiki.DeadLetterOnError, &deadLetters, &processed, &failed))
+			require.NoError(t, errE, "% -+#.1v", errE)
+			assert.Equal(t, int64(test.processed), processed)
+			assert.Equal(t, int64(test.failed), failed)
+
+			records := bytes.Split(bytes.TrimSpace(deadLetters.Bytes()), []byte("\n"))
+			require.Len(t, records, test.failed)
+			stages := map[mediawiki.ErrorStage]int{}
+			for _, record := range records {
+				var deadLetter mediawiki.DeadLetter
+				require.NoError(t, json.Unmarshal(record, &deadLetter))
+				stages[deadLetter.Stage]++
+				assert.NotEmpty(t, deadLetter.Data)
+				assert.NotEmpty(t, deadLetter.Error)
+				assert.NotZero(t, deadLetter.LineNumber)
+				assert.NotContains(t, deadLetter.Details, "row")
+				if deadLetter.Format == "sql" && deadLetter.Index >= 0 {
+					// Only the failing list of values is recorded.
+					assert.Equal(t, 1, deadLetter.Index)
+					assert.Equal(t, "INSERT INTO `t` VALUES (NOW())", string(deadLetter.Data))
+				}
+			}
+			assert.Len(t, stages, 2)
+
+			// Replaying with a more lenient type succeeds for all items.
+			replayed := 0
+			errE = mediawiki.ReplayDeadLetters(context.Background(), bytes.NewReader(deadLetters.Bytes()),
+				func(_ context.Context, _ map[string]interface{}) errors.E {
+					replayed++
+					return nil
+				},
+			)
+			if test.fileType == mediawiki.SQLDump {
+				// Statement with a syntax error cannot be replayed.
+				assert.ErrorIs(t, errE, mediawiki.ErrSQLParse)
+			} else {
+				require.NoError(t, errE, "% -+#.1v", errE)
+				assert.Equal(t, test.failed, replayed)
+			}
+		})
+	}
+}
+
+func TestSharding(t *testing.T) {
+	t.Parallel()
+
+	tempDir := t.TempDir()
+
+	var ndjson bytes.Buffer
+	for i := range 1000 {
+		fmt.Fprintf(&ndjson, `{"n":%d}`+"\n", i)
+	}
+
+	var sql bytes.Buffer
+	sql.WriteString("DROP TABLE IF EXISTS `t`;\nCREATE TABLE `t` (\n  `n` int(10) NOT NULL\n);\n")
+	for i := range 50 {
+		sql.WriteString("INSERT INTO `t` VALUES ")
+		for j := range 20 {
+			if j > 0 {
+				sql.WriteString(",")
+			}
+			fmt.Fprintf(&sql, "(%d)", i*20+j)
+		}
+		sql.WriteString(";\n")
+	}
+
+	// Three files in tar, sharded together.
+	var tarArchive bytes.Buffer
+	tw := tar.NewWriter(&tarArchive)
+	for file := range 3 {
+		var data bytes.Buffer
+		for i := range 333 + file/2 {
+			fmt.Fprintf(&data, `{"n":%d}`+"\n", file*333+i)
+		}
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: fmt.Sprintf("%d.ndjson", file), Mode: 0o600, Size: int64(data.Len())}))
+		_, err := tw.Write(data.Bytes())
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+
+	const shards = 3
+
+	for _, test := range []struct {
+		name        string
+		data        []byte
+		fileType    mediawiki.FileType
+		compression mediawiki.Compression
+		ordered     bool
+	}{
+		{"items.ndjson", ndjson.Bytes(), mediawiki.NDJSON, mediawiki.NoCompression, false},
+		{"ordered.ndjson", ndjson.Bytes(), mediawiki.NDJSON, mediawiki.NoCompression, true},
+		{"items.sql", sql.Bytes(), mediawiki.SQLDump, mediawiki.NoCompression, false},
+		{"ordered.sql", sql.Bytes(), mediawiki.SQLDump, mediawiki.NoCompression, true},
+		{"items.tar", tarArchive.Bytes(), mediawiki.NDJSON, mediawiki.Tar, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			path := filepath.Join(tempDir, test.name)
+			err := os.WriteFile(path, test.data, 0o600)
+			require.NoError(t, err)
+
+			seen := map[int]int{}
+			for shard := range shards {
+				var mu sync.Mutex
+				numbers := []int{}
+
+				errE := mediawiki.Process(context.Background(), &mediawiki.ProcessConfig[testNumber]{
+					Path: path,
+					Process: func(_ context.Context, i testNumber) errors.E {
+						mu.Lock()
+						defer mu.Unlock()
+						numbers = append(numbers, i.N)
+						return nil
+					},
+					FileType:    test.fileType,
+					Compression: test.compression,
+					Ordered:     test.ordered,
+					Shard:       shard,
+					Shards:      shards,
+					CheckpointConfig: &mediawiki.CheckpointConfig{
+						SaveInterval:   time.Minute,
+						ItemsThreshold: 10,
+						CheckpointFile: path + ".checkpoint.json",
+					},
+				})
+				require.NoError(t, errE, "% -+#.1v", errE)
+				assert.NotEmpty(t, numbers)
+				if test.ordered {
+					assert.IsIncreasing(t, numbers)
+				}
+				for _, n := range numbers {
+					seen[n]++
+				}
+				assert.FileExists(t, fmt.Sprintf("%s.checkpoint.shard-%d-of-%d.json", path, shard, shards))
+			}
+
+			require.Len(t, seen, 1000)
+			for n, count := range seen {
+				assert.Equal(t, 1, count, n)
+			}
+		})
+	}
+
+	errE := mediawiki.Process(context.Background(), &mediawiki.ProcessConfig[testNumber]{
+		Path: filepath.Join(tempDir, "invalid.ndjson"),
+		Process: func(_ context.Context, _ testNumber) errors.E {
+			return nil
+		},
+		FileType:         mediawiki.NDJSON,
+		Compression:      mediawiki.NoCompression,
+		Shard:            3,
+		Shards:           3,
+		CheckpointConfig: testCheckpointConfig(),
+	})
+	assert.ErrorIs(t, errE, mediawiki.ErrInvalidValue)
+}
+
+func TestResume(t *testing.T) {
+	t.Parallel()
+
+	tempDir := t.TempDir()
+
+	var ndjson bytes.Buffer
+	for i := range 1000 {
+		fmt.Fprintf(&ndjson, `{"n":%d}`+"\n", i)
+	}
+	ndjsonPath := filepath.Join(tempDir, "items.ndjson")
+	err := os.WriteFile(ndjsonPath, ndjson.Bytes(), 0o600)
+	require.NoError(t, err)
+
+	var sql bytes.Buffer
+	sql.WriteString("CREATE TABLE `t` (\n  `n` int(10) NOT NULL\n);\n")
+	for i := range 100 {
+		sql.WriteString("INSERT INTO `t` VALUES ")
+		for j := range 10 {
+			if j > 0 {
+				sql.WriteString(",")
+			}
+			fmt.Fprintf(&sql, "(%d)", i*10+j)
+		}
+		sql.WriteString(";\n")
+	}
+	sqlPath := filepath.Join(tempDir, "items.sql")
+	err = os.WriteFile(sqlPath, sql.Bytes(), 0o600)
+	require.NoError(t, err)
+
+	for _, test := range []struct {
+		name     string
+		path     string
+		fileType mediawiki.FileType
+	}{
+		{"ndjson", ndjsonPath, mediawiki.NDJSON},
+		{"sql", sqlPath, mediawiki.SQLDump},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			checkpointFile := test.path + ".checkpoint.json"
+
+			config := func(process func(context.Context, testNumber) errors.E) *mediawiki.ProcessConfig[testNumber] {
+				return &mediawiki.ProcessConfig[testNumber]{
+					Path:                   test.path,
+					DecodingThreads:        4,
+					ItemsProcessingThreads: 8,
+					Process: func(ctx context.Context, i testNumber) errors.E {
+						// Some items are slow so that other goroutines get ahead of them.
+						if i.N%37 == 0 {
+							time.Sleep(20 * time.Millisecond)
+						}
+						return process(ctx, i)
+					},
+					FileType:    test.fileType,
+					Compression: mediawiki.NoCompression,
+					CheckpointConfig: &mediawiki.CheckpointConfig{
+						SaveInterval:   time.Minute,
+						ItemsThreshold: 1,
+						CheckpointFile: checkpointFile,
+					},
+				}
+			}
+
+			var mu sync.Mutex
+			processed := map[int]bool{}
+			killed := false
+			var checkpoint []byte
+
+			errE := mediawiki.Process(context.Background(), config(func(_ context.Context, i testNumber) errors.E {
+				mu.Lock()
+				defer mu.Unlock()
+				if killed {
+					// After the kill nothing is processed anymore.
+					return errors.New("killed")
+				}
+				processed[i.N] = true
+				if len(processed) == 300 {
+					// We remember the checkpoint as it was at the time of the kill.
+					var err error
+					checkpoint, err = os.ReadFile(checkpointFile)
+					if err != nil {
+						return errors.WithStack(err)
+					}
+					killed = true
+				}
+				return nil
+			}))
+			require.Error(t, errE)
+			require.True(t, killed)
+			require.NotEmpty(t, checkpoint)
+
+			// Checkpoint saved after the kill would not exist if the process was really killed.
+			err := os.WriteFile(checkpointFile, checkpoint, 0o600)
+			require.NoError(t, err)
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			defer cancel()
+
+			resumed := map[int]bool{}
+			errE = mediawiki.Process(ctx, config(func(_ context.Context, i testNumber) errors.E {
+				mu.Lock()
+				defer mu.Unlock()
+				resumed[i.N] = true
+				return nil
+			}))
+			require.NoError(t, errE, "% -+#.1v", errE)
+
+			// Resumed run skipped some items.
+			assert.Less(t, len(resumed), 1000)
+			for n := range 1000 {
+				assert.True(t, processed[n] || resumed[n], n)
+			}
+		})
+	}
+}
+
+func TestCheckpointConflict(t *testing.T) {
+	t.Parallel()
+
+	var ndjson bytes.Buffer
+	for i := range 1000 {
+		fmt.Fprintf(&ndjson, `{"n":%d}`+"\n", i)
+	}
+	path := filepath.Join(t.TempDir(), "items.ndjson")
+	err := os.WriteFile(path, ndjson.Bytes(), 0o600)
+	require.NoError(t, err)
+
+	store := mediawiki.NewMemoryCheckpointStore()
+	var processed atomic.Int64
+	errE := mediawiki.Process(context.Background(), &mediawiki.ProcessConfig[testNumber]{
+		Path: path,
+		Process: func(_ context.Context, i testNumber) errors.E {
+			if i.N == 100 {
+				// Somebody else changes the stored checkpoint.
+				errE := store.Save(&mediawiki.Checkpoint{ProcessedPosition: 10})
+				if errE != nil {
+					return errE
+				}
+			}
+			processed.Add(1)
+			return nil
+		},
+		FileType:    mediawiki.NDJSON,
+		Compression: mediawiki.NoCompression,
+		CheckpointConfig: &mediawiki.CheckpointConfig{
+			SaveInterval:   time.Minute,
+			ItemsThreshold: 1,
+			Store:          store,
+		},
+	})
+	assert.ErrorIs(t, errE, mediawiki.ErrCheckpointConflict)
+	assert.Less(t, processed.Load(), int64(1000))
+
+	// The checkpoint changed by somebody else is not overwritten when Process returns.
+	checkpoint, errE := store.Load()
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, 10, checkpoint.ProcessedPosition)
+}
+
+func TestCheckpointSavedOnReturn(t *testing.T) {
+	t.Parallel()
+
+	var ndjson bytes.Buffer
+	for i := range 1000 {
+		fmt.Fprintf(&ndjson, `{"n":%d}`+"\n", i)
+	}
+	path := filepath.Join(t.TempDir(), "items.ndjson")
+	err := os.WriteFile(path, ndjson.Bytes(), 0o600)
+	require.NoError(t, err)
+
+	store := mediawiki.NewMemoryCheckpointStore()
+	errE := mediawiki.Process(context.Background(), &mediawiki.ProcessConfig[testNumber]{
+		Path: path,
+		Process: func(_ context.Context, i testNumber) errors.E {
+			if i.N == 500 {
+				return errors.New("stop")
+			}
+			return nil
+		},
+		FileType:               mediawiki.NDJSON,
+		Compression:            mediawiki.NoCompression,
+		Ordered:                true,
+		ItemsProcessingThreads: 1,
+		CheckpointConfig: &mediawiki.CheckpointConfig{
+			// Neither is reached, so the checkpoint is saved only when Process returns.
+			SaveInterval:   time.Hour,
+			ItemsThreshold: 1000000,
+			Store:          store,
+		},
+	})
+	require.Error(t, errE)
+
+	checkpoint, errE := store.Load()
+	require.NoError(t, errE, "% -+#.1v", errE)
+	require.NotNil(t, checkpoint)
+	assert.Equal(t, 500, checkpoint.ProcessedPosition)
+}
+
+func TestCheckpointLoadError(t *testing.T) {
+	t.Parallel()
+
+	tempDir := t.TempDir()
+	path := filepath.Join(tempDir, "items.ndjson")
+	err := os.WriteFile(path, []byte(`{"n":0}`+"\n"), 0o600)
+	require.NoError(t, err)
+	checkpointFile := filepath.Join(tempDir, "checkpoint.json")
+	err = os.WriteFile(checkpointFile, []byte("{invalid"), 0o600)
+	require.NoError(t, err)
+
+	errE := mediawiki.Process(context.Background(), &mediawiki.ProcessConfig[testNumber]{
+		Path: path,
+		Process: func(_ context.Context, _ testNumber) errors.E {
+			return nil
+		},
+		FileType:    mediawiki.NDJSON,
+		Compression: mediawiki.NoCompression,
+		CheckpointConfig: &mediawiki.CheckpointConfig{
+			SaveInterval:   time.Minute,
+			ItemsThreshold: 1,
+			CheckpointFile: checkpointFile,
+		},
+	})
+	require.Error(t, errE)
+	assert.Equal(t, checkpointFile, errors.Details(errE)["checkpointFile"])
+}
+
+func TestResumeOffset(t *testing.T) { //nolint:maintidx
+	t.Parallel()
+
+	tempDir := t.TempDir()
+
+	const total = 1000
+	const resumeAt = 500
+	const rowsPerFile = 250
+
+	var ndjson, jsonArray, xmlDump bytes.Buffer
+	var ndjsonOffset, jsonArrayOffset, xmlOffset int64
+	jsonArray.WriteString("[\n")
+	xmlDump.WriteString("<mediawiki>\n  <siteinfo>\n  </siteinfo>\n")
+	for i := range total {
+		if i > 0 {
+			jsonArray.WriteString(",\n")
+		}
+		fmt.Fprintf(&ndjson, `{"n":%d}`+"\n", i)
+		fmt.Fprintf(&jsonArray, `{"n":%d}`, i)
+		fmt.Fprintf(&xmlDump, "  <page>\n    <n>%d</n>\n  </page>\n", i)
+		if i == resumeAt-1 {
+			// Offsets just after the row.
+			ndjsonOffset = int64(ndjson.Len()) - 1
+			jsonArrayOffset = int64(jsonArray.Len())
+			xmlOffset = int64(xmlDump.Len())
+		}
+	}
+	jsonArray.WriteString("\n]\n")
+	xmlDump.WriteString("</mediawiki>\n")
+
+	// A tar archive with rowsPerFile rows in each file.
+	var tarArchive bytes.Buffer
+	tw := tar.NewWriter(&tarArchive)
+	for file := range total / rowsPerFile {
+		var data bytes.Buffer
+		for i := file * rowsPerFile; i < (file+1)*rowsPerFile; i++ {
+			fmt.Fprintf(&data, `{"n":%d}`+"\n", i)
+		}
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: fmt.Sprintf("%d.ndjson", file), Mode: 0o600, Size: int64(data.Len())}))
+		_, err := tw.Write(data.Bytes())
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+
+	// Two bzip2 streams with three blocks each and 18000 rows each (compressed with "bzip2 -1").
+	numbers, err := os.ReadFile("testdata/numbers.ndjson.bz2")
+	require.NoError(t, err)
+
+	// FileServer supports Range requests.
+	ts := httptest.NewServer(http.FileServer(http.Dir(tempDir)))
+	t.Cleanup(ts.Close)
+
+	client := retryablehttp.NewClient()
+	client.Logger = nil
+
+	for _, test := range []struct {
+		name        string
+		data        []byte
+		total       int
+		resumeAt    int
+		offset      int64
+		lastOffset  int64
+		fileType    mediawiki.FileType
+		compression mediawiki.Compression
+		restart     bool
+		url         bool
+	}{
+		{"items.ndjson", ndjson.Bytes(), total, resumeAt, ndjsonOffset, int64(ndjson.Len()) - 1, mediawiki.NDJSON, mediawiki.NoCompression, false, false},
+		{"items.json", jsonArray.Bytes(), total, resumeAt, jsonArrayOffset, int64(jsonArray.Len()) - 3, mediawiki.JSONArray, mediawiki.NoCompression, false, false},
+		{"items.xml", xmlDump.Bytes(), total, resumeAt, xmlOffset, int64(xmlDump.Len()) - int64(len("</mediawiki>\n")), mediawiki.XML, mediawiki.NoCompression, false, false},
+		{"items.ndjson.gz", gzipMembers(t, ndjson.Bytes(), 1000), total, resumeAt, ndjsonOffset, int64(ndjson.Len()) - 1, mediawiki.NDJSON, mediawiki.GZIP, true, false},
+		{"items.json.gz", gzipMembers(t, jsonArray.Bytes(), 1000), total, resumeAt, jsonArrayOffset, int64(jsonArray.Len()) - 3, mediawiki.JSONArray, mediawiki.GZIP, true, false},
+		{"items.ndjson.bz2", numbers, 36000, 12000, -1, -1, mediawiki.NDJSON, mediawiki.BZIP2, true, false},
+		{"items.tar", tarArchive.Bytes(), total, resumeAt, rowsPerFile*int64(len(`{"n":000}`)+1) - 1, rowsPerFile*int64(len(`{"n":000}`)+1) - 1, mediawiki.NDJSON, mediawiki.Tar, true, false},
+		{"items.tar.gz", gzipMembers(t, tarArchive.Bytes(), 1024), total, resumeAt, rowsPerFile*int64(len(`{"n":000}`)+1) - 1, rowsPerFile*int64(len(`{"n":000}`)+1) - 1, mediawiki.NDJSON, mediawiki.GZIPTar, true, false},
+		// Offsets are not recorded nor used when compression is detected.
+		{"auto.json.gz", gzipMembers(t, jsonArray.Bytes(), 1000), total, resumeAt, 0, 0, mediawiki.JSONArray, mediawiki.AutoCompression, false, false},
+		// Zstd files are always read from the start.
+		{"items.ndjson.zst", compress(t, ndjson.Bytes(), newZSTDWriter), total, resumeAt, 0, 0, mediawiki.NDJSON, mediawiki.ZSTD, false, false},
+		{"url.ndjson", ndjson.Bytes(), total, resumeAt, ndjsonOffset, int64(ndjson.Len()) - 1, mediawiki.NDJSON, mediawiki.NoCompression, false, true},
+		{"url.ndjson.bz2", numbers, 36000, 30000, -1, -1, mediawiki.NDJSON, mediawiki.BZIP2, true, true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			path := filepath.Join(tempDir, test.name)
+			store := mediawiki.NewMemoryCheckpointStore()
+
+			config := func(process func(context.Context, testNumber) errors.E) *mediawiki.ProcessConfig[testNumber] {
+				c := &mediawiki.ProcessConfig[testNumber]{
+					Path:        path,
+					Process:     process,
+					FileType:    test.fileType,
+					Compression: test.compression,
+					CheckpointConfig: &mediawiki.CheckpointConfig{
+						SaveInterval:   time.Minute,
+						ItemsThreshold: 1,
+						Store:          store,
+					},
+				}
+				if test.url {
+					c.URL = ts.URL + "/" + test.name
+					c.Path = ""
+					c.Client = client
+				}
+				return c
+			}
+
+			readCheckpoint := func() *mediawiki.Checkpoint {
+				checkpoint, errE := store.Load()
+				require.NoError(t, errE, "% -+#.1v", errE)
+				require.NotNil(t, checkpoint)
+				return checkpoint
+			}
+
+			err := os.WriteFile(path, test.data, 0o600)
+			require.NoError(t, err)
+
+			// A full run records the offset after the last row.
+			errE := mediawiki.Process(context.Background(), config(func(_ context.Context, _ testNumber) errors.E {
+				return nil
+			}))
+			require.NoError(t, errE, "% -+#.1v", errE)
+			checkpoint := readCheckpoint()
+			assert.Equal(t, test.total, checkpoint.ProcessedPosition)
+			if test.lastOffset >= 0 {
+				assert.Equal(t, test.lastOffset, checkpoint.Offset)
+			}
+			assert.Equal(t, test.restart, checkpoint.Restart != nil)
+			store = mediawiki.NewMemoryCheckpointStore()
+
+			// A run which stops after the row resumeAt records where to resume.
+			c := config(func(_ context.Context, i testNumber) errors.E {
+				if i.N == test.resumeAt {
+					return errors.New("stop")
+				}
+				return nil
+			})
+			c.Ordered = true
+			c.ItemsProcessingThreads = 1
+			errE = mediawiki.Process(context.Background(), c)
+			require.Error(t, errE)
+			checkpoint = readCheckpoint()
+			require.Equal(t, test.resumeAt, checkpoint.ProcessedPosition)
+			if test.offset >= 0 {
+				assert.Equal(t, test.offset, checkpoint.Offset)
+			}
+			offset := checkpoint.Offset
+			if test.restart {
+				require.NotNil(t, checkpoint.Restart)
+				// Reading restarts in the middle of the file.
+				assert.Positive(t, checkpoint.Restart.Offset)
+				offset = checkpoint.Restart.Offset
+			} else {
+				assert.Nil(t, checkpoint.Restart)
+			}
+
+			// Data before the offset is corrupted, so resuming works only if it is not read.
+			// Without the offset, already processed rows are read and skipped.
+			// The checkpoint is not bound to the dump, which changes.
+			err = os.WriteFile(path, corrupt(test.data, offset), 0o600)
+			require.NoError(t, err)
+			checkpoint.Dump = nil
+			errE = store.Save(checkpoint)
+			require.NoError(t, errE, "% -+#.1v", errE)
+
+			var mu sync.Mutex
+			numbers := map[int]bool{}
+			errE = mediawiki.Process(context.Background(), config(func(_ context.Context, i testNumber) errors.E {
+				mu.Lock()
+				defer mu.Unlock()
+				numbers[i.N] = true
+				return nil
+			}))
+			require.NoError(t, errE, "% -+#.1v", errE)
+			require.Len(t, numbers, test.total-test.resumeAt)
+			for n := test.resumeAt; n < test.total; n++ {
+				assert.True(t, numbers[n], n)
+			}
+		})
+	}
+}
+
+// gzipMembers compresses data into gzip members with size bytes of data each.
+func gzipMembers(t *testing.T, data []byte, size int) []byte {
+	t.Helper()
+
+	var buffer bytes.Buffer
+	for len(data) > 0 {
+		member := data[:min(size, len(data))]
+		data = data[len(member):]
+		w := gzip.NewWriter(&buffer)
+		_, err := w.Write(member)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+	}
+	return buffer.Bytes()
+}
+
+// compress compresses data with the writer.
+func compress(t *testing.T, data []byte, newWriter func(io.Writer) (io.WriteCloser, error)) []byte {
+	t.Helper()
+
+	var buffer bytes.Buffer
+	w, err := newWriter(&buffer)
+	require.NoError(t, err)
+	_, err = w.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buffer.Bytes()
+}
+
+// testCheckpointConfig returns a checkpoint configuration which keeps the checkpoint in memory,
+// so that tests do not store checkpoints in the working directory and resume from them.
+func testCheckpointConfig() *mediawiki.CheckpointConfig {
+	return &mediawiki.CheckpointConfig{
+		SaveInterval:   time.Minute,
+		ItemsThreshold: 1000,
+		Store:          mediawiki.NewMemoryCheckpointStore(),
+	}
+}
+
+// corrupt replaces all data before offset with invalid data.
+func corrupt(data []byte, offset int64) []byte {
+	c := bytes.Clone(data)
+	for i := range offset {
+		c[i] = '!'
+	}
+	return c
+}
+
+func newGzipWriter(w io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriter(w), nil
+}
+
+func newZSTDWriter(w io.Writer) (io.WriteCloser, error) {
+	return zstd.NewWriter(w)
+}
+
+func newXZWriter(w io.Writer) (io.WriteCloser, error) {
+	return xz.NewWriter(w)
+}
+
+func TestLogger(t *testing.T) {
+	t.Parallel()
+
+	tempDir := t.TempDir()
+
+	var ndjson bytes.Buffer
+	for i := range 100 {
+		fmt.Fprintf(&ndjson, `{"n":%d}`+"\n", i)
+	}
+	path := filepath.Join(tempDir, "items.ndjson")
+	err := os.WriteFile(path, ndjson.Bytes(), 0o600)
+	require.NoError(t, err)
+
+	store := mediawiki.NewMemoryCheckpointStore()
+	var output bytes.Buffer
+	var mu sync.Mutex
+	logger := slog.New(slog.NewJSONHandler(&lockedWriter{w: &output, mu: &mu}, nil))
+
+	run := func(count int) {
+		processed := 0
+		errE := mediawiki.Process(context.Background(), &mediawiki.ProcessConfig[testNumber]{
+			Path:                   path,
+			FileType:               mediawiki.NDJSON,
+			Compression:            mediawiki.NoCompression,
+			ItemsProcessingThreads: 1,
+			ErrorPolicy:            mediawiki.SkipOnError,
+			Logger:                 logger,
+			Process: func(_ context.Context, i testNumber) errors.E {
+				processed++
+				if i.N == 42 {
+					return errors.New("failed")
+				}
+				if processed == count {
+					return errors.WithStack(context.Canceled)
+				}
+				return nil
+			},
+			CheckpointConfig: &mediawiki.CheckpointConfig{
+				SaveInterval:   time.Minute,
+				ItemsThreshold: 1,
+				Store:          store,
+			},
+		})
+		if count > 0 {
+			assert.ErrorIs(t, errE, context.Canceled)
+		} else {
+			require.NoError(t, errE, "% -+#.1v", errE)
+		}
+	}
+
+	run(50)
+	run(0)
+
+	events := []map[string]any{}
+	mu.Lock()
+	decoder := json.NewDecoder(&output)
+	for decoder.More() {
+		var event map[string]any
+		err := decoder.Decode(&event)
+		require.NoError(t, err)
+		events = append(events, event)
+	}
+	mu.Unlock()
+
+	messages := []string{}
+	for _, event := range events {
+		messages = append(messages, event["msg"].(string)) //nolint:forcetypeassert
+		assert.Equal(t, path, event["path"])
+		switch event["msg"] {
+		case "skipping item":
+			assert.Equal(t, "WARN", event["level"])
+			assert.Equal(t, "process", event["stage"])
+			assert.Equal(t, float64(43), event["line"])
+			// Errors are logged with their stack trace.
+			assert.Equal(t, "failed", event["error"].(map[string]any)["error"]) //nolint:forcetypeassert
+		case "resuming from checkpoint":
+			assert.Equal(t, "INFO", event["level"])
+			assert.Equal(t, float64(49), event["line"])
+		}
+	}
+	assert.Equal(t, []string{"skipping item", "loaded checkpoint", "resuming from checkpoint"}, messages)
+}
+
+type lockedWriter struct {
+	w  io.Writer
+	mu *sync.Mutex
+}
+
+func (w *lockedWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.Write(p) //nolint:wrapcheck
+}
+
 func TestSQLDump(t *testing.T) {
 	t.Parallel()
 
@@ -111,8 +1295,9 @@ func TestSQLDump(t *testing.T) {
 			atomic.AddInt64(&itemCounter, int64(1))
 			return nil
 		},
-		FileType:    mediawiki.SQLDump,
-		Compression: mediawiki.GZIP,
+		FileType:         mediawiki.SQLDump,
+		Compression:      mediawiki.GZIP,
+		CheckpointConfig: testCheckpointConfig(),
 	})
 	require.NoError(t, err, "% -+#.1v", err)
 	assert.Equal(t, int64(9057), itemCounter)
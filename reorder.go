@@ -0,0 +1,81 @@
+package mediawiki
+
+import (
+	"context"
+	"sync"
+
+	"gitlab.com/tozd/go/errors"
+)
+
+type windowRow struct {
+	lineNumber int
+	size       int
+}
+
+// reorderWindow bounds the total size of rows which are read ahead of the oldest row
+// not yet passed on in ordered mode. It also remembers the order in which rows were read.
+type reorderWindow struct {
+	size int
+	mu   sync.Mutex
+	used int
+	rows []windowRow
+	// freed is signaled when space in the window is freed.
+	freed chan struct{}
+}
+
+func newReorderWindow(size int) *reorderWindow {
+	return &reorderWindow{
+		size:  size,
+		mu:    sync.Mutex{},
+		used:  0,
+		rows:  nil,
+		freed: make(chan struct{}, 1),
+	}
+}
+
+// add waits until there is space in the window for the row and then adds it.
+// A row larger than the whole window is added once the window is empty.
+func (w *reorderWindow) add(ctx context.Context, lineNumber, size int) errors.E {
+	size = min(size, w.size)
+	for {
+		w.mu.Lock()
+		if w.used+size <= w.size {
+			w.used += size
+			w.rows = append(w.rows, windowRow{lineNumber: lineNumber, size: size})
+			w.mu.Unlock()
+			return nil
+		}
+		w.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return errors.WithStack(ctx.Err())
+		case <-w.freed:
+		}
+	}
+}
+
+// next returns the line number of the oldest row in the window.
+// It returns false if the window is empty.
+func (w *reorderWindow) next() (int, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.rows) == 0 {
+		return 0, false
+	}
+	return w.rows[0].lineNumber, true
+}
+
+// remove removes the oldest row from the window and frees its space.
+func (w *reorderWindow) remove() {
+	w.mu.Lock()
+	w.used -= w.rows[0].size
+	w.rows = w.rows[1:]
+	w.mu.Unlock()
+
+	select {
+	case w.freed <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,60 @@
+package mediawiki
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReorderWindow(t *testing.T) {
+	t.Parallel()
+
+	window := newReorderWindow(100)
+
+	errE := window.add(context.Background(), 1, 60)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	errE = window.add(context.Background(), 2, 40)
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	// The window is full.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	errE = window.add(ctx, 3, 1)
+	assert.ErrorIs(t, errE, context.DeadlineExceeded)
+
+	added := make(chan struct{})
+	go func() {
+		defer close(added)
+		// A row larger than the window waits until the window is empty.
+		errE := window.add(context.Background(), 3, 1000)
+		assert.NoError(t, errE, "% -+#.1v", errE)
+	}()
+
+	next, ok := window.next()
+	assert.True(t, ok)
+	assert.Equal(t, 1, next)
+	window.remove()
+
+	select {
+	case <-added:
+		assert.Fail(t, "row added before the window was empty")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	next, ok = window.next()
+	assert.True(t, ok)
+	assert.Equal(t, 2, next)
+	window.remove()
+
+	<-added
+	next, ok = window.next()
+	assert.True(t, ok)
+	assert.Equal(t, 3, next)
+	window.remove()
+
+	_, ok = window.next()
+	assert.False(t, ok)
+}
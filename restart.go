@@ -0,0 +1,421 @@
+package mediawiki
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/cosnicolaou/pbzip2"
+	gzip "github.com/klauspost/pgzip"
+	"gitlab.com/tozd/go/errors"
+)
+
+const (
+	// bzip2MagicBits is the size of the block magic and of the end of stream magic.
+	bzip2MagicBits = 48
+	// bzip2HeaderBits is the size of the stream header.
+	bzip2HeaderBits = 32
+	// bzip2TrailerBits is the size of the end of stream magic and the stream CRC.
+	bzip2TrailerBits = bzip2MagicBits + 32
+	// bzip2LevelSize is the block size of bzip2 compression level 1.
+	bzip2LevelSize = 100 * 1000
+	// bzip2RestartSlack is how many bytes before the estimated start of a block reading restarts.
+	bzip2RestartSlack = 16
+	// tarBlockSize is the size of tar blocks to which file data is padded.
+	tarBlockSize = 512
+)
+
+// Restart is a position in a compressed file or a tar archive at which reading can restart,
+// when resuming from a checkpoint.
+//
+// Offset is the byte offset in the file at which decompression restarts, producing
+// the decompressed data from the byte offset Decompressed on. For gzip, Offset is the start
+// of a gzip member. For bzip2, Offset is at or just before the start of a bzip2 block
+// with the CRC and compression Level.
+//
+// For tar archives, Entry is the offset in the decompressed data of the header of
+// the archive member in which reading continues and Checkpoint.Offset is relative to
+// the start of the member's data.
+type Restart struct {
+	Offset       int64  `json:"offset"`
+	Decompressed int64  `json:"decompressed"`
+	CRC          uint32 `json:"crc,omitempty"`
+	Level        int    `json:"level,omitempty"`
+	Entry        int64  `json:"entry,omitempty"`
+}
+
+// restartableCompression returns true if reading can restart in the middle of files with the compression.
+//
+// zstd and xz files are always read from the start.
+func restartableCompression(compression Compression) bool {
+	switch compression.withoutTar() { //nolint:exhaustive
+	case NoCompression, GZIP, BZIP2:
+		return true
+	default:
+		return false
+	}
+}
+
+// restartPoints are positions at which decompression can restart, in order.
+type restartPoints struct {
+	mu     sync.Mutex
+	points []*Restart
+}
+
+func (r *restartPoints) add(restart *Restart) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.points = append(r.points, restart)
+}
+
+// last returns the last restart point at or before the decompressed offset, or nil if there is none.
+//
+// Offsets passed to last have to be increasing, because earlier restart points are forgotten.
+func (r *restartPoints) last(decompressed int64) *Restart {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	i := len(r.points) - 1
+	for i >= 0 && r.points[i].Decompressed > decompressed {
+		i--
+	}
+	if i < 0 {
+		return nil
+	}
+	r.points = r.points[i:]
+	return r.points[0]
+}
+
+// countingByteReader counts bytes read from a bufio.Reader. It implements io.ByteReader
+// so that decompressors do not read more than they need.
+type countingByteReader struct {
+	reader *bufio.Reader
+	count  atomic.Int64
+}
+
+func (c *countingByteReader) Read(p []byte) (int, error) {
+	n, err := c.reader.Read(p)
+	c.count.Add(int64(n))
+	return n, err //nolint:wrapcheck
+}
+
+func (c *countingByteReader) ReadByte() (byte, error) {
+	b, err := c.reader.ReadByte()
+	if err == nil {
+		c.count.Add(1)
+	}
+	return b, err //nolint:wrapcheck
+}
+
+// gzipMembersReader decompresses gzip members one after the other and records
+// the start of every member as a restart point.
+type gzipMembersReader struct {
+	reader       *countingByteReader
+	gzip         *gzip.Reader
+	offset       int64
+	decompressed int64
+	points       *restartPoints
+	// eof is true after the last member.
+	eof bool
+}
+
+// newGzipMembersReader returns a reader which decompresses reader, whose first byte is at offset
+// in the file and at decompressed in the decompressed data. A gzip member has to start there.
+// If points is not nil, restart points are added to it.
+func newGzipMembersReader(reader *bufio.Reader, offset, decompressed int64, points *restartPoints) (*gzipMembersReader, errors.E) {
+	r := &gzipMembersReader{
+		reader:       &countingByteReader{reader: reader, count: atomic.Int64{}},
+		gzip:         nil,
+		offset:       offset,
+		decompressed: decompressed,
+		points:       points,
+		eof:          false,
+	}
+	gzipReader, err := gzip.NewReader(r.reader)
+	if err != nil {
+		return nil, errors.WithMessage(err, "new gzip reader")
+	}
+	gzipReader.Multistream(false)
+	r.gzip = gzipReader
+	r.addPoint(offset)
+	return r, nil
+}
+
+func (r *gzipMembersReader) addPoint(offset int64) {
+	if r.points != nil {
+		r.points.add(&Restart{
+			Offset:       offset,
+			Decompressed: r.decompressed,
+			CRC:          0,
+			Level:        0,
+			Entry:        0,
+		})
+	}
+}
+
+func (r *gzipMembersReader) Read(p []byte) (int, error) {
+	if r.eof {
+		return 0, io.EOF
+	}
+	for {
+		n, err := r.gzip.Read(p)
+		r.decompressed += int64(n)
+		if !errors.Is(err, io.EOF) {
+			return n, err //nolint:wrapcheck
+		}
+		// The member ended, the next one (if any) starts here.
+		offset := r.offset + r.reader.count.Load()
+		err = r.gzip.Reset(r.reader)
+		if errors.Is(err, io.EOF) {
+			r.eof = true
+			return n, io.EOF
+		} else if err != nil {
+			return n, err //nolint:wrapcheck
+		}
+		r.gzip.Multistream(false)
+		r.addPoint(offset)
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+func (r *gzipMembersReader) Close() error {
+	return r.gzip.Close() //nolint:wrapcheck
+}
+
+// bzip2Block is a bzip2 block passed to the decompressor.
+type bzip2Block struct {
+	offset int64
+	crc    uint32
+	level  int
+	size   int
+}
+
+// bzip2BlocksReader decompresses bzip2 blocks in parallel (like pbzip2.NewReader) and
+// records the start of every block as a restart point.
+//
+// Starts of blocks in the file are not known exactly, because the scanner does not expose them,
+// so they are estimated from sizes of blocks and headers and restart points use an offset
+// at or before the start of the block, with its CRC used to find it when restarting.
+type bzip2BlocksReader struct {
+	ctx   context.Context //nolint:containedctx
+	errCh chan error
+	wg    sync.WaitGroup
+	dc    *pbzip2.Decompressor
+
+	mu     sync.Mutex
+	points *restartPoints
+	// blocks are blocks passed to the decompressor which have not yet been decompressed.
+	blocks map[uint64]bzip2Block
+	// order is the order of the next block to be decompressed.
+	order uint64
+	// pointed is the order of the last block added as a restart point.
+	pointed uint64
+	// decompressed is the offset in the decompressed data at which the block with order starts.
+	decompressed int64
+}
+
+// newBzip2BlocksReader returns a reader which decompresses reader. If restart is not nil,
+// reader starts at restart.Offset in the file and decompression restarts at the block
+// from restart, otherwise reader starts at the start of the file.
+// If points is not nil, restart points are added to it.
+func newBzip2BlocksReader(
+	ctx context.Context, reader io.Reader, threads int, restart *Restart, points *restartPoints,
+) *bzip2BlocksReader {
+	decompressed := int64(0)
+	if restart != nil {
+		decompressed = restart.Decompressed
+		// The scanner expects a stream header.
+		reader = io.MultiReader(strings.NewReader(fmt.Sprintf("BZh%d", restart.Level)), reader)
+	}
+	options := []pbzip2.DecompressorOption{pbzip2.BZConcurrency(threads)}
+	var progress chan pbzip2.Progress
+	if points != nil {
+		progress = make(chan pbzip2.Progress)
+		options = append(options, pbzip2.BZSendUpdates(progress))
+	}
+	r := &bzip2BlocksReader{
+		ctx:          ctx,
+		errCh:        make(chan error, 1),
+		wg:           sync.WaitGroup{},
+		dc:           pbzip2.NewDecompressor(ctx, options...),
+		mu:           sync.Mutex{},
+		points:       points,
+		blocks:       map[uint64]bzip2Block{},
+		order:        1,
+		pointed:      0,
+		decompressed: decompressed,
+	}
+
+	done := make(chan struct{})
+	if progress != nil {
+		go func() {
+			for {
+				select {
+				case p := <-progress:
+					r.blockDecompressed(p)
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		defer close(r.errCh)
+		defer close(done)
+		err := r.scan(ctx, pbzip2.NewScanner(reader), restart)
+		if err != nil {
+			r.dc.Cancel(err)
+			_ = r.dc.Finish()
+			r.errCh <- err
+			return
+		}
+		r.errCh <- r.dc.Finish()
+	}()
+
+	return r
+}
+
+// scan passes blocks from the scanner to the decompressor.
+func (r *bzip2BlocksReader) scan(ctx context.Context, scanner *pbzip2.Scanner, restart *Restart) error {
+	order := uint64(0)
+	// position is the (estimated) start of the current block in bits.
+	position := int64(bzip2HeaderBits + bzip2MagicBits)
+	restarting := restart != nil
+	if restarting {
+		position = 8*restart.Offset + bzip2MagicBits //nolint:mnd
+	}
+	// When restarting in the middle of a stream, the stream CRC cannot be computed from
+	// all blocks of the stream, so we make it match what the decompressor computes.
+	partialStream := restarting
+	streamCRC := uint32(0)
+	for scanner.Scan(ctx) {
+		block := scanner.Block()
+		if restarting {
+			// We skip data before the block at which we restart.
+			if len(block.Data) == 0 || block.CRC != restart.CRC {
+				continue
+			}
+			restarting = false
+		}
+		if partialStream {
+			streamCRC = (streamCRC<<1 | streamCRC>>31) ^ block.CRC //nolint:mnd
+			if block.EOS {
+				block.StreamCRC = streamCRC
+				partialStream = false
+			}
+		}
+		order++
+		if r.points != nil {
+			offset := max((position-bzip2MagicBits)/8-bzip2RestartSlack, 0) //nolint:mnd
+			if order == 1 && restart != nil {
+				offset = restart.Offset
+			}
+			r.blockScanned(order, bzip2Block{
+				offset: offset,
+				crc:    block.CRC,
+				level:  block.StreamBlockSize / bzip2LevelSize,
+				size:   len(block.Data),
+			})
+		}
+		err := r.dc.Append(block)
+		if err != nil {
+			return err //nolint:wrapcheck
+		}
+		position += int64(block.SizeInBits) + bzip2MagicBits
+		if block.EOS {
+			// The stream trailer and the header of the next stream.
+			position += bzip2TrailerBits + bzip2HeaderBits
+		}
+	}
+	err := scanner.Err()
+	if err == nil && restarting {
+		err = errors.New("bzip2 block to restart at not found")
+	}
+	return err //nolint:wrapcheck
+}
+
+func (r *bzip2BlocksReader) blockScanned(order uint64, block bzip2Block) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.blocks[order] = block
+	r.addPoint()
+}
+
+func (r *bzip2BlocksReader) blockDecompressed(progress pbzip2.Progress) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	block := r.blocks[progress.Block]
+	delete(r.blocks, progress.Block)
+	r.order = progress.Block + 1
+	r.decompressed += int64(progress.Size)
+	if progress.Compressed != block.size {
+		// The decompressor merged the block with the next one (the scanner split
+		// the block at a false block magic), so the next block is not a block.
+		delete(r.blocks, r.order)
+		r.order++
+	}
+	r.addPoint()
+}
+
+// addPoint adds the restart point for the next block to be decompressed, once
+// both its start in the file and in the decompressed data are known.
+func (r *bzip2BlocksReader) addPoint() {
+	if r.pointed >= r.order {
+		return
+	}
+	block, ok := r.blocks[r.order]
+	if !ok {
+		return
+	}
+	r.pointed = r.order
+	r.points.add(&Restart{
+		Offset:       block.offset,
+		Decompressed: r.decompressed,
+		CRC:          block.crc,
+		Level:        block.level,
+		Entry:        0,
+	})
+}
+
+// Read implements io.Reader.
+func (r *bzip2BlocksReader) Read(p []byte) (int, error) {
+	select {
+	case err := <-r.errCh:
+		if err != nil {
+			r.dc.Cancel(err)
+			r.wg.Wait()
+			return 0, err
+		}
+	case <-r.ctx.Done():
+		err := r.ctx.Err()
+		r.dc.Cancel(err)
+		r.wg.Wait()
+		return 0, err //nolint:wrapcheck
+	default:
+	}
+	n, err := r.dc.Read(p)
+	if err == nil {
+		return n, nil
+	}
+	r.wg.Wait()
+	// Errors sent after the decompressor is done (e.g., a CRC error).
+	cerr, ok := <-r.errCh
+	if ok && cerr != nil && errors.Is(err, io.EOF) {
+		return n, cerr
+	}
+	return n, err //nolint:wrapcheck
+}
+
+// tarHeaderOffset returns the offset of the tar header after the data of a member which starts at
+// offset and has size.
+func tarHeaderOffset(offset, size int64) int64 {
+	return offset + (size+tarBlockSize-1)/tarBlockSize*tarBlockSize
+}
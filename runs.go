@@ -0,0 +1,273 @@
+package mediawiki
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/hashicorp/go-retryablehttp"
+	"gitlab.com/tozd/go/errors"
+)
+
+// DumpRun is a run of a dump.
+//
+// URL is the URL of the dump file in the run.
+type DumpRun struct {
+	Date time.Time
+	URL  string
+}
+
+// Dump describes where runs of a dump are published, how its file in a run is named,
+// and the format of the file. Use CatalogDump or functions like WikidataEntitiesDump
+// and WikipediaPagesArticlesDump to obtain it.
+//
+// For most dumps the file is available in a run only when the job producing it has completed,
+// based on run's dumpstatus.json. For other dumps (e.g., entities dumps) which do not publish
+// dumpstatus.json, the file is available in a run if it exists, which is checked with a HEAD
+// request for every run considered.
+//
+// For dumps which publish dumpstatus.json, Latest uses index.json of the dumps site, which
+// contains dumpstatus.json of the latest run of every wiki. Only if index.json cannot be
+// fetched or the file is not available in the latest run (e.g., the job producing it is
+// still in progress), Latest falls back to enumerating runs. Runs are enumerated by scraping
+// links from the HTML directory listing of runs (there is no machine-readable index of all
+// runs), so enumeration depends on the format of the listing.
+type Dump struct {
+	// runsURL is the URL of the directory listing of runs.
+	runsURL string
+	// fileFormat is the format of the file name with %s placeholders for the wiki and the date
+	// (if wiki is set), or the format of the file URL with two %s placeholders for the date.
+	fileFormat string
+	// baseURL and wiki are set for dumps which publish dumpstatus.json.
+	baseURL string
+	wiki    string
+	format  DumpFormat
+}
+
+// newStatusDump returns a Dump of the wiki which publishes dumpstatus.json.
+// fileFormat is the file name with two %s placeholders for the wiki and the date.
+func newStatusDump(baseURL, wiki, fileFormat string, format DumpFormat) Dump {
+	return Dump{
+		runsURL:    fmt.Sprintf("%s/%s/", baseURL, wiki),
+		fileFormat: fileFormat,
+		baseURL:    baseURL,
+		wiki:       wiki,
+		format:     format,
+	}
+}
+
+// newListingDump returns a Dump which does not publish dumpstatus.json.
+// fileFormat is the file URL with two %s placeholders for the date.
+func newListingDump(runsURL, fileFormat string, format DumpFormat) Dump {
+	return Dump{
+		runsURL:    runsURL,
+		fileFormat: fileFormat,
+		baseURL:    "",
+		wiki:       "",
+		format:     format,
+	}
+}
+
+// Format returns the format of the dump file.
+func (d Dump) Format() DumpFormat {
+	return d.format
+}
+
+// FileType returns the file type of the dump file, to be used with Process.
+func (d Dump) FileType() FileType {
+	return dumpFormats[d.format].fileType
+}
+
+// Compression returns the compression of the dump file, to be used with Process.
+func (d Dump) Compression() Compression {
+	return dumpFormats[d.format].compression
+}
+
+// Runs returns all runs of the dump in which its file is available,
+// ordered from the oldest to the newest.
+//
+// It makes a request for every run, so it is slower than Latest and Run.
+func (d Dump) Runs(ctx context.Context, client *retryablehttp.Client) ([]DumpRun, errors.E) {
+	dates, errE := listRuns(ctx, client, d.runsURL)
+	if errE != nil {
+		return nil, errE
+	}
+
+	result := []DumpRun{}
+	for _, date := range dates {
+		run, errE := d.run(ctx, client, date)
+		if errors.Is(errE, ErrNotFound) {
+			continue
+		} else if errE != nil {
+			return nil, errE
+		}
+		result = append(result, run)
+	}
+	return result, nil
+}
+
+// Run returns the run of the dump on the date.
+//
+// It returns ErrNotFound if there is no such run or if the file is not available in it.
+// Error details contain the reason (e.g., the status of the job producing the file).
+func (d Dump) Run(ctx context.Context, client *retryablehttp.Client, date time.Time) (DumpRun, errors.E) {
+	return d.run(ctx, client, date.Format(runDateFormat))
+}
+
+// Latest returns the latest run of the dump in which its file is available.
+func (d Dump) Latest(ctx context.Context, client *retryablehttp.Client) (DumpRun, errors.E) {
+	if d.wiki != "" {
+		run, errE := d.indexLatest(ctx, client)
+		if errE == nil {
+			return run, nil
+		}
+		// Otherwise we fall back to enumerating runs from the directory listing.
+	}
+
+	dates, errE := listRuns(ctx, client, d.runsURL)
+	if errE != nil {
+		return DumpRun{}, errE
+	}
+
+	// We start with the last run.
+	for i := len(dates) - 1; i >= 0; i-- {
+		run, errE := d.run(ctx, client, dates[i])
+		if errors.Is(errE, ErrNotFound) {
+			continue
+		} else if errE != nil {
+			return DumpRun{}, errE
+		}
+		return run, nil
+	}
+
+	return DumpRun{}, errors.WithDetails(ErrNotFound, "url", d.runsURL)
+}
+
+// indexLatest returns the latest run of the dump based on index.json of the dumps site.
+// It returns ErrNotFound if the file is not available in the latest run.
+func (d Dump) indexLatest(ctx context.Context, client *retryablehttp.Client) (DumpRun, errors.E) {
+	index, errE := fetchDumpIndex(ctx, client, d.baseURL)
+	if errE != nil {
+		return DumpRun{}, errE
+	}
+	status, ok := index.Wikis[d.wiki]
+	if !ok {
+		return DumpRun{}, errors.WithDetails(ErrNotFound, "url", d.baseURL+"/index.json", "wiki", d.wiki)
+	}
+	date, ok := status.date()
+	if !ok {
+		return DumpRun{}, errors.WithDetails(ErrNotFound, "url", d.baseURL+"/index.json", "wiki", d.wiki)
+	}
+	runDate, err := time.Parse(runDateFormat, date)
+	if err != nil {
+		errE := errors.WithMessage(err, "run date")
+		errors.Details(errE)["url"] = d.baseURL + "/index.json"
+		errors.Details(errE)["date"] = date
+		return DumpRun{}, errE
+	}
+	url, errE := d.statusFileURL(&status, date)
+	if errE != nil {
+		return DumpRun{}, errE
+	}
+	return DumpRun{
+		Date: runDate,
+		URL:  url,
+	}, nil
+}
+
+// latestURL returns URL of the file in the latest run of the dump.
+func (d Dump) latestURL(ctx context.Context, client *retryablehttp.Client) (string, errors.E) {
+	run, errE := d.Latest(ctx, client)
+	if errE != nil {
+		return "", errE
+	}
+	return run.URL, nil
+}
+
+func (d Dump) run(ctx context.Context, client *retryablehttp.Client, date string) (DumpRun, errors.E) {
+	runDate, err := time.Parse(runDateFormat, date)
+	if err != nil {
+		errE := errors.WithMessage(err, "run date")
+		errors.Details(errE)["url"] = d.runsURL
+		errors.Details(errE)["date"] = date
+		return DumpRun{}, errE
+	}
+
+	var url string
+	var errE errors.E
+	if d.wiki != "" {
+		url, errE = d.completedFileURL(ctx, client, date)
+	} else {
+		url, errE = d.existingFileURL(ctx, client, date)
+	}
+	if errE != nil {
+		return DumpRun{}, errE
+	}
+	return DumpRun{
+		Date: runDate,
+		URL:  url,
+	}, nil
+}
+
+// completedFileURL returns URL of the file in the run on the date
+// if the job producing the file has completed, based on run's dumpstatus.json.
+// Other jobs of the run do not have to be completed.
+func (d Dump) completedFileURL(ctx context.Context, client *retryablehttp.Client, date string) (string, errors.E) {
+	status, errE := fetchDumpStatus(ctx, client, d.baseURL, d.wiki, date)
+	if errE != nil {
+		errors.Details(errE)["date"] = date
+		return "", errE
+	}
+	return d.statusFileURL(status, date)
+}
+
+// statusFileURL returns URL of the file in the run on the date with status
+// if the job producing the file has completed.
+func (d Dump) statusFileURL(status *DumpStatus, date string) (string, errors.E) {
+	name := fmt.Sprintf(d.fileFormat, d.wiki, date)
+	jobName, job, file, ok := status.File(name)
+	if !ok {
+		return "", errors.WithDetails(ErrNotFound, "url", d.runsURL+date+"/", "date", date, "file", name)
+	}
+	if job.Status != JobDone {
+		return "", errors.WithDetails(
+			ErrNotFound,
+			"url", d.runsURL+date+"/",
+			"date", date,
+			"file", name,
+			"job", jobName,
+			"status", job.Status,
+		)
+	}
+	if file.URL != "" {
+		return d.baseURL + file.URL, nil
+	}
+	return fmt.Sprintf("%s%s/%s", d.runsURL, date, name), nil
+}
+
+// existingFileURL returns URL of the file in the run on the date if the file exists.
+func (d Dump) existingFileURL(ctx context.Context, client *retryablehttp.Client, date string) (string, errors.E) {
+	url := fmt.Sprintf(d.fileFormat, date, date)
+
+	// It can happen that the file is missing in the dump directory. So we check.
+	req, err := retryablehttp.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		errE := errors.WithMessage(err, "new request")
+		errors.Details(errE)["url"] = url
+		return "", errE
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		errE := errors.WithMessage(err, "head")
+		errors.Details(errE)["url"] = url
+		return "", errE
+	}
+	defer resp.Body.Close()
+	defer io.Copy(io.Discard, resp.Body) //nolint:errcheck
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.WithDetails(ErrNotFound, "url", url, "date", date, "status", resp.Status)
+	}
+	return url, nil
+}
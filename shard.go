@@ -0,0 +1,43 @@
+package mediawiki
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+var sqlInsertPrefix = []byte("INSERT")
+
+// inShard returns true if the row with the line number belongs to the shard.
+//
+// Rows are assigned to shards round-robin by their line number, after decompression.
+// For SQL dumps, only
+// INSERT statements are sharded. Other statements (e.g., CREATE TABLE which provides
+// column names) are passed on in all shards.
+func inShard(shard, shards int, fileType FileType, lineNumber int, row []byte) bool {
+	if shards <= 1 {
+		return true
+	}
+	if passThrough(fileType, row) {
+		return true
+	}
+	return (lineNumber-1)%shards == shard
+}
+
+// passThrough returns true if the row has to be passed on in every shard and also when
+// resuming from a checkpoint after it. Those are SQL statements other than INSERT (e.g.,
+// CREATE TABLE which provides column names), which have no items.
+func passThrough(fileType FileType, row []byte) bool {
+	return fileType == SQLDump && !bytes.HasPrefix(bytes.TrimLeft(row, " \t\r\n"), sqlInsertPrefix)
+}
+
+// shardCheckpointFile returns the checkpoint file name for the shard,
+// e.g., "checkpoint.shard-2-of-8.json" for "checkpoint.json".
+func shardCheckpointFile(checkpointFile string, shard, shards int) string {
+	if shards <= 1 {
+		return checkpointFile
+	}
+	ext := filepath.Ext(checkpointFile)
+	return fmt.Sprintf("%s.shard-%d-of-%d%s", strings.TrimSuffix(checkpointFile, ext), shard, shards, ext)
+}
@@ -0,0 +1,421 @@
+package mediawiki
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-retryablehttp"
+	"gitlab.com/tozd/go/errors"
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	// MediaWiki stores most timestamps in binary(14) columns.
+	mediaWikiTimestampFormat = "20060102150405"
+	// Some tables use SQL timestamp columns.
+	sqlTimestampFormat = "2006-01-02 15:04:05"
+)
+
+// PageRow is a row of the page table.
+// See: https://www.mediawiki.org/wiki/Manual:Page_table
+//
+// Title is converted from its database form by replacing underscores with spaces.
+type PageRow struct {
+	ID                 int64      `json:"id"`
+	Namespace          int        `json:"namespace"`
+	Title              string     `json:"title"`
+	IsRedirect         bool       `json:"is_redirect,omitempty"`
+	IsNew              bool       `json:"is_new,omitempty"`
+	Random             float64    `json:"random"`
+	Touched            time.Time  `json:"touched"`
+	LinksUpdated       *time.Time `json:"links_updated,omitempty"`
+	Latest             int64      `json:"latest"`
+	Length             int64      `json:"length"`
+	ContentModel       string     `json:"content_model,omitempty"`
+	Language           string     `json:"language,omitempty"`
+	LegacyRestrictions string     `json:"restrictions,omitempty"`
+}
+
+// PageLinkRow is a row of the pagelinks table.
+// See: https://www.mediawiki.org/wiki/Manual:Pagelinks_table
+//
+// Current dumps have only From and TargetID set. TargetID references a row of the linktarget
+// table (see LinkTargetRow) with the namespace and title of the link target.
+// Older dumps have FromNamespace, Namespace, and Title set instead of TargetID.
+type PageLinkRow struct {
+	From          int64  `json:"from"`
+	FromNamespace int    `json:"from_namespace"`
+	TargetID      int64  `json:"target_id,omitempty"`
+	Namespace     int    `json:"namespace,omitempty"`
+	Title         string `json:"title,omitempty"`
+}
+
+// LinkTargetRow is a row of the linktarget table, which is referenced by
+// TargetID of PageLinkRow.
+// See: https://www.mediawiki.org/wiki/Manual:Linktarget_table
+type LinkTargetRow struct {
+	ID        int64  `json:"id"`
+	Namespace int    `json:"namespace"`
+	Title     string `json:"title"`
+}
+
+// CategoryLinkRow is a row of the categorylinks table.
+// See: https://www.mediawiki.org/wiki/Manual:Categorylinks_table
+//
+// Type is one of "page", "subcat", or "file".
+//
+// SortKey is a binary sort key produced by the collation and is not normalized like
+// other strings. Because rows are decoded through JSON, its bytes which are not
+// valid UTF-8 are replaced with zero bytes (its length is preserved).
+type CategoryLinkRow struct {
+	From          int64     `json:"from"`
+	To            string    `json:"to"`
+	SortKey       string    `json:"sortkey"`
+	SortKeyPrefix string    `json:"sortkey_prefix,omitempty"`
+	Timestamp     time.Time `json:"timestamp"`
+	Collation     string    `json:"collation,omitempty"`
+	Type          string    `json:"type"`
+}
+
+// RedirectRow is a row of the redirect table.
+// See: https://www.mediawiki.org/wiki/Manual:Redirect_table
+type RedirectRow struct {
+	From      int64  `json:"from"`
+	Namespace int    `json:"namespace"`
+	Title     string `json:"title"`
+	Interwiki string `json:"interwiki,omitempty"`
+	Fragment  string `json:"fragment,omitempty"`
+}
+
+// LangLinkRow is a row of the langlinks table.
+// See: https://www.mediawiki.org/wiki/Manual:Langlinks_table
+type LangLinkRow struct {
+	From     int64  `json:"from"`
+	Language string `json:"language"`
+	Title    string `json:"title"`
+}
+
+// rowDecoder converts columns of a SQL row to Go values.
+// Missing columns and NULL values are converted to zero values,
+// so that rows from dumps with older or newer schemas can be decoded.
+// The first error is stored and all following conversions are skipped.
+type rowDecoder struct {
+	row  map[string]json.RawMessage
+	errE errors.E
+}
+
+func (d *rowDecoder) raw(column string) json.RawMessage {
+	if d.errE != nil {
+		return nil
+	}
+	value, ok := d.row[column]
+	if !ok || bytes.Equal(value, []byte("null")) {
+		return nil
+	}
+	return value
+}
+
+func (d *rowDecoder) fail(column string, value json.RawMessage, err error) {
+	errE := errors.WithMessage(err, "column")
+	errors.Details(errE)["column"] = column
+	errors.Details(errE)["value"] = string(value)
+	d.errE = errE
+}
+
+// String returns the value of a text column, normalized to NFC.
+func (d *rowDecoder) String(column string) string {
+	return norm.NFC.String(d.Binary(column))
+}
+
+// Binary returns the value of a binary column (e.g., a sort key) as-is.
+func (d *rowDecoder) Binary(column string) string {
+	value := d.raw(column)
+	if value == nil {
+		return ""
+	}
+	var s string
+	err := json.Unmarshal(value, &s)
+	if err != nil {
+		// Numeric values in string columns.
+		if _, errNum := strconv.ParseFloat(string(value), 64); errNum == nil {
+			return string(value)
+		}
+		d.fail(column, value, err)
+		return ""
+	}
+	return s
+}
+
+func (d *rowDecoder) Title(column string) string {
+	return strings.ReplaceAll(d.String(column), "_", " ")
+}
+
+func (d *rowDecoder) Int64(column string) int64 {
+	value := d.raw(column)
+	if value == nil {
+		return 0
+	}
+	s := string(value)
+	if strings.HasPrefix(s, `"`) {
+		// Numbers stored in string columns.
+		err := json.Unmarshal(value, &s)
+		if err != nil {
+			d.fail(column, value, err)
+			return 0
+		}
+	}
+	i, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		d.fail(column, value, err)
+		return 0
+	}
+	return i
+}
+
+func (d *rowDecoder) Int(column string) int {
+	return int(d.Int64(column))
+}
+
+func (d *rowDecoder) Bool(column string) bool {
+	return d.Int64(column) != 0
+}
+
+func (d *rowDecoder) Float64(column string) float64 {
+	value := d.raw(column)
+	if value == nil {
+		return 0
+	}
+	f, err := strconv.ParseFloat(string(value), 64)
+	if err != nil {
+		d.fail(column, value, err)
+		return 0
+	}
+	return f
+}
+
+func (d *rowDecoder) Timestamp(column string) *time.Time {
+	s := d.String(column)
+	if strings.Trim(s, "0") == "" {
+		return nil
+	}
+	format := mediaWikiTimestampFormat
+	if strings.Contains(s, "-") {
+		format = sqlTimestampFormat
+	}
+	t, err := time.Parse(format, s)
+	if err != nil {
+		d.fail(column, d.raw(column), errors.Prefix(err, ErrInvalidValue))
+		return nil
+	}
+	return &t
+}
+
+func (d *rowDecoder) Time(column string) time.Time {
+	t := d.Timestamp(column)
+	if t == nil {
+		return time.Time{}
+	}
+	return *t
+}
+
+func decodePageRow(d *rowDecoder) PageRow {
+	return PageRow{
+		ID:                 d.Int64("page_id"),
+		Namespace:          d.Int("page_namespace"),
+		Title:              d.Title("page_title"),
+		IsRedirect:         d.Bool("page_is_redirect"),
+		IsNew:              d.Bool("page_is_new"),
+		Random:             d.Float64("page_random"),
+		Touched:            d.Time("page_touched"),
+		LinksUpdated:       d.Timestamp("page_links_updated"),
+		Latest:             d.Int64("page_latest"),
+		Length:             d.Int64("page_len"),
+		ContentModel:       d.String("page_content_model"),
+		Language:           d.String("page_lang"),
+		LegacyRestrictions: d.String("page_restrictions"),
+	}
+}
+
+func decodePageLinkRow(d *rowDecoder) PageLinkRow {
+	return PageLinkRow{
+		From:          d.Int64("pl_from"),
+		FromNamespace: d.Int("pl_from_namespace"),
+		TargetID:      d.Int64("pl_target_id"),
+		Namespace:     d.Int("pl_namespace"),
+		Title:         d.Title("pl_title"),
+	}
+}
+
+func decodeLinkTargetRow(d *rowDecoder) LinkTargetRow {
+	return LinkTargetRow{
+		ID:        d.Int64("lt_id"),
+		Namespace: d.Int("lt_namespace"),
+		Title:     d.Title("lt_title"),
+	}
+}
+
+func decodeCategoryLinkRow(d *rowDecoder) CategoryLinkRow {
+	return CategoryLinkRow{
+		From:          d.Int64("cl_from"),
+		To:            d.Title("cl_to"),
+		SortKey:       d.Binary("cl_sortkey"),
+		SortKeyPrefix: d.String("cl_sortkey_prefix"),
+		Timestamp:     d.Time("cl_timestamp"),
+		Collation:     d.String("cl_collation"),
+		Type:          d.String("cl_type"),
+	}
+}
+
+func decodeRedirectRow(d *rowDecoder) RedirectRow {
+	return RedirectRow{
+		From:      d.Int64("rd_from"),
+		Namespace: d.Int("rd_namespace"),
+		Title:     d.Title("rd_title"),
+		Interwiki: d.String("rd_interwiki"),
+		Fragment:  d.String("rd_fragment"),
+	}
+}
+
+func decodeLangLinkRow(d *rowDecoder) LangLinkRow {
+	return LangLinkRow{
+		From:     d.Int64("ll_from"),
+		Language: d.String("ll_lang"),
+		Title:    d.String("ll_title"),
+	}
+}
+
+// WikipediaTableDump returns Wikipedia SQL dump of the table (e.g., "page", "pagelinks", "linktarget",
+// "categorylinks", "redirect", or "langlinks").
+// Use "enwiki" for English Wikipedia.
+func WikipediaTableDump(language, table string) Dump {
+	return tableDump(Wiki(language), table)
+}
+
+func latestTableRun(ctx context.Context, client *retryablehttp.Client, language, table string) (string, errors.E) {
+	return WikipediaTableDump(language, table).latestURL(ctx, client)
+}
+
+func processTableDump[R any](
+	ctx context.Context, config *ProcessDumpConfig,
+	decode func(*rowDecoder) R, processRow func(context.Context, R) errors.E,
+) errors.E {
+	return Process(ctx, &ProcessConfig[map[string]json.RawMessage]{
+		URL:                    config.URL,
+		Path:                   config.Path,
+		Client:                 config.Client,
+		DecompressionThreads:   config.DecompressionThreads,
+		DecodingThreads:        config.DecodingThreads,
+		ItemsProcessingThreads: config.ItemsProcessingThreads,
+		Process: func(ctx context.Context, row map[string]json.RawMessage) errors.E {
+			d := &rowDecoder{row: row, errE: nil}
+			r := decode(d)
+			if d.errE != nil {
+				return errors.Prefix(d.errE, ErrUnexpectedType)
+			}
+			return processRow(ctx, r)
+		},
+		Progress:         config.Progress,
+		Shard:            config.Shard,
+		Shards:           config.Shards,
+		Logger:           config.Logger,
+		Observer:         config.Observer,
+		Checksum:         config.Checksum,
+		CheckpointConfig: config.CheckpointConfig,
+		FileType:         SQLDump,
+		Compression:      GZIP,
+	})
+}
+
+// LatestWikipediaPageTableRun returns URL of the latest completed run of Wikipedia page table dump.
+// Use "enwiki" for English Wikipedia.
+func LatestWikipediaPageTableRun(ctx context.Context, client *retryablehttp.Client, language string) (string, errors.E) {
+	return latestTableRun(ctx, client, language, "page")
+}
+
+// LatestWikipediaPageLinksTableRun returns URL of the latest completed run of Wikipedia pagelinks table dump.
+// Use "enwiki" for English Wikipedia.
+func LatestWikipediaPageLinksTableRun(ctx context.Context, client *retryablehttp.Client, language string) (string, errors.E) {
+	return latestTableRun(ctx, client, language, "pagelinks")
+}
+
+// LatestWikipediaLinkTargetTableRun returns URL of the latest completed run of Wikipedia linktarget table dump.
+// Use "enwiki" for English Wikipedia.
+func LatestWikipediaLinkTargetTableRun(ctx context.Context, client *retryablehttp.Client, language string) (string, errors.E) {
+	return latestTableRun(ctx, client, language, "linktarget")
+}
+
+// LatestWikipediaCategoryLinksTableRun returns URL of the latest completed run of Wikipedia categorylinks table dump.
+// Use "enwiki" for English Wikipedia.
+func LatestWikipediaCategoryLinksTableRun(ctx context.Context, client *retryablehttp.Client, language string) (string, errors.E) {
+	return latestTableRun(ctx, client, language, "categorylinks")
+}
+
+// LatestWikipediaRedirectTableRun returns URL of the latest completed run of Wikipedia redirect table dump.
+// Use "enwiki" for English Wikipedia.
+func LatestWikipediaRedirectTableRun(ctx context.Context, client *retryablehttp.Client, language string) (string, errors.E) {
+	return latestTableRun(ctx, client, language, "redirect")
+}
+
+// LatestWikipediaLangLinksTableRun returns URL of the latest completed run of Wikipedia langlinks table dump.
+// Use "enwiki" for English Wikipedia.
+func LatestWikipediaLangLinksTableRun(ctx context.Context, client *retryablehttp.Client, language string) (string, errors.E) {
+	return latestTableRun(ctx, client, language, "langlinks")
+}
+
+// ProcessWikipediaPageTableDump downloads (unless already saved), decompresses, parses SQL,
+// and calls processRow on every row in a Wikipedia page table dump.
+func ProcessWikipediaPageTableDump(
+	ctx context.Context, config *ProcessDumpConfig,
+	processRow func(context.Context, PageRow) errors.E,
+) errors.E {
+	return processTableDump(ctx, config, decodePageRow, processRow)
+}
+
+// ProcessWikipediaPageLinksTableDump downloads (unless already saved), decompresses, parses SQL,
+// and calls processRow on every row in a Wikipedia pagelinks table dump.
+func ProcessWikipediaPageLinksTableDump(
+	ctx context.Context, config *ProcessDumpConfig,
+	processRow func(context.Context, PageLinkRow) errors.E,
+) errors.E {
+	return processTableDump(ctx, config, decodePageLinkRow, processRow)
+}
+
+// ProcessWikipediaLinkTargetTableDump downloads (unless already saved), decompresses, parses SQL,
+// and calls processRow on every row in a Wikipedia linktarget table dump.
+func ProcessWikipediaLinkTargetTableDump(
+	ctx context.Context, config *ProcessDumpConfig,
+	processRow func(context.Context, LinkTargetRow) errors.E,
+) errors.E {
+	return processTableDump(ctx, config, decodeLinkTargetRow, processRow)
+}
+
+// ProcessWikipediaCategoryLinksTableDump downloads (unless already saved), decompresses, parses SQL,
+// and calls processRow on every row in a Wikipedia categorylinks table dump.
+func ProcessWikipediaCategoryLinksTableDump(
+	ctx context.Context, config *ProcessDumpConfig,
+	processRow func(context.Context, CategoryLinkRow) errors.E,
+) errors.E {
+	return processTableDump(ctx, config, decodeCategoryLinkRow, processRow)
+}
+
+// ProcessWikipediaRedirectTableDump downloads (unless already saved), decompresses, parses SQL,
+// and calls processRow on every row in a Wikipedia redirect table dump.
+func ProcessWikipediaRedirectTableDump(
+	ctx context.Context, config *ProcessDumpConfig,
+	processRow func(context.Context, RedirectRow) errors.E,
+) errors.E {
+	return processTableDump(ctx, config, decodeRedirectRow, processRow)
+}
+
+// ProcessWikipediaLangLinksTableDump downloads (unless already saved), decompresses, parses SQL,
+// and calls processRow on every row in a Wikipedia langlinks table dump.
+func ProcessWikipediaLangLinksTableDump(
+	ctx context.Context, config *ProcessDumpConfig,
+	processRow func(context.Context, LangLinkRow) errors.E,
+) errors.E {
+	return processTableDump(ctx, config, decodeLangLinkRow, processRow)
+}
@@ -0,0 +1,260 @@
+package mediawiki_test
+
+import (
+	"compress/gzip"
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/go/errors"
+
+	"github.com/citadel2024/go-mediawiki"
+)
+
+const testPageTable = "-- MySQL dump 10.19\n" +
+	"/*!40101 SET @OLD_CHARACTER_SET_CLIENT=@@CHARACTER_SET_CLIENT */;\n" +
+	"DROP TABLE IF EXISTS `page`;\n" +
+	"CREATE TABLE `page` (\n" +
+	"  `page_id` int(10) unsigned NOT NULL AUTO_INCREMENT,\n" +
+	"  `page_namespace` int(11) NOT NULL DEFAULT 0,\n" +
+	"  `page_title` varbinary(255) NOT NULL DEFAULT '',\n" +
+	"  `page_is_redirect` tinyint(3) unsigned NOT NULL DEFAULT 0,\n" +
+	"  `page_is_new` tinyint(3) unsigned NOT NULL DEFAULT 0,\n" +
+	"  `page_random` double unsigned NOT NULL DEFAULT 0,\n" +
+	"  `page_touched` binary(14) NOT NULL,\n" +
+	"  `page_links_updated` varbinary(14) DEFAULT NULL,\n" +
+	"  `page_latest` int(10) unsigned NOT NULL DEFAULT 0,\n" +
+	"  `page_len` int(10) unsigned NOT NULL DEFAULT 0,\n" +
+	"  `page_content_model` varbinary(32) DEFAULT NULL,\n" +
+	"  `page_lang` varbinary(35) DEFAULT NULL,\n" +
+	"  PRIMARY KEY (`page_id`)\n" +
+	") ENGINE=InnoDB DEFAULT CHARSET=binary;\n" +
+	"INSERT INTO `page` VALUES (10,0,'AccessibleComputing',1,0,0.856935107283,'20240901085321','20240830021203',1219062925,111,'wikitext',NULL)," +
+	"(12,0,'Anarchism',0,0,0.786172332974311,'20240902150145',NULL,1243409396,110284,'wikitext',NULL);\n"
+
+const testCategoryLinksTable = "CREATE TABLE `categorylinks` (\n" +
+	"  `cl_from` int(8) unsigned NOT NULL DEFAULT 0,\n" +
+	"  `cl_to` varbinary(255) NOT NULL DEFAULT '',\n" +
+	"  `cl_sortkey` varbinary(230) NOT NULL DEFAULT '',\n" +
+	"  `cl_timestamp` timestamp NOT NULL DEFAULT current_timestamp(),\n" +
+	"  `cl_sortkey_prefix` varbinary(255) NOT NULL DEFAULT '',\n" +
+	"  `cl_collation` varbinary(32) NOT NULL DEFAULT '',\n" +
+	"  `cl_type` enum('page','subcat','file') NOT NULL DEFAULT 'page',\n" +
+	"  PRIMARY KEY (`cl_from`,`cl_to`)\n" +
+	") ENGINE=InnoDB DEFAULT CHARSET=binary;\n" +
+	"INSERT INTO `categorylinks` VALUES (12,'Anarchism','ANARCHISM','2023-05-01 10:20:30','','uca-default-u-kn','page')," +
+	"(12,'Political_ideologies','ANARCHISM','2022-01-02 03:04:05','','uca-default-u-kn','page')," +
+	// Sort key with a decomposed character, which has to be kept as-is.
+	"(13,'Cafe\u0301s','CAFE\u0301','2022-01-02 03:04:05','Cafe\u0301','uca-default-u-kn','subcat');\n"
+
+const testPageLinksTable = "CREATE TABLE `pagelinks` (\n" +
+	"  `pl_from` int(8) unsigned NOT NULL DEFAULT 0,\n" +
+	"  `pl_target_id` bigint(20) unsigned NOT NULL,\n" +
+	"  PRIMARY KEY (`pl_from`,`pl_target_id`)\n" +
+	") ENGINE=InnoDB DEFAULT CHARSET=binary;\n" +
+	"INSERT INTO `pagelinks` VALUES (10,1001),(12,1002);\n"
+
+const testOldPageLinksTable = "CREATE TABLE `pagelinks` (\n" +
+	"  `pl_from` int(8) unsigned NOT NULL DEFAULT 0,\n" +
+	"  `pl_namespace` int(11) NOT NULL DEFAULT 0,\n" +
+	"  `pl_title` varbinary(255) NOT NULL DEFAULT '',\n" +
+	"  `pl_from_namespace` int(11) NOT NULL DEFAULT 0,\n" +
+	"  PRIMARY KEY (`pl_from`,`pl_namespace`,`pl_title`)\n" +
+	") ENGINE=InnoDB DEFAULT CHARSET=binary;\n" +
+	"INSERT INTO `pagelinks` VALUES (10,0,'Computer_accessibility',0),(12,14,'Political_ideologies',0);\n"
+
+const testLinkTargetTable = "CREATE TABLE `linktarget` (\n" +
+	"  `lt_id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,\n" +
+	"  `lt_namespace` int(11) NOT NULL,\n" +
+	"  `lt_title` varbinary(255) NOT NULL,\n" +
+	"  PRIMARY KEY (`lt_id`)\n" +
+	") ENGINE=InnoDB DEFAULT CHARSET=binary;\n" +
+	"INSERT INTO `linktarget` VALUES (1001,0,'Computer_accessibility'),(1002,14,'Political_ideologies');\n"
+
+const testRedirectTable = "CREATE TABLE `redirect` (\n" +
+	"  `rd_from` int(8) unsigned NOT NULL DEFAULT 0,\n" +
+	"  `rd_namespace` int(11) NOT NULL DEFAULT 0,\n" +
+	"  `rd_title` varbinary(255) NOT NULL DEFAULT '',\n" +
+	"  `rd_interwiki` varbinary(32) DEFAULT NULL,\n" +
+	"  `rd_fragment` varbinary(255) DEFAULT NULL,\n" +
+	"  PRIMARY KEY (`rd_from`)\n" +
+	") ENGINE=InnoDB DEFAULT CHARSET=binary;\n" +
+	"INSERT INTO `redirect` VALUES (10,0,'Computer_accessibility','',''),(24,0,'Anarchism','','History');\n"
+
+const testLangLinksTable = "CREATE TABLE `langlinks` (\n" +
+	"  `ll_from` int(8) unsigned NOT NULL DEFAULT 0,\n" +
+	"  `ll_lang` varbinary(35) NOT NULL DEFAULT '',\n" +
+	"  `ll_title` varbinary(255) NOT NULL DEFAULT '',\n" +
+	"  PRIMARY KEY (`ll_from`,`ll_lang`)\n" +
+	") ENGINE=InnoDB DEFAULT CHARSET=binary;\n" +
+	"INSERT INTO `langlinks` VALUES (12,'de','Anarchismus'),(12,'sl','Anarhizem');\n"
+
+func writeGzip(t *testing.T, path, content string) {
+	t.Helper()
+
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	w := gzip.NewWriter(f)
+	_, err = w.Write([]byte(content))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	require.NoError(t, f.Close())
+}
+
+func TestProcessWikipediaPageTableDump(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "page.sql.gz")
+	writeGzip(t, path, testPageTable)
+
+	var mu sync.Mutex
+	rows := map[int64]mediawiki.PageRow{}
+
+	errE := mediawiki.ProcessWikipediaPageTableDump(
+		context.Background(),
+		&mediawiki.ProcessDumpConfig{
+			Path:             path,
+			CheckpointConfig: testCheckpointConfig(),
+		},
+		func(_ context.Context, r mediawiki.PageRow) errors.E {
+			mu.Lock()
+			defer mu.Unlock()
+			rows[r.ID] = r
+			return nil
+		},
+	)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	require.Len(t, rows, 2)
+
+	r := rows[10]
+	assert.Equal(t, "AccessibleComputing", r.Title)
+	assert.True(t, r.IsRedirect)
+	assert.False(t, r.IsNew)
+	assert.InDelta(t, 0.856935107283, r.Random, 1e-12)
+	assert.Equal(t, time.Date(2024, 9, 1, 8, 53, 21, 0, time.UTC), r.Touched)
+	require.NotNil(t, r.LinksUpdated)
+	assert.Equal(t, time.Date(2024, 8, 30, 2, 12, 3, 0, time.UTC), *r.LinksUpdated)
+	assert.Equal(t, int64(1219062925), r.Latest)
+	assert.Equal(t, int64(111), r.Length)
+	assert.Equal(t, "wikitext", r.ContentModel)
+	assert.Equal(t, "", r.Language)
+
+	r = rows[12]
+	assert.False(t, r.IsRedirect)
+	assert.Nil(t, r.LinksUpdated)
+}
+
+func TestProcessWikipediaCategoryLinksTableDump(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "categorylinks.sql.gz")
+	writeGzip(t, path, testCategoryLinksTable)
+
+	var mu sync.Mutex
+	rows := map[string]mediawiki.CategoryLinkRow{}
+
+	errE := mediawiki.ProcessWikipediaCategoryLinksTableDump(
+		context.Background(),
+		&mediawiki.ProcessDumpConfig{
+			Path:             path,
+			CheckpointConfig: testCheckpointConfig(),
+		},
+		func(_ context.Context, r mediawiki.CategoryLinkRow) errors.E {
+			mu.Lock()
+			defer mu.Unlock()
+			rows[r.To] = r
+			return nil
+		},
+	)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	require.Len(t, rows, 3)
+
+	r := rows["Political ideologies"]
+	assert.Equal(t, int64(12), r.From)
+	assert.Equal(t, "ANARCHISM", r.SortKey)
+	assert.Equal(t, time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC), r.Timestamp)
+	assert.Equal(t, "page", r.Type)
+
+	// Text columns are normalized, but the sort key is not.
+	r = rows["Caf\u00e9s"]
+	assert.Equal(t, "CAFE\u0301", r.SortKey)
+	assert.Equal(t, "Caf\u00e9", r.SortKeyPrefix)
+	assert.Equal(t, "subcat", r.Type)
+}
+
+// processTable processes the table dump with content using process and returns all rows.
+func processTable[R any](
+	t *testing.T, content string,
+	process func(context.Context, *mediawiki.ProcessDumpConfig, func(context.Context, R) errors.E) errors.E,
+) []R {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "table.sql.gz")
+	writeGzip(t, path, content)
+
+	var mu sync.Mutex
+	rows := []R{}
+
+	errE := process(
+		context.Background(),
+		&mediawiki.ProcessDumpConfig{
+			Path:                   path,
+			ItemsProcessingThreads: 1,
+			CheckpointConfig:       testCheckpointConfig(),
+		},
+		func(_ context.Context, r R) errors.E {
+			mu.Lock()
+			defer mu.Unlock()
+			rows = append(rows, r)
+			return nil
+		},
+	)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	return rows
+}
+
+func TestProcessWikipediaPageLinksTableDump(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, []mediawiki.PageLinkRow{
+		{From: 10, FromNamespace: 0, TargetID: 1001, Namespace: 0, Title: ""},
+		{From: 12, FromNamespace: 0, TargetID: 1002, Namespace: 0, Title: ""},
+	}, processTable(t, testPageLinksTable, mediawiki.ProcessWikipediaPageLinksTableDump))
+
+	assert.Equal(t, []mediawiki.PageLinkRow{
+		{From: 10, FromNamespace: 0, TargetID: 0, Namespace: 0, Title: "Computer accessibility"},
+		{From: 12, FromNamespace: 0, TargetID: 0, Namespace: 14, Title: "Political ideologies"},
+	}, processTable(t, testOldPageLinksTable, mediawiki.ProcessWikipediaPageLinksTableDump))
+}
+
+func TestProcessWikipediaLinkTargetTableDump(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, []mediawiki.LinkTargetRow{
+		{ID: 1001, Namespace: 0, Title: "Computer accessibility"},
+		{ID: 1002, Namespace: 14, Title: "Political ideologies"},
+	}, processTable(t, testLinkTargetTable, mediawiki.ProcessWikipediaLinkTargetTableDump))
+}
+
+func TestProcessWikipediaRedirectTableDump(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, []mediawiki.RedirectRow{
+		{From: 10, Namespace: 0, Title: "Computer accessibility", Interwiki: "", Fragment: ""},
+		{From: 24, Namespace: 0, Title: "Anarchism", Interwiki: "", Fragment: "History"},
+	}, processTable(t, testRedirectTable, mediawiki.ProcessWikipediaRedirectTableDump))
+}
+
+func TestProcessWikipediaLangLinksTableDump(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, []mediawiki.LangLinkRow{
+		{From: 12, Language: "de", Title: "Anarchismus"},
+		{From: 12, Language: "sl", Title: "Anarhizem"},
+	}, processTable(t, testLangLinksTable, mediawiki.ProcessWikipediaLangLinksTableDump))
+}
@@ -2,19 +2,30 @@ package mediawiki
 
 import (
 	"context"
+	"iter"
 
 	"github.com/hashicorp/go-retryablehttp"
 	"gitlab.com/tozd/go/errors"
 )
 
+// WikidataEntitiesDump returns Wikidata entities JSON dump.
+func WikidataEntitiesDump() Dump {
+	return entitiesDump(WikidataWiki, "wikidata", "all", JSONBZIP2Format)
+}
+
 // LatestWikidataEntitiesRun returns URL of the latest run of Wikidata entities JSON dump.
 func LatestWikidataEntitiesRun(ctx context.Context, client *retryablehttp.Client) (string, errors.E) {
-	return latestRun(
-		ctx,
-		client,
-		"https://dumps.wikimedia.org/wikidatawiki/entities/",
-		"https://dumps.wikimedia.org/wikidatawiki/entities/%s/wikidata-%s-all.json.bz2",
-	)
+	return WikidataEntitiesDump().latestURL(ctx, client)
+}
+
+// WikidataPagesLoggingDump returns Wikidata pages-logging XML dump.
+func WikidataPagesLoggingDump() Dump {
+	return xmlDump(WikidataWiki, PagesLoggingKind, XMLGZIPFormat)
+}
+
+// LatestWikidataPagesLoggingRun returns URL of the latest completed run of Wikidata pages-logging XML dump.
+func LatestWikidataPagesLoggingRun(ctx context.Context, client *retryablehttp.Client) (string, errors.E) {
+	return WikidataPagesLoggingDump().latestURL(ctx, client)
 }
 
 // ProcessWikidataDump downloads (unless already saves), decompresses, decodes JSON,
@@ -32,7 +43,69 @@ func ProcessWikidataDump(
 		ItemsProcessingThreads: config.ItemsProcessingThreads,
 		Process:                processEntity,
 		Progress:               config.Progress,
+		Shard:                  config.Shard,
+		Shards:                 config.Shards,
+		Logger:                 config.Logger,
+		Observer:               config.Observer,
+		Checksum:               config.Checksum,
+		CheckpointConfig:       config.CheckpointConfig,
+		FileType:               JSONArray,
+		Compression:            BZIP2,
+	})
+}
+
+// WikidataEntities returns an iterator over all entities in a Wikidata entities JSON dump.
+// See Items for details.
+func WikidataEntities(ctx context.Context, config *ProcessDumpConfig) iter.Seq2[Entity, error] {
+	return items(ctx, func(ctx context.Context, process func(context.Context, Entity) errors.E) errors.E {
+		c := *config
+		c.CheckpointConfig = iterationCheckpointConfig(config.CheckpointConfig)
+		return ProcessWikidataDump(ctx, &c, process)
+	})
+}
+
+// WikidataLexemesDump returns Wikidata lexemes JSON dump.
+func WikidataLexemesDump() Dump {
+	return entitiesDump(WikidataWiki, "wikidata", "lexemes", JSONBZIP2Format)
+}
+
+// LatestWikidataLexemesRun returns URL of the latest run of Wikidata lexemes JSON dump.
+func LatestWikidataLexemesRun(ctx context.Context, client *retryablehttp.Client) (string, errors.E) {
+	return WikidataLexemesDump().latestURL(ctx, client)
+}
+
+// ProcessWikidataLexemesDump downloads (unless already saved), decompresses, decodes JSON,
+// and calls processLexeme on every lexeme in a Wikidata lexemes JSON dump.
+func ProcessWikidataLexemesDump(
+	ctx context.Context, config *ProcessDumpConfig,
+	processLexeme func(context.Context, Lexeme) errors.E,
+) errors.E {
+	return Process(ctx, &ProcessConfig[Lexeme]{
+		URL:                    config.URL,
+		Path:                   config.Path,
+		Client:                 config.Client,
+		DecompressionThreads:   config.DecompressionThreads,
+		DecodingThreads:        config.DecodingThreads,
+		ItemsProcessingThreads: config.ItemsProcessingThreads,
+		Process:                processLexeme,
+		Progress:               config.Progress,
+		Shard:                  config.Shard,
+		Shards:                 config.Shards,
+		Logger:                 config.Logger,
+		Observer:               config.Observer,
+		Checksum:               config.Checksum,
+		CheckpointConfig:       config.CheckpointConfig,
 		FileType:               JSONArray,
 		Compression:            BZIP2,
 	})
 }
+
+// WikidataLexemes returns an iterator over all lexemes in a Wikidata lexemes JSON dump.
+// See Items for details.
+func WikidataLexemes(ctx context.Context, config *ProcessDumpConfig) iter.Seq2[Lexeme, error] {
+	return items(ctx, func(ctx context.Context, process func(context.Context, Lexeme) errors.E) errors.E {
+		c := *config
+		c.CheckpointConfig = iterationCheckpointConfig(config.CheckpointConfig)
+		return ProcessWikidataLexemesDump(ctx, &c, process)
+	})
+}
@@ -46,9 +46,10 @@ func TestProcessWikidataDumpLatest(t *testing.T) {
 	errE = mediawiki.ProcessWikidataDump(
 		ctx,
 		&mediawiki.ProcessDumpConfig{
-			URL:    url,
-			Path:   dumpPath,
-			Client: client,
+			URL:              url,
+			Path:             dumpPath,
+			Client:           client,
+			CheckpointConfig: testCheckpointConfig(),
 		},
 		func(_ context.Context, a mediawiki.Entity) errors.E {
 			atomic.AddInt64(&entityCounter, int64(1))
@@ -95,9 +96,10 @@ func TestProcessWikidataDumpExplicit(t *testing.T) {
 	errE := mediawiki.ProcessWikidataDump(
 		context.Background(),
 		&mediawiki.ProcessDumpConfig{
-			URL:    wikidataTestDump,
-			Path:   dumpPath,
-			Client: client,
+			URL:              wikidataTestDump,
+			Path:             dumpPath,
+			Client:           client,
+			CheckpointConfig: testCheckpointConfig(),
 		},
 		func(_ context.Context, a mediawiki.Entity) errors.E {
 			atomic.AddInt64(&entityCounter, int64(1))
@@ -135,7 +137,8 @@ func TestProcessWikidataDumpExplicit(t *testing.T) {
 	errE = mediawiki.ProcessWikidataDump(
 		context.Background(),
 		&mediawiki.ProcessDumpConfig{
-			Path: dumpPath,
+			Path:             dumpPath,
+			CheckpointConfig: testCheckpointConfig(),
 		},
 		func(_ context.Context, a mediawiki.Entity) errors.E {
 			atomic.AddInt64(&entityCounter, int64(1))
@@ -2,34 +2,70 @@ package mediawiki
 
 import (
 	"context"
-	"fmt"
+	"iter"
 
 	"github.com/hashicorp/go-retryablehttp"
 	"gitlab.com/tozd/go/errors"
 )
 
+// WikipediaEnterpriseHTMLDump returns Wikimedia Enterprise HTML dump of the namespace.
+// Use "enwiki" for English Wikipedia and namespace 0 for its articles.
+func WikipediaEnterpriseHTMLDump(language string, namespace int) Dump {
+	return enterpriseHTMLDump(Wiki(language), namespace)
+}
+
 // LatestWikipediaRun returns URL of the latest run of Wikimedia Enterprise HTML dump.
 // Use "enwiki" for English Wikipedia and namespace 0 for its articles.
 func LatestWikipediaRun(ctx context.Context, client *retryablehttp.Client, language string, namespace int) (string, errors.E) {
-	format := fmt.Sprintf("https://dumps.wikimedia.org/other/enterprise_html/runs/%%s/%s-NS%d-%%s-ENTERPRISE-HTML.json.tar.gz", language, namespace)
-	return latestRun(
-		ctx,
-		client,
-		"https://dumps.wikimedia.org/other/enterprise_html/runs/",
-		format,
-	)
+	return WikipediaEnterpriseHTMLDump(language, namespace).latestURL(ctx, client)
+}
+
+// WikipediaImageMetadataDump returns Wikipedia image table dump.
+// Use "enwiki" for English Wikipedia.
+func WikipediaImageMetadataDump(language string) Dump {
+	return tableDump(Wiki(language), "image")
 }
 
-// LatestWikipediaImageMetadataRun returns URL of the latest run of Wikipedia image table dump.
+// LatestWikipediaImageMetadataRun returns URL of the latest completed run of Wikipedia image table dump.
 // Use "enwiki" for English Wikipedia.
 func LatestWikipediaImageMetadataRun(ctx context.Context, client *retryablehttp.Client, language string) (string, errors.E) {
-	format := fmt.Sprintf("https://dumps.wikimedia.org/enwiki/%%s/%s-%%s-image.sql.gz", language)
-	return latestRun(
-		ctx,
-		client,
-		fmt.Sprintf("https://dumps.wikimedia.org/%s/", language),
-		format,
-	)
+	return WikipediaImageMetadataDump(language).latestURL(ctx, client)
+}
+
+// WikipediaPagesArticlesDump returns Wikipedia pages-articles XML dump.
+// Use "enwiki" for English Wikipedia.
+func WikipediaPagesArticlesDump(language string) Dump {
+	return xmlDump(Wiki(language), PagesArticlesKind, XMLBZIP2Format)
+}
+
+// LatestWikipediaPagesArticlesRun returns URL of the latest completed run of Wikipedia pages-articles XML dump.
+// Use "enwiki" for English Wikipedia.
+func LatestWikipediaPagesArticlesRun(ctx context.Context, client *retryablehttp.Client, language string) (string, errors.E) {
+	return WikipediaPagesArticlesDump(language).latestURL(ctx, client)
+}
+
+// WikipediaStubMetaHistoryDump returns Wikipedia stub-meta-history XML dump.
+// Use "enwiki" for English Wikipedia.
+func WikipediaStubMetaHistoryDump(language string) Dump {
+	return xmlDump(Wiki(language), StubMetaHistoryKind, XMLGZIPFormat)
+}
+
+// LatestWikipediaStubMetaHistoryRun returns URL of the latest completed run of Wikipedia stub-meta-history XML dump.
+// Use "enwiki" for English Wikipedia.
+func LatestWikipediaStubMetaHistoryRun(ctx context.Context, client *retryablehttp.Client, language string) (string, errors.E) {
+	return WikipediaStubMetaHistoryDump(language).latestURL(ctx, client)
+}
+
+// WikipediaPagesLoggingDump returns Wikipedia pages-logging XML dump.
+// Use "enwiki" for English Wikipedia.
+func WikipediaPagesLoggingDump(language string) Dump {
+	return xmlDump(Wiki(language), PagesLoggingKind, XMLGZIPFormat)
+}
+
+// LatestWikipediaPagesLoggingRun returns URL of the latest completed run of Wikipedia pages-logging XML dump.
+// Use "enwiki" for English Wikipedia.
+func LatestWikipediaPagesLoggingRun(ctx context.Context, client *retryablehttp.Client, language string) (string, errors.E) {
+	return WikipediaPagesLoggingDump(language).latestURL(ctx, client)
 }
 
 // ProcessWikipediaDump downloads (unless already saves), decompresses, decodes JSON,
@@ -47,7 +83,114 @@ func ProcessWikipediaDump(
 		ItemsProcessingThreads: config.ItemsProcessingThreads,
 		Process:                processArticle,
 		Progress:               config.Progress,
+		Shard:                  config.Shard,
+		Shards:                 config.Shards,
+		Logger:                 config.Logger,
+		Observer:               config.Observer,
+		Checksum:               config.Checksum,
+		CheckpointConfig:       config.CheckpointConfig,
 		FileType:               NDJSON,
 		Compression:            GZIPTar,
 	})
 }
+
+// WikipediaArticles returns an iterator over all articles in a Wikimedia Enterprise HTML dump.
+// See Items for details.
+func WikipediaArticles(ctx context.Context, config *ProcessDumpConfig) iter.Seq2[Article, error] {
+	return items(ctx, func(ctx context.Context, process func(context.Context, Article) errors.E) errors.E {
+		c := *config
+		c.CheckpointConfig = iterationCheckpointConfig(config.CheckpointConfig)
+		return ProcessWikipediaDump(ctx, &c, process)
+	})
+}
+
+// ProcessWikipediaPagesArticlesDump downloads (unless already saved), decompresses, decodes XML,
+// and calls processPage on every page in a Wikipedia pages-articles XML dump.
+func ProcessWikipediaPagesArticlesDump(
+	ctx context.Context, config *ProcessDumpConfig,
+	processPage func(context.Context, Page) errors.E,
+) errors.E {
+	return Process(ctx, &ProcessConfig[Page]{
+		URL:                    config.URL,
+		Path:                   config.Path,
+		Client:                 config.Client,
+		DecompressionThreads:   config.DecompressionThreads,
+		DecodingThreads:        config.DecodingThreads,
+		ItemsProcessingThreads: config.ItemsProcessingThreads,
+		Process:                processPage,
+		Progress:               config.Progress,
+		Shard:                  config.Shard,
+		Shards:                 config.Shards,
+		Logger:                 config.Logger,
+		Observer:               config.Observer,
+		Checksum:               config.Checksum,
+		CheckpointConfig:       config.CheckpointConfig,
+		FileType:               XML,
+		Compression:            BZIP2,
+	})
+}
+
+// WikipediaPagesArticles returns an iterator over all pages in a Wikipedia pages-articles XML dump.
+// See Items for details.
+func WikipediaPagesArticles(ctx context.Context, config *ProcessDumpConfig) iter.Seq2[Page, error] {
+	return items(ctx, func(ctx context.Context, process func(context.Context, Page) errors.E) errors.E {
+		c := *config
+		c.CheckpointConfig = iterationCheckpointConfig(config.CheckpointConfig)
+		return ProcessWikipediaPagesArticlesDump(ctx, &c, process)
+	})
+}
+
+// ProcessWikipediaHistoryDump downloads (unless already saved), decompresses, decodes XML,
+// and calls processRevision on every revision of every page in a Wikipedia stub-meta-history
+// or pages-meta-history XML dump. Page passed to processRevision has Revision field set
+// to the revision being processed.
+//
+// Revisions are streamed one by one (see XMLRevision file type), so memory usage does not
+// depend on the size of page histories. Revisions of a page are passed to processRevision
+// one after the other and in the order they are in the dump (by revision ID), while revisions
+// of different pages are processed in parallel by ItemsProcessingThreads goroutines
+// (see Ordered and Partition in ProcessConfig).
+//
+// Compression is detected automatically (stub history dumps are compressed with GZIP
+// and full history dumps with BZIP2).
+func ProcessWikipediaHistoryDump(
+	ctx context.Context, config *ProcessDumpConfig,
+	processRevision func(context.Context, Page) errors.E,
+) errors.E {
+	return Process(ctx, &ProcessConfig[Page]{
+		URL:                    config.URL,
+		Path:                   config.Path,
+		Client:                 config.Client,
+		DecompressionThreads:   config.DecompressionThreads,
+		DecodingThreads:        config.DecodingThreads,
+		ItemsProcessingThreads: config.ItemsProcessingThreads,
+		Process:                processRevision,
+		Progress:               config.Progress,
+		Shard:                  config.Shard,
+		Shards:                 config.Shards,
+		Logger:                 config.Logger,
+		Observer:               config.Observer,
+		Checksum:               config.Checksum,
+		CheckpointConfig:       config.CheckpointConfig,
+		Ordered:                true,
+		Partition:              pagePartition,
+		FileType:               XMLRevision,
+		Compression:            AutoCompression,
+	})
+}
+
+// pagePartition assigns revisions to goroutines by their page ID.
+func pagePartition(page Page) uint64 {
+	return uint64(page.ID) //nolint:gosec
+}
+
+// WikipediaHistory returns an iterator over all revisions of all pages in a Wikipedia
+// stub-meta-history or pages-meta-history XML dump. Page has Revision field set to the
+// revision. Revisions of a page are yielded in the order they are in the dump. See Items for details.
+func WikipediaHistory(ctx context.Context, config *ProcessDumpConfig) iter.Seq2[Page, error] {
+	return items(ctx, func(ctx context.Context, process func(context.Context, Page) errors.E) errors.E {
+		c := *config
+		c.CheckpointConfig = iterationCheckpointConfig(config.CheckpointConfig)
+		return ProcessWikipediaHistoryDump(ctx, &c, process)
+	})
+}
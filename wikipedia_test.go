@@ -46,9 +46,10 @@ func TestProcessWikipediaDumpLatest(t *testing.T) {
 	errE = mediawiki.ProcessWikipediaDump(
 		ctx,
 		&mediawiki.ProcessDumpConfig{
-			URL:    url,
-			Path:   dumpPath,
-			Client: client,
+			URL:              url,
+			Path:             dumpPath,
+			Client:           client,
+			CheckpointConfig: testCheckpointConfig(),
 		},
 		func(_ context.Context, a mediawiki.Article) errors.E {
 			atomic.AddInt64(&articleCounter, int64(1))
@@ -95,9 +96,10 @@ func TestProcessWikipediaDumpExplicit(t *testing.T) {
 	errE := mediawiki.ProcessWikipediaDump(
 		context.Background(),
 		&mediawiki.ProcessDumpConfig{
-			URL:    wikipediaTestDump,
-			Path:   dumpPath,
-			Client: client,
+			URL:              wikipediaTestDump,
+			Path:             dumpPath,
+			Client:           client,
+			CheckpointConfig: testCheckpointConfig(),
 		},
 		func(_ context.Context, a mediawiki.Article) errors.E {
 			atomic.AddInt64(&articleCounter, int64(1))
@@ -135,7 +137,8 @@ func TestProcessWikipediaDumpExplicit(t *testing.T) {
 	errE = mediawiki.ProcessWikipediaDump(
 		context.Background(),
 		&mediawiki.ProcessDumpConfig{
-			Path: dumpPath,
+			Path:             dumpPath,
+			CheckpointConfig: testCheckpointConfig(),
 		},
 		func(_ context.Context, a mediawiki.Article) errors.E {
 			atomic.AddInt64(&articleCounter, int64(1))